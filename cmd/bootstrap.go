@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dovics/wx-demo/pkg/admin/model"
+	"github.com/dovics/wx-demo/util/migrate"
+	"github.com/dovics/wx-demo/util/salt"
+)
+
+var (
+	errBootstrapUsage = errors.New(`usage:
+	bootstrap-admin <username>  create the first admin account, the password is read from stdin`)
+	errAdminExists  = errors.New("an active admin already exists, create accounts through /api/admin/v1/account/insert")
+	errWeakPassword = fmt.Errorf("the password needs at least %d characters", model.MinPasswordLength)
+)
+
+// runBootstrapAdmin creates the first admin account of the back office named
+// args[0]. It refuses once there is an active admin, the inactive admins moved
+// from user roles need one to set their password.
+func runBootstrapAdmin(db *sql.DB, args []string) error {
+	if len(args) != 1 {
+		return errBootstrapUsage
+	}
+
+	if err := migrate.Up(db, modules...); err != nil {
+		return err
+	}
+
+	admins, err := model.CountRole(db, model.RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if admins > 0 {
+		return errAdminExists
+	}
+
+	fmt.Print("password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	password = strings.TrimRight(password, "\r\n")
+	if len(password) < model.MinPasswordLength {
+		return errWeakPassword
+	}
+
+	digest, err := salt.Generate(&password)
+	if err != nil {
+		return err
+	}
+
+	id, err := model.CreateAccount(db, args[0], digest, model.RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("admin %s created with id %d\n", args[0], id)
+	return nil
+}
@@ -4,13 +4,18 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"os"
 
 	c "github.com/dovics/wx-demo/config"
+	admin "github.com/dovics/wx-demo/pkg/admin/controller"
+	adminmodel "github.com/dovics/wx-demo/pkg/admin/model"
 	cart "github.com/dovics/wx-demo/pkg/cart/controller"
 	goods "github.com/dovics/wx-demo/pkg/goods/controller"
+	order "github.com/dovics/wx-demo/pkg/order/controller"
 	user "github.com/dovics/wx-demo/pkg/user/controller"
 
 	"github.com/dovics/wx-demo/util/config"
+	"github.com/dovics/wx-demo/util/migrate"
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -20,12 +25,19 @@ func init() {
 }
 
 var (
-	userRouterGroup        = "/api/v1/user"
-	spuRouterGroup         = "/api/v1/spu"
-	categoryRouterGroup    = "/api/v1/category"
-	cartRouterGroup        = "/api/v1/cart"
-	userRouterGroupLogin   = userRouterGroup + "/login"
-	userRouterRefreshToken = userRouterGroup + "/refresh_token"
+	userRouterGroup         = "/api/v1/user"
+	spuRouterGroup          = "/api/v1/spu"
+	categoryRouterGroup     = "/api/v1/category"
+	cartRouterGroup         = "/api/v1/cart"
+	orderRouterGroup        = "/api/v1/order"
+	userRouterGroupLogin    = userRouterGroup + "/login"
+	userRouterRefreshToken  = userRouterGroup + "/refresh_token"
+	orderRouterPayNotify    = orderRouterGroup + "/pay/notify"
+	orderRouterRefundNotify = orderRouterGroup + "/refund/notify"
+
+	adminRouterGroup        = "/api/admin/v1"
+	adminRouterLogin        = adminRouterGroup + "/login"
+	adminRouterRefreshToken = adminRouterGroup + "/refresh_token"
 )
 
 func main() {
@@ -44,21 +56,65 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "migrate":
+			err = runMigrate(dbConn, os.Args[2:])
+		case "bootstrap-admin":
+			err = runBootstrapAdmin(dbConn, os.Args[2:])
+		case "reconcile-inventory":
+			err = runReconcileInventory(dbConn, os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %q", os.Args[1])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := migrate.Up(dbConn, modules...); err != nil {
+		log.Fatal(err)
+	}
+
 	router := gin.Default()
 
 	userController := user.New(dbConn)
 	spuController := goods.NewSpuController(dbConn)
 	categoryController := goods.NewCatagoryController(dbConn)
 	cartController := cart.New(dbConn)
-	router.POST(userRouterGroupLogin, userController.JWT.LoginHandler)
-	router.POST(userRouterRefreshToken, userController.JWT.RefreshHandler)
-
-	router.Use(userController.JWT.MiddlewareFunc())
-	router.Use(userController.CheckActive())
-	userController.RegisterRouter(router.Group(userRouterGroup))
-	spuController.RegisterRouter(router.Group(spuRouterGroup))
-	categoryController.RegisterRouter(router.Group(categoryRouterGroup))
-	cartController.RegisterRouter(router.Group(cartRouterGroup))
+	orderController := order.New(dbConn)
+	adminController := admin.New(dbConn)
+	router.POST(userRouterGroupLogin, userController.LoginHandler)
+	router.POST(userRouterRefreshToken, userController.RefreshHandler)
+	router.POST(orderRouterPayNotify, orderController.PayNotify)
+	router.POST(orderRouterRefundNotify, orderController.RefundNotify)
+
+	router.POST(adminRouterLogin, adminController.JWT.LoginHandler)
+	router.POST(adminRouterRefreshToken, adminController.JWT.RefreshHandler)
+
+	// the mini-program and the back office are different realms, a token of
+	// one is never accepted by the other.
+	customer := userController.JWT.MiddlewareFunc()
+	userController.RegisterRouter(router.Group(userRouterGroup, customer))
+	spuController.RegisterRouter(router.Group(spuRouterGroup, customer))
+	categoryController.RegisterRouter(router.Group(categoryRouterGroup, customer))
+	cartController.RegisterRouter(router.Group(cartRouterGroup, customer))
+	orderController.RegisterRouter(router.Group(orderRouterGroup, customer))
+
+	backOffice := router.Group(adminRouterGroup, adminController.JWT.MiddlewareFunc(), adminController.CheckActive())
+	operator := backOffice.Group("", adminController.RequireRole(adminmodel.RoleOperator))
+	superuser := backOffice.Group("", adminController.RequireRole(adminmodel.RoleAdmin))
+	adminController.RegisterRouter(backOffice.Group("/account"))
+	adminController.RegisterManageRouter(superuser.Group("/account"))
+	userController.RegisterManageRouter(superuser.Group("/user"))
+	spuController.RegisterRouter(operator.Group("/spu"))
+	spuController.RegisterManageRouter(operator.Group("/spu"))
+	categoryController.RegisterRouter(operator.Group("/category"))
+	categoryController.RegisterManageRouter(operator.Group("/category"))
+	orderController.RegisterManageRouter(operator.Group("/order"))
 
 	fmt.Println("port" + config.GetString("app.port"))
 	log.Fatal(router.Run("0.0.0.0:" + config.GetString("app.port")))
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	admin "github.com/dovics/wx-demo/pkg/admin/model"
+	cart "github.com/dovics/wx-demo/pkg/cart/model"
+	goods "github.com/dovics/wx-demo/pkg/goods/model"
+	order "github.com/dovics/wx-demo/pkg/order/model"
+	user "github.com/dovics/wx-demo/pkg/user/model"
+	"github.com/dovics/wx-demo/util/migrate"
+)
+
+// modules is in dependency order: cart and order read goods tables.
+var modules = []migrate.Module{
+	user.Migrations,
+	goods.Migrations,
+	cart.Migrations,
+	order.Migrations,
+	admin.Migrations,
+}
+
+const migrateUsage = `usage:
+	migrate up                    apply all pending migrations
+	migrate down <module> [steps] revert the last steps (default 1) migrations of module
+	migrate force <module> <version>
+	                              record version as applied and clear the dirty flag
+	                              after a failed migration was repaired by hand
+	migrate status                print the version of every module`
+
+var errMigrateUsage = errors.New(migrateUsage)
+
+// runMigrate runs the migrate subcommand with args after "migrate".
+func runMigrate(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	switch args[0] {
+	case "up":
+		return migrate.Up(db, modules...)
+	case "down":
+		if len(args) < 2 || len(args) > 3 {
+			return errMigrateUsage
+		}
+
+		steps := 1
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 1 {
+				return errMigrateUsage
+			}
+			steps = n
+		}
+
+		m, err := findModule(args[1])
+		if err != nil {
+			return err
+		}
+
+		return migrate.Down(db, m, steps)
+	case "force":
+		if len(args) != 3 {
+			return errMigrateUsage
+		}
+
+		version, err := strconv.ParseUint(args[2], 10, 32)
+		if err != nil {
+			return errMigrateUsage
+		}
+
+		m, err := findModule(args[1])
+		if err != nil {
+			return err
+		}
+
+		return migrate.Force(db, m, uint32(version))
+	case "status":
+		status, err := migrate.Info(db, modules...)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range status {
+			dirty := ""
+			if s.Dirty {
+				dirty = " (dirty)"
+			}
+			fmt.Printf("%-8s %d/%d%s\n", s.Module, s.Version, s.Latest, dirty)
+		}
+
+		return nil
+	default:
+		return errMigrateUsage
+	}
+}
+
+func findModule(name string) (migrate.Module, error) {
+	for _, m := range modules {
+		if m.Name == name {
+			return m, nil
+		}
+	}
+
+	return migrate.Module{}, fmt.Errorf("unknown module %q", name)
+}
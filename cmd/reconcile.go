@@ -0,0 +1,32 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/dovics/wx-demo/pkg/goods/model"
+	"github.com/dovics/wx-demo/util/migrate"
+)
+
+var errReconcileUsage = errors.New(`usage:
+	reconcile-inventory  set the inventory of every spu with skus to the sum of its sku stock`)
+
+// runReconcileInventory repairs the spu inventories drifted from their sku
+// stock. A spu without skus keeps its own inventory.
+func runReconcileInventory(db *sql.DB, args []string) error {
+	if len(args) != 0 {
+		return errReconcileUsage
+	}
+
+	if err := migrate.Up(db, modules...); err != nil {
+		return err
+	}
+
+	if err := model.ReconcileInventory(db); err != nil {
+		return err
+	}
+
+	fmt.Println("inventory reconciled")
+	return nil
+}
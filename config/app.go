@@ -10,8 +10,8 @@ func init() {
 		// debug mode
 		"debug": config.Env("APP_DEBUG", false),
 		// port
-		"port": config.Env("APP_PORT", "3000"),
+		"port": config.Env("APP_PORT", "8000"),
 		// BaseUrl
-		"url": config.Env("APP_URL", "http://localhost:3000"),
+		"url": config.Env("APP_URL", "http://localhost:8000"),
 	})
 }
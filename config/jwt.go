@@ -0,0 +1,36 @@
+package config
+
+import "github.com/dovics/wx-demo/util/config"
+
+func init() {
+	// algorithm is HS256/384/512, RS256/384/512, PS256/384/512 or ES256/384/512.
+	// key is the HMAC secret, or the PEM private key file for RSA and ECDSA.
+	// verify_keys are retired keys still accepted while rotating, as comma
+	// separated kid:alg:key or kid:key entries where key is the secret or the
+	// public key file. alg defaults to algorithm, set it when rotating to
+	// another algorithm, e.g. "2021:HS256:old secret" with RS256.
+	config.Add("jwt", config.StrMap{
+		// tokens of the mini-program
+		"user": map[string]interface{}{
+			"algorithm":   config.Env("JWT_ALGORITHM", "HS256"),
+			"kid":         config.Env("JWT_KID", ""),
+			"key":         config.Env("JWT_KEY", "moli-tech-cats-member"),
+			"verify_keys": config.Env("JWT_VERIFY_KEYS", ""),
+			"timeout":     config.Env("JWT_TIMEOUT", "15m"),
+			// lifetime of the refresh tokens, renewed on every refresh
+			"refresh_timeout": config.Env("JWT_REFRESH_TIMEOUT", "720h"),
+			// how long revocations made by other instances may take to apply
+			"revocation_cache": config.Env("JWT_REVOCATION_CACHE", "30s"),
+		},
+		// tokens of the back office, keep the key apart from the mini-program
+		// one so a customer token never opens the back office.
+		"admin": map[string]interface{}{
+			"algorithm":   config.Env("ADMIN_JWT_ALGORITHM", "HS256"),
+			"kid":         config.Env("ADMIN_JWT_KID", ""),
+			"key":         config.Env("ADMIN_JWT_KEY", "moli-tech-cats-admin"),
+			"verify_keys": config.Env("ADMIN_JWT_VERIFY_KEYS", ""),
+			"timeout":     config.Env("ADMIN_JWT_TIMEOUT", "12h"),
+			"max_refresh": config.Env("ADMIN_JWT_MAX_REFRESH", "24h"),
+		},
+	})
+}
@@ -6,5 +6,29 @@ func init() {
 	config.Add("wx", config.StrMap{
 		"appid":  config.Env("WX_APPID", ""),
 		"secret": config.Env("WX_SECRET", ""),
+		// point it at a local fake of the WeChat api in development
+		"base_url": config.Env("WX_BASE_URL", "https://api.weixin.qq.com"),
+		// jscode2session, retried when WeChat is busy or unreachable
+		"login": map[string]interface{}{
+			"timeout": config.Env("WX_LOGIN_TIMEOUT", "5s"),
+			"retries": config.Env("WX_LOGIN_RETRIES", 2),
+		},
+		// a user bound to a phone number can change it once per interval
+		"phone_rebind_interval": config.Env("WX_PHONE_REBIND_INTERVAL", "720h"),
+
+		// WeChat Pay v3, pay is disabled if mchid is empty
+		"pay": map[string]interface{}{
+			"base_url":  config.Env("WX_PAY_BASE_URL", "https://api.mch.weixin.qq.com"),
+			"mchid":     config.Env("WX_PAY_MCHID", ""),
+			"serial_no": config.Env("WX_PAY_SERIAL_NO", ""),
+			"apiv3_key": config.Env("WX_PAY_APIV3_KEY", ""),
+			// merchant private key pem file
+			"private_key": config.Env("WX_PAY_PRIVATE_KEY", ""),
+			// platform certificate or public key pem file
+			"platform_key": config.Env("WX_PAY_PLATFORM_KEY", ""),
+			"notify_url":   config.Env("WX_PAY_NOTIFY_URL", "http://localhost:8000/api/v1/order/pay/notify"),
+			"refund_notify_url": config.Env("WX_PAY_REFUND_NOTIFY_URL",
+				"http://localhost:8000/api/v1/order/refund/notify"),
+		},
 	})
 }
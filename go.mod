@@ -3,9 +3,9 @@ module github.com/dovics/wx-demo
 go 1.16
 
 require (
-	github.com/appleboy/gin-jwt/v2 v2.7.0
 	github.com/gin-gonic/gin v1.7.4
 	github.com/go-sql-driver/mysql v1.6.0
+	github.com/golang-jwt/jwt/v4 v4.1.0
 	github.com/sfreiberg/gotwilio v0.0.0-20201211181435-c426a3710ab5
 	github.com/spf13/cast v1.4.1
 	github.com/spf13/viper v1.9.0
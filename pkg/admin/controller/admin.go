@@ -0,0 +1,354 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/dovics/wx-demo/pkg/admin/model"
+	"github.com/dovics/wx-demo/util/jwt"
+	"github.com/dovics/wx-demo/util/salt"
+	"github.com/dovics/wx-demo/util/user"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errActive        = errors.New("the account is not activated")
+	errForbidden     = errors.New("the account has no permission")
+	errWeakPassword  = errors.New("the password is too short")
+	errWrongPassword = errors.New("the password is wrong")
+	errUsernameTaken = errors.New("the username is taken")
+	errModifySelf    = errors.New("an account can not change its own active or role")
+)
+
+// Controller external service interface of the back office.
+type Controller struct {
+	store model.Store
+	JWT   *jwt.Middleware
+}
+
+// New create an external service interface
+func New(db *sql.DB) *Controller {
+	return NewWithStore(model.NewMySQLStore(db))
+}
+
+// NewWithStore create an external service interface on store.
+func NewWithStore(store model.Store) *Controller {
+	c := &Controller{
+		store: store,
+	}
+	var err error
+	c.JWT, err = c.newJWTMiddleware()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return c
+}
+
+// RegisterRouter register the routes every account has on its own account.
+// It fatal because there is no service if register failed.
+func (c *Controller) RegisterRouter(r gin.IRouter) {
+	if r == nil {
+		log.Fatal("[InitRouter]: server is nil")
+	}
+
+	r.GET("/info", c.info)
+	r.POST("/modify/password", c.modifyPassword)
+}
+
+// RegisterManageRouter register the account management routes, r should only
+// be reachable by admins.
+func (c *Controller) RegisterManageRouter(r gin.IRouter) {
+	if r == nil {
+		log.Fatal("[InitRouter]: server is nil")
+	}
+
+	r.GET("/all", c.all)
+	r.POST("/insert", c.insert)
+	r.POST("/modify/active", c.modifyActive)
+	r.POST("/modify/role", c.modifyRole)
+	r.POST("/reset/password", c.resetPassword)
+}
+
+// Login JWT validation
+func (c *Controller) Login(ctx *gin.Context) (uint32, error) {
+	var req struct {
+		Username string `json:"username"  binding:"required"`
+		Password string `json:"password"  binding:"required"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		return 0, err
+	}
+
+	account, err := c.store.GetAccountByName(req.Username)
+	if err == sql.ErrNoRows {
+		return 0, jwt.ErrFailedAuthentication
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if !salt.Compare([]byte(account.Password), &req.Password) {
+		return 0, jwt.ErrFailedAuthentication
+	}
+
+	if !account.Active {
+		return 0, errActive
+	}
+
+	return account.ID, nil
+}
+
+func (c *Controller) info(ctx *gin.Context) {
+	id, err := user.GetAdminID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	account, err := c.store.GetAccount(id)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "info": account})
+}
+
+func (c *Controller) modifyPassword(ctx *gin.Context) {
+	var req struct {
+		OldPassword string `json:"old_password"    binding:"required"`
+		NewPassword string `json:"new_password"    binding:"required"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if len(req.NewPassword) < model.MinPasswordLength {
+		ctx.Error(errWeakPassword)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	id, err := user.GetAdminID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	account, err := c.store.GetAccount(id)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	if !salt.Compare([]byte(account.Password), &req.OldPassword) {
+		ctx.Error(errWrongPassword)
+		ctx.JSON(http.StatusForbidden, gin.H{"status": http.StatusForbidden})
+		return
+	}
+
+	digest, err := salt.Generate(&req.NewPassword)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError})
+		return
+	}
+
+	if err := c.store.ModifyPassword(id, digest); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
+
+func (c *Controller) all(ctx *gin.Context) {
+	accounts, err := c.store.AllAccount()
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "accounts": accounts})
+}
+
+func (c *Controller) insert(ctx *gin.Context) {
+	var req struct {
+		Username string     `json:"username"    binding:"required"`
+		Password string     `json:"password"    binding:"required"`
+		Role     model.Role `json:"role"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if req.Role == "" {
+		req.Role = model.RoleOperator
+	}
+
+	if !req.Role.Valid() {
+		ctx.Error(model.ErrInvalidRole)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if len(req.Password) < model.MinPasswordLength {
+		ctx.Error(errWeakPassword)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	_, err := c.store.GetAccountByName(req.Username)
+	if err == nil {
+		ctx.Error(errUsernameTaken)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict})
+		return
+	}
+	if err != sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	digest, err := salt.Generate(&req.Password)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError})
+		return
+	}
+
+	id, err := c.store.CreateAccount(req.Username, digest, req.Role)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "id": id})
+}
+
+func (c *Controller) modifyActive(ctx *gin.Context) {
+	var req struct {
+		CheckID     uint32 `json:"check_id"    binding:"required"`
+		CheckActive bool   `json:"check_active"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if !c.checkNotSelf(ctx, req.CheckID) {
+		return
+	}
+
+	c.respondModify(ctx, c.store.ModifyActive(req.CheckID, req.CheckActive))
+}
+
+func (c *Controller) modifyRole(ctx *gin.Context) {
+	var req struct {
+		CheckID uint32     `json:"check_id"    binding:"required"`
+		Role    model.Role `json:"role"        binding:"required"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if !c.checkNotSelf(ctx, req.CheckID) {
+		return
+	}
+
+	c.respondModify(ctx, c.store.ModifyRole(req.CheckID, req.Role))
+}
+
+// resetPassword sets the password of another account, e.g. one moved from a
+// user role, which has none.
+func (c *Controller) resetPassword(ctx *gin.Context) {
+	var req struct {
+		CheckID  uint32 `json:"check_id"    binding:"required"`
+		Password string `json:"password"    binding:"required"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if len(req.Password) < model.MinPasswordLength {
+		ctx.Error(errWeakPassword)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if !c.checkNotSelf(ctx, req.CheckID) {
+		return
+	}
+
+	digest, err := salt.Generate(&req.Password)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError})
+		return
+	}
+
+	c.respondModify(ctx, c.store.ModifyPassword(req.CheckID, digest))
+}
+
+// checkNotSelf refuses changes of the account to itself, so the last admin
+// can not lock everyone out of the back office.
+func (c *Controller) checkNotSelf(ctx *gin.Context, checkID uint32) bool {
+	id, err := user.GetAdminID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return false
+	}
+
+	if id == checkID {
+		ctx.Error(errModifySelf)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict})
+		return false
+	}
+
+	return true
+}
+
+func (c *Controller) respondModify(ctx *gin.Context, err error) {
+	if err == model.ErrInvalidRole {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
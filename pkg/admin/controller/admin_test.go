@@ -0,0 +1,280 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	// the default jwt realms
+	_ "github.com/dovics/wx-demo/config"
+	"github.com/dovics/wx-demo/pkg/admin/model"
+	"github.com/dovics/wx-demo/util/salt"
+	"github.com/gin-gonic/gin"
+)
+
+const testPassword = "correct horse"
+
+func newTestRouter(t *testing.T) (*gin.Engine, *model.MemoryStore) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := model.NewMemoryStore()
+	createAccount(t, store, "root", model.RoleAdmin)
+	createAccount(t, store, "clerk", model.RoleOperator)
+
+	c := NewWithStore(store)
+	r := gin.New()
+	r.POST("/api/admin/v1/login", c.JWT.LoginHandler)
+
+	backOffice := r.Group("/api/admin/v1", c.JWT.MiddlewareFunc(), c.CheckActive())
+	c.RegisterRouter(backOffice.Group("/account"))
+	c.RegisterManageRouter(backOffice.Group("/account", c.RequireRole(model.RoleAdmin)))
+
+	return r, store
+}
+
+func createAccount(t *testing.T, store *model.MemoryStore, username string, role model.Role) uint32 {
+	t.Helper()
+
+	password := testPassword
+	digest, err := salt.Generate(&password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := store.CreateAccount(username, digest, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func do(t *testing.T, r http.Handler, method, path, token string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func login(t *testing.T, r http.Handler, username, password string) string {
+	t.Helper()
+
+	w, resp := do(t, r, http.MethodPost, "/api/admin/v1/login", "", gin.H{"username": username, "password": password})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login of %s status = %d, body %s", username, w.Code, w.Body)
+	}
+
+	token, _ := resp["token"].(string)
+	if token == "" {
+		t.Fatalf("login returned no token: %s", w.Body)
+	}
+
+	return token
+}
+
+func TestLogin(t *testing.T) {
+	r, store := newTestRouter(t)
+	token := login(t, r, "root", testPassword)
+
+	for _, body := range []gin.H{
+		{"username": "root", "password": "wrong password"},
+		{"username": "nobody", "password": testPassword},
+		{"username": "root"},
+	} {
+		if w, _ := do(t, r, http.MethodPost, "/api/admin/v1/login", "", body); w.Code != http.StatusUnauthorized {
+			t.Errorf("login %v status = %d, want %d", body, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	w, resp := do(t, r, http.MethodGet, "/api/admin/v1/account/info", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("info status = %d, body %s", w.Code, w.Body)
+	}
+
+	info, _ := resp["info"].(map[string]interface{})
+	if info["Username"] != "root" || info["Role"] != string(model.RoleAdmin) {
+		t.Errorf("info = %v", info)
+	}
+	if _, ok := info["Password"]; ok {
+		t.Error("info exposes the password digest")
+	}
+
+	clerk, _ := store.GetAccountByName("clerk")
+	if err := store.ModifyActive(clerk.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if w, _ := do(t, r, http.MethodPost, "/api/admin/v1/login", "",
+		gin.H{"username": "clerk", "password": testPassword}); w.Code != http.StatusUnauthorized {
+		t.Errorf("login of disabled account status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	r, store := newTestRouter(t)
+	clerk := login(t, r, "clerk", testPassword)
+
+	if w, _ := do(t, r, http.MethodGet, "/api/admin/v1/account/all", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("all without token status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if w, _ := do(t, r, http.MethodGet, "/api/admin/v1/account/all", clerk, nil); w.Code != http.StatusForbidden {
+		t.Errorf("all by operator status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	account, _ := store.GetAccountByName("clerk")
+	if err := store.ModifyActive(account.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if w, _ := do(t, r, http.MethodGet, "/api/admin/v1/account/info", clerk, nil); w.Code != http.StatusLocked {
+		t.Errorf("info of disabled account status = %d, want %d", w.Code, http.StatusLocked)
+	}
+}
+
+func TestInsertAccount(t *testing.T) {
+	r, _ := newTestRouter(t)
+	root := login(t, r, "root", testPassword)
+
+	for _, tc := range []struct {
+		body gin.H
+		code int
+	}{
+		{gin.H{"username": "packer"}, http.StatusBadRequest},
+		{gin.H{"username": "packer", "password": "short"}, http.StatusBadRequest},
+		{gin.H{"username": "packer", "password": testPassword, "role": "customer"}, http.StatusBadRequest},
+		{gin.H{"username": "clerk", "password": testPassword}, http.StatusConflict},
+		{gin.H{"username": "packer", "password": testPassword}, http.StatusOK},
+	} {
+		if w, _ := do(t, r, http.MethodPost, "/api/admin/v1/account/insert", root, tc.body); w.Code != tc.code {
+			t.Errorf("insert %v status = %d, want %d", tc.body, w.Code, tc.code)
+		}
+	}
+
+	packer := login(t, r, "packer", testPassword)
+	w, resp := do(t, r, http.MethodGet, "/api/admin/v1/account/info", packer, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("info status = %d, body %s", w.Code, w.Body)
+	}
+	if info, _ := resp["info"].(map[string]interface{}); info["Role"] != string(model.RoleOperator) {
+		t.Errorf("role of new account = %v, want operator", info["Role"])
+	}
+}
+
+func TestModifyAccount(t *testing.T) {
+	r, store := newTestRouter(t)
+	root := login(t, r, "root", testPassword)
+	rootAccount, _ := store.GetAccountByName("root")
+	clerkAccount, _ := store.GetAccountByName("clerk")
+
+	for _, tc := range []struct {
+		path string
+		body gin.H
+		code int
+	}{
+		{"/api/admin/v1/account/modify/role", gin.H{"check_id": rootAccount.ID, "role": "operator"}, http.StatusConflict},
+		{"/api/admin/v1/account/modify/active", gin.H{"check_id": rootAccount.ID, "check_active": false}, http.StatusConflict},
+		{"/api/admin/v1/account/modify/role", gin.H{"check_id": clerkAccount.ID, "role": "root"}, http.StatusBadRequest},
+		{"/api/admin/v1/account/modify/role", gin.H{"check_id": 9999, "role": "admin"}, http.StatusNotFound},
+		{"/api/admin/v1/account/modify/role", gin.H{"check_id": clerkAccount.ID, "role": "admin"}, http.StatusOK},
+	} {
+		if w, _ := do(t, r, http.MethodPost, tc.path, root, tc.body); w.Code != tc.code {
+			t.Errorf("%s %v status = %d, want %d", tc.path, tc.body, w.Code, tc.code)
+		}
+	}
+
+	if account, _ := store.GetAccount(clerkAccount.ID); account.Role != model.RoleAdmin {
+		t.Errorf("role = %q, want %q", account.Role, model.RoleAdmin)
+	}
+}
+
+func TestModifyPassword(t *testing.T) {
+	r, _ := newTestRouter(t)
+	clerk := login(t, r, "clerk", testPassword)
+
+	if w, _ := do(t, r, http.MethodPost, "/api/admin/v1/account/modify/password", clerk,
+		gin.H{"old_password": "wrong password", "new_password": "battery staple"}); w.Code != http.StatusForbidden {
+		t.Errorf("modify with wrong password status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/admin/v1/account/modify/password", clerk,
+		gin.H{"old_password": testPassword, "new_password": "short"}); w.Code != http.StatusBadRequest {
+		t.Errorf("modify to short password status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w, _ := do(t, r, http.MethodPost, "/api/admin/v1/account/modify/password", clerk,
+		gin.H{"old_password": testPassword, "new_password": "battery staple"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("modify password status = %d, body %s", w.Code, w.Body)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/admin/v1/login", "",
+		gin.H{"username": "clerk", "password": testPassword}); w.Code != http.StatusUnauthorized {
+		t.Errorf("login with old password status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	login(t, r, "clerk", "battery staple")
+}
+
+func TestResetPassword(t *testing.T) {
+	r, store := newTestRouter(t)
+	root := login(t, r, "root", testPassword)
+	rootAccount, _ := store.GetAccountByName("root")
+
+	// an account moved from a user role has no password and is inactive.
+	moved, err := store.CreateAccount("user-1000", "", model.RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ModifyActive(moved, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		body gin.H
+		code int
+	}{
+		{gin.H{"check_id": rootAccount.ID, "password": "battery staple"}, http.StatusConflict},
+		{gin.H{"check_id": moved, "password": "short"}, http.StatusBadRequest},
+		{gin.H{"check_id": 9999, "password": "battery staple"}, http.StatusNotFound},
+		{gin.H{"check_id": moved, "password": "battery staple"}, http.StatusOK},
+	} {
+		if w, _ := do(t, r, http.MethodPost, "/api/admin/v1/account/reset/password", root, tc.body); w.Code != tc.code {
+			t.Errorf("reset %v status = %d, want %d", tc.body, w.Code, tc.code)
+		}
+	}
+
+	clerk := login(t, r, "clerk", testPassword)
+	if w, _ := do(t, r, http.MethodPost, "/api/admin/v1/account/reset/password", clerk,
+		gin.H{"check_id": moved, "password": "battery staple"}); w.Code != http.StatusForbidden {
+		t.Errorf("reset by operator status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/admin/v1/login", "",
+		gin.H{"username": "user-1000", "password": "battery staple"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("login of inactive account status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/admin/v1/account/modify/active", root,
+		gin.H{"check_id": moved, "check_active": true}); w.Code != http.StatusOK {
+		t.Fatalf("activate status = %d, body %s", w.Code, w.Body)
+	}
+	login(t, r, "user-1000", "battery staple")
+}
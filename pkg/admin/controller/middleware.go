@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dovics/wx-demo/pkg/admin/model"
+	"github.com/dovics/wx-demo/util/config"
+	"github.com/dovics/wx-demo/util/jwt"
+	"github.com/dovics/wx-demo/util/user"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CheckActive middleware that checks the account is still active, a disabled
+// account loses access even with a valid token.
+func (c *Controller) CheckActive() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		id, err := user.GetAdminID(ctx)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		account, err := c.store.GetAccount(id)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusConflict, err)
+			return
+		}
+
+		if !account.Active {
+			_ = ctx.AbortWithError(http.StatusLocked, errActive)
+			ctx.JSON(http.StatusLocked, gin.H{"status": http.StatusLocked})
+			return
+		}
+	}
+}
+
+// RequireRole middleware that refuses accounts without the permissions of
+// role. It must run after the JWT middleware.
+func (c *Controller) RequireRole(role model.Role) func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		id, err := user.GetAdminID(ctx)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		account, err := c.store.GetAccount(id)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusBadGateway, err)
+			return
+		}
+
+		if !account.Role.AtLeast(role) {
+			_ = ctx.AbortWithError(http.StatusForbidden, errForbidden)
+			ctx.JSON(http.StatusForbidden, gin.H{"status": http.StatusForbidden})
+			return
+		}
+	}
+}
+
+func (c *Controller) newJWTMiddleware() (*jwt.Middleware, error) {
+	keys, err := jwt.LoadKeySet("jwt.admin")
+	if err != nil {
+		return nil, err
+	}
+
+	return jwt.New(&jwt.Middleware{
+		Realm:       "test-pet-admin",
+		Keys:        keys,
+		Timeout:     config.GetDuration("jwt.admin.timeout"),
+		MaxRefresh:  config.GetDuration("jwt.admin.max_refresh"),
+		IdentityKey: "adminID",
+		PayloadFunc: func(data interface{}) jwt.MapClaims {
+			return jwt.MapClaims{
+				"adminID": data,
+			}
+		},
+		IdentityHandler: func(ctx *gin.Context) interface{} {
+			claims := jwt.ExtractClaims(ctx)
+			return claims["adminID"]
+		},
+		Authenticator: func(ctx *gin.Context) (interface{}, error) {
+			return c.Login(ctx)
+		},
+		// active and role are checked by the middlewares of the routes.
+		Authorizator: func(data interface{}, ctx *gin.Context) bool {
+			return true
+		},
+		Unauthorized: func(ctx *gin.Context, code int, message string) {
+			ctx.JSON(code, gin.H{
+				"code":    code,
+				"message": message,
+			})
+		},
+		// the back office is a web page, keep the token out of urls.
+		TokenLookup:   "header: Authorization, cookie: admin_jwt",
+		TokenHeadName: "Bearer",
+		TimeFunc:      time.Now,
+	})
+}
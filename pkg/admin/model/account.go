@@ -0,0 +1,193 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DBName    = "admin"
+	TableName = "account"
+
+	// MinPasswordLength is the shortest password accepted for an account.
+	MinPasswordLength = 8
+)
+
+const (
+	mysqlAccountCreateDatabase = iota
+	mysqlAccountCreateTable
+	mysqlAccountInsert
+	mysqlAccountGetByName
+	mysqlAccountGet
+	mysqlAccountAll
+	mysqlAccountModifyPassword
+	mysqlAccountModifyActive
+	mysqlAccountModifyRole
+	mysqlAccountCountRole
+)
+
+var (
+	errInvalidMysql = errors.New("affected 0 rows")
+
+	accountSQLString = []string{
+		fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s ;`, DBName),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+			id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+			username     	VARCHAR(64) UNIQUE NOT NULL,
+			password	 	VARCHAR(100) NOT NULL COMMENT 'bcrypt digest',
+			role			VARCHAR(16) NOT NULL DEFAULT "operator",
+			active   		BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (id)
+		) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, TableName),
+		fmt.Sprintf(`INSERT INTO %s.%s (username, password, role) VALUES (?,?,?)`, DBName, TableName),
+		fmt.Sprintf(`SELECT id, username, password, role, active, created_at FROM %s.%s WHERE username = ?`, DBName, TableName),
+		fmt.Sprintf(`SELECT id, username, password, role, active, created_at FROM %s.%s WHERE id = ?`, DBName, TableName),
+		fmt.Sprintf(`SELECT id, username, password, role, active, created_at FROM %s.%s ORDER BY id`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET password = ? WHERE id = ? LIMIT 1`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET active = ? WHERE id = ? LIMIT 1`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET role = ? WHERE id = ? LIMIT 1`, DBName, TableName),
+		fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s WHERE role = ? AND active = TRUE`, DBName, TableName),
+	}
+)
+
+// Account is a back office account. Password is the bcrypt digest and never
+// leaves the server.
+type Account struct {
+	ID        uint32
+	Username  string
+	Password  string `json:"-"`
+	Role      Role
+	Active    bool
+	CreatedAt time.Time
+}
+
+// CreateDatabase create admin database.
+func CreateDatabase(db *sql.DB) error {
+	_, err := db.Exec(accountSQLString[mysqlAccountCreateDatabase])
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateTable create account table.
+func CreateTable(db *sql.DB) error {
+	_, err := db.Exec(accountSQLString[mysqlAccountCreateTable])
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateAccount create an account with the bcrypt digest of its password.
+func CreateAccount(db *sql.DB, username, digest string, role Role) (uint32, error) {
+	if !role.Valid() {
+		return 0, ErrInvalidRole
+	}
+
+	result, err := db.Exec(accountSQLString[mysqlAccountInsert], username, digest, role)
+	if err != nil {
+		return 0, err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return 0, errInvalidMysql
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return uint32(id), nil
+}
+
+func scanAccount(row interface{ Scan(...interface{}) error }) (*Account, error) {
+	var a Account
+	if err := row.Scan(&a.ID, &a.Username, &a.Password, &a.Role, &a.Active, &a.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// GetAccountByName returns the account of username, sql.ErrNoRows if there is
+// no such account.
+func GetAccountByName(db *sql.DB, username string) (*Account, error) {
+	return scanAccount(db.QueryRow(accountSQLString[mysqlAccountGetByName], username))
+}
+
+// GetAccount returns the account of id, sql.ErrNoRows if there is no such
+// account.
+func GetAccount(db *sql.DB, id uint32) (*Account, error) {
+	return scanAccount(db.QueryRow(accountSQLString[mysqlAccountGet], id))
+}
+
+// AllAccount returns every account ordered by id.
+func AllAccount(db *sql.DB) ([]*Account, error) {
+	rows, err := db.Query(accountSQLString[mysqlAccountAll])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []*Account
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		accounts = append(accounts, a)
+	}
+
+	return accounts, rows.Err()
+}
+
+// ModifyPassword replace the password digest of the account.
+func ModifyPassword(db *sql.DB, id uint32, digest string) error {
+	return modify(db, mysqlAccountModifyPassword, id, digest)
+}
+
+// ModifyActive enables or disables the account.
+func ModifyActive(db *sql.DB, id uint32, active bool) error {
+	return modify(db, mysqlAccountModifyActive, id, active)
+}
+
+// ModifyRole sets the role of the account.
+func ModifyRole(db *sql.DB, id uint32, role Role) error {
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+
+	return modify(db, mysqlAccountModifyRole, id, role)
+}
+
+// modify runs the update statement of stmt, it returns sql.ErrNoRows if there
+// is no account id.
+func modify(db *sql.DB, stmt int, id uint32, value interface{}) error {
+	result, err := db.Exec(accountSQLString[stmt], value, id)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		// nothing changed if the account already has the value.
+		_, err := GetAccount(db, id)
+		return err
+	}
+
+	return nil
+}
+
+// CountRole returns the number of active accounts with the role.
+func CountRole(db *sql.DB, role Role) (int, error) {
+	var n int
+	err := db.QueryRow(accountSQLString[mysqlAccountCountRole], role).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,122 @@
+package model
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store kept in process memory, for tests.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	nextID   uint32
+	accounts map[uint32]*Account
+}
+
+// NewMemoryStore returns an empty MemoryStore. Ids start at 1000 like the
+// account table.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nextID:   1000,
+		accounts: make(map[uint32]*Account),
+	}
+}
+
+func (s *MemoryStore) CreateAccount(username, digest string, role Role) (uint32, error) {
+	if !role.Valid() {
+		return 0, ErrInvalidRole
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.accounts {
+		if a.Username == username {
+			return 0, errInvalidMysql
+		}
+	}
+
+	id := s.nextID
+	s.nextID++
+	s.accounts[id] = &Account{
+		ID:        id,
+		Username:  username,
+		Password:  digest,
+		Role:      role,
+		Active:    true,
+		CreatedAt: time.Now(),
+	}
+
+	return id, nil
+}
+
+func (s *MemoryStore) GetAccountByName(username string) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, a := range s.accounts {
+		if a.Username == username {
+			account := *a
+			return &account, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) GetAccount(id uint32) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.accounts[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	account := *a
+	return &account, nil
+}
+
+func (s *MemoryStore) AllAccount() ([]*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := make([]*Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		account := *a
+		accounts = append(accounts, &account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+
+	return accounts, nil
+}
+
+func (s *MemoryStore) ModifyPassword(id uint32, digest string) error {
+	return s.modify(id, func(a *Account) { a.Password = digest })
+}
+
+func (s *MemoryStore) ModifyActive(id uint32, active bool) error {
+	return s.modify(id, func(a *Account) { a.Active = active })
+}
+
+func (s *MemoryStore) ModifyRole(id uint32, role Role) error {
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+
+	return s.modify(id, func(a *Account) { a.Role = role })
+}
+
+func (s *MemoryStore) modify(id uint32, fn func(a *Account)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	fn(a)
+
+	return nil
+}
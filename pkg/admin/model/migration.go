@@ -0,0 +1,31 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/dovics/wx-demo/util/migrate"
+)
+
+// Migrations is the versioned schema of the admin module.
+var Migrations = migrate.Module{
+	Name: "admin",
+	Migrations: []migrate.Migration{
+		{
+			Version:     1,
+			Description: "create account table",
+			Up: migrate.Exec(
+				fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s`, DBName),
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+					id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+					username     	VARCHAR(64) UNIQUE NOT NULL,
+					password	 	VARCHAR(100) NOT NULL COMMENT 'bcrypt digest',
+					role			VARCHAR(16) NOT NULL DEFAULT "operator",
+					active   		BOOLEAN NOT NULL DEFAULT TRUE,
+					created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (id)
+				) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, TableName),
+			),
+			Down: migrate.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, TableName)),
+		},
+	},
+}
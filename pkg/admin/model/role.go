@@ -0,0 +1,31 @@
+package model
+
+import "errors"
+
+// Role grants access to the back office routes. Every role includes the
+// permissions of the roles before it.
+type Role string
+
+const (
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+var roleRank = map[Role]int{
+	RoleOperator: 1,
+	RoleAdmin:    2,
+}
+
+// ErrInvalidRole returned when a role is not one of the known roles.
+var ErrInvalidRole = errors.New("invalid role")
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	_, ok := roleRank[r]
+	return ok
+}
+
+// AtLeast reports whether r has the permissions of required.
+func (r Role) AtLeast(required Role) bool {
+	return r.Valid() && roleRank[r] >= roleRank[required]
+}
@@ -0,0 +1,51 @@
+package model
+
+import "database/sql"
+
+// Store is the persistence of admin accounts used by the controller.
+type Store interface {
+	CreateAccount(username, digest string, role Role) (uint32, error)
+	GetAccountByName(username string) (*Account, error)
+	GetAccount(id uint32) (*Account, error)
+	AllAccount() ([]*Account, error)
+	ModifyPassword(id uint32, digest string) error
+	ModifyActive(id uint32, active bool) error
+	ModifyRole(id uint32, role Role) error
+}
+
+type mysqlStore struct {
+	db *sql.DB
+}
+
+// NewMySQLStore returns the Store backed by the admin schema.
+func NewMySQLStore(db *sql.DB) Store {
+	return &mysqlStore{db: db}
+}
+
+func (s *mysqlStore) CreateAccount(username, digest string, role Role) (uint32, error) {
+	return CreateAccount(s.db, username, digest, role)
+}
+
+func (s *mysqlStore) GetAccountByName(username string) (*Account, error) {
+	return GetAccountByName(s.db, username)
+}
+
+func (s *mysqlStore) GetAccount(id uint32) (*Account, error) {
+	return GetAccount(s.db, id)
+}
+
+func (s *mysqlStore) AllAccount() ([]*Account, error) {
+	return AllAccount(s.db)
+}
+
+func (s *mysqlStore) ModifyPassword(id uint32, digest string) error {
+	return ModifyPassword(s.db, id, digest)
+}
+
+func (s *mysqlStore) ModifyActive(id uint32, active bool) error {
+	return ModifyActive(s.db, id, active)
+}
+
+func (s *mysqlStore) ModifyRole(id uint32, role Role) error {
+	return ModifyRole(s.db, id, role)
+}
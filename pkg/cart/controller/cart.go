@@ -2,6 +2,7 @@ package controller
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 
@@ -10,13 +11,19 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+var errEmptyIDs = errors.New("request should contain cart ids")
+
 type CartController struct {
-	db *sql.DB
+	store model.Store
 }
 
 func New(db *sql.DB) *CartController {
+	return NewWithStore(model.NewMySQLStore(db))
+}
+
+func NewWithStore(store model.Store) *CartController {
 	return &CartController{
-		db: db,
+		store: store,
 	}
 }
 
@@ -25,22 +32,16 @@ func (c *CartController) RegisterRouter(r gin.IRouter) {
 		log.Fatal("[InitRouter]: server is nil")
 	}
 
-	if err := model.CreateDatabase(c.db); err != nil {
-		log.Fatal(err)
-	}
-
-	if err := model.CreateCartTable(c.db); err != nil {
-		log.Fatal(err)
-	}
-
 	r.POST("/insert", c.insert)
 	r.GET("/info", c.info)
+	r.POST("/modify/count", c.modifyCount)
+	r.POST("/modify/active", c.modifyActive)
+	r.POST("/delete", c.delete)
 }
 
 func (c *CartController) insert(ctx *gin.Context) {
 	var req struct {
 		SkuID uint32 `json:"sku_id,omitempty"`
-		SpuID uint32 `json:"spu_id,omitempty"`
 		Count uint32 `json:"count,omitempty"`
 	}
 
@@ -57,7 +58,17 @@ func (c *CartController) insert(ctx *gin.Context) {
 		return
 	}
 
-	if err := model.InsertCart(c.db, userID, req.SkuID, req.SpuID, req.Count); err != nil {
+	if req.Count == 0 {
+		req.Count = 1
+	}
+
+	err = c.store.InsertCart(userID, req.SkuID, req.Count)
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
+	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
@@ -74,12 +85,120 @@ func (c *CartController) info(ctx *gin.Context) {
 		return
 	}
 
-	goods, err := model.InfoByUserID(c.db, userID)
+	goods, err := c.store.InfoByUserID(userID)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": goods, "summary": model.Summarize(goods)})
+}
+
+func (c *CartController) modifyCount(ctx *gin.Context) {
+	var req struct {
+		ID    uint32 `json:"id"       binding:"required"`
+		Count uint32 `json:"count"    binding:"required,min=1"`
+	}
+
+	userID, err := user.GetID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	err = c.store.ModifyCartCount(userID, req.ID, req.Count)
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
+
+func (c *CartController) modifyActive(ctx *gin.Context) {
+	var req struct {
+		IDs    []uint32 `json:"ids"`
+		Active bool     `json:"active"`
+	}
+
+	userID, err := user.GetID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if len(req.IDs) == 0 {
+		ctx.Error(errEmptyIDs)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	err = c.store.ModifyCartActive(userID, req.IDs, req.Active)
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
+
+func (c *CartController) delete(ctx *gin.Context) {
+	var req struct {
+		IDs []uint32 `json:"ids"`
+	}
+
+	userID, err := user.GetID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if len(req.IDs) == 0 {
+		ctx.Error(errEmptyIDs)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	deleted, err := c.store.DeleteCart(userID, req.IDs)
 	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": goods})
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "deleted": deleted})
 }
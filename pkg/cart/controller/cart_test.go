@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dovics/wx-demo/pkg/cart/model"
+	"github.com/dovics/wx-demo/util/money"
+	"github.com/gin-gonic/gin"
+)
+
+const testUserID = 1000
+
+func newTestRouter(t *testing.T) (*gin.Engine, *model.MemoryStore) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := model.NewMemoryStore()
+	store.PutSku(model.MemorySku{SkuID: 1, SpuID: 10, Title: "cat food", Spec: `{"size":"L"}`,
+		Price: money.New(1250), Stock: 5, Listed: true})
+	store.PutSku(model.MemorySku{SkuID: 2, SpuID: 11, Title: "cat toy", Price: money.New(300),
+		Stock: 0, Listed: true})
+
+	r := gin.New()
+	r.Use(func(ctx *gin.Context) {
+		ctx.Set("userID", float64(testUserID))
+	})
+	NewWithStore(store).RegisterRouter(r.Group("/api/v1/cart"))
+
+	return r, store
+}
+
+func do(t *testing.T, r http.Handler, method, path string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func cartRows(t *testing.T, store *model.MemoryStore) []*model.CartGoods {
+	t.Helper()
+
+	rows, err := store.InfoByUserID(testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rows
+}
+
+func TestInsert(t *testing.T) {
+	r, store := newTestRouter(t)
+
+	for _, body := range []gin.H{{"sku_id": 1}, {"sku_id": 1, "count": 2}} {
+		if w, _ := do(t, r, http.MethodPost, "/api/v1/cart/insert", body); w.Code != http.StatusOK {
+			t.Fatalf("insert %v status = %d, body %s", body, w.Code, w.Body)
+		}
+	}
+
+	rows := cartRows(t, store)
+	if len(rows) != 1 || rows[0].Count != 3 {
+		t.Fatalf("cart = %+v, want one row of count 3", rows)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/cart/insert", gin.H{"sku_id": 99}); w.Code != http.StatusNotFound {
+		t.Errorf("insert of unknown sku status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	r, store := newTestRouter(t)
+	do(t, r, http.MethodPost, "/api/v1/cart/insert", gin.H{"sku_id": 1, "count": 2})
+	do(t, r, http.MethodPost, "/api/v1/cart/insert", gin.H{"sku_id": 2})
+	store.PutSku(model.MemorySku{SkuID: 1, SpuID: 10, Title: "cat food", Spec: `{"size":"L"}`,
+		Price: money.New(1300), Stock: 5, Listed: true})
+
+	w, resp := do(t, r, http.MethodGet, "/api/v1/cart/info", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("info status = %d, body %s", w.Code, w.Body)
+	}
+
+	data, _ := resp["data"].([]interface{})
+	if len(data) != 2 {
+		t.Fatalf("info data = %v, want 2 rows", resp["data"])
+	}
+
+	statuses := make(map[float64]interface{})
+	for _, row := range data {
+		row := row.(map[string]interface{})
+		statuses[row["SkuID"].(float64)] = row["Status"]
+		if row["SkuID"] == float64(1) && row["PriceChanged"] != true {
+			t.Errorf("price change of sku 1 not reported: %v", row)
+		}
+	}
+	if statuses[1] != model.StatusAvailable || statuses[2] != model.StatusOutOfStock {
+		t.Errorf("statuses = %v", statuses)
+	}
+
+	summary, _ := resp["summary"].(map[string]interface{})
+	if summary["TotalPrice"] != 26.0 || summary["TotalCount"] != 2.0 {
+		t.Errorf("summary = %v, want 26.00 for 2", summary)
+	}
+}
+
+func TestModifyCount(t *testing.T) {
+	r, store := newTestRouter(t)
+	do(t, r, http.MethodPost, "/api/v1/cart/insert", gin.H{"sku_id": 1})
+	id := cartRows(t, store)[0].ID
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/cart/modify/count", gin.H{"id": id, "count": 0}); w.Code != http.StatusBadRequest {
+		t.Errorf("modify count to 0 status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/cart/modify/count", gin.H{"id": id, "count": 4}); w.Code != http.StatusOK {
+		t.Fatalf("modify count status = %d, body %s", w.Code, w.Body)
+	}
+
+	if rows := cartRows(t, store); rows[0].Count != 4 {
+		t.Errorf("count = %d, want 4", rows[0].Count)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/cart/modify/count", gin.H{"id": id, "count": 4}); w.Code != http.StatusOK {
+		t.Errorf("modify to the same count status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	other := foreignCartID(t, store)
+	for _, id := range []uint32{9999, other} {
+		if w, _ := do(t, r, http.MethodPost, "/api/v1/cart/modify/count", gin.H{"id": id, "count": 2}); w.Code != http.StatusNotFound {
+			t.Errorf("modify count of %d status = %d, want %d", id, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+// foreignCartID adds a cart row of another user and returns its id.
+func foreignCartID(t *testing.T, store *model.MemoryStore) uint32 {
+	t.Helper()
+
+	if err := store.InsertCart(testUserID+1, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := store.InfoByUserID(testUserID + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rows[0].ID
+}
+
+func TestModifyActive(t *testing.T) {
+	r, store := newTestRouter(t)
+	do(t, r, http.MethodPost, "/api/v1/cart/insert", gin.H{"sku_id": 1})
+	id := cartRows(t, store)[0].ID
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/cart/modify/active", gin.H{"active": false}); w.Code != http.StatusBadRequest {
+		t.Errorf("modify active without ids status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w, _ := do(t, r, http.MethodPost, "/api/v1/cart/modify/active", gin.H{"ids": []uint32{id}, "active": false})
+	if w.Code != http.StatusOK {
+		t.Fatalf("modify active status = %d, body %s", w.Code, w.Body)
+	}
+
+	if rows := cartRows(t, store); rows[0].Active {
+		t.Error("cart row is still active")
+	}
+
+	other := foreignCartID(t, store)
+	for _, ids := range [][]uint32{{id, 9999}, {id, other}} {
+		w, _ := do(t, r, http.MethodPost, "/api/v1/cart/modify/active", gin.H{"ids": ids, "active": true})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("modify active of %v status = %d, want %d", ids, w.Code, http.StatusNotFound)
+		}
+	}
+
+	if rows := cartRows(t, store); rows[0].Active {
+		t.Error("cart row was activated by a rejected request")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	r, store := newTestRouter(t)
+	do(t, r, http.MethodPost, "/api/v1/cart/insert", gin.H{"sku_id": 1})
+	do(t, r, http.MethodPost, "/api/v1/cart/insert", gin.H{"sku_id": 2})
+	rows := cartRows(t, store)
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/cart/delete", gin.H{}); w.Code != http.StatusBadRequest {
+		t.Errorf("delete without ids status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w, resp := do(t, r, http.MethodPost, "/api/v1/cart/delete", gin.H{"ids": []uint32{rows[0].ID, 9999}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete status = %d, body %s", w.Code, w.Body)
+	}
+	if resp["deleted"] != 1.0 {
+		t.Errorf("deleted = %v, want 1", resp["deleted"])
+	}
+
+	if rows := cartRows(t, store); len(rows) != 1 {
+		t.Errorf("cart has %d rows, want 1", len(rows))
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/dovics/wx-demo/util/money"
+	sqlutil "github.com/dovics/wx-demo/util/sql"
 )
 
 const (
@@ -16,8 +20,21 @@ const (
 	mysqlCartCreateTable
 	mysqlCartInsert
 	mysqlCartInfoByUserID
+	mysqlCartDeleteByID
+	mysqlCartModifyCount
+	mysqlCartDeleteByIDs
+	mysqlCartModifyActive
+	mysqlCartMergeDuplicate
+	mysqlCartDeleteDuplicate
+	mysqlCartHasUniqueIndex
+	mysqlCartAddUniqueIndex
+	mysqlCartCountBySpuID
+	mysqlCartCountBySkuID
+	mysqlCartCountByIDs
 )
 
+const uniqueIndexName = "user_sku_index"
+
 var (
 	errInvalidMysql = errors.New("affected 0 rows")
 
@@ -29,14 +46,39 @@ var (
 			sku_id			BIGINT NOT NULL,
 			spu_id			BIGINT NOT NULL,
 			count			INT NOT NULL DEFAULT 1,
+			price			BIGINT NOT NULL DEFAULT 0 COMMENT 'sku price in cents when added',
 
 			active   		BOOLEAN DEFAULT TRUE,
 			created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			PRIMARY KEY (id),
-			INDEX user_index (user_id)
-		)  ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, TableName),
-		fmt.Sprintf(`INSERT INTO %s.%s (user_id, sku_id, spu_id, count) VALUES (?, ?, ?, ?)`, DBName, TableName),
-		fmt.Sprintf(`SELECT id, sku_id, count, active FROM %s.%s WHERE user_id = ?`, DBName, TableName),
+			INDEX user_index (user_id),
+			UNIQUE INDEX %s (user_id, sku_id)
+		)  ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, TableName, uniqueIndexName),
+		fmt.Sprintf(`INSERT INTO %s.%s (user_id, sku_id, spu_id, count, price) 
+			SELECT ?, id, spu_id, ?, price FROM goods.sku WHERE id = ? 
+			ON DUPLICATE KEY UPDATE count = count + VALUES(count), price = VALUES(price), active = TRUE`,
+			DBName, TableName),
+		fmt.Sprintf(`SELECT cart.id, cart.sku_id, cart.spu_id, cart.count, cart.active, cart.price, 
+			COALESCE(spu.title, ""), COALESCE(JSON_UNQUOTE(JSON_EXTRACT(spu.images, '$[0]')), ""), 
+			COALESCE(sku.spec, ""), COALESCE(sku.price, 0), COALESCE(sku.stock, 0), 
+			sku.id IS NOT NULL AND COALESCE(spu.active, FALSE) 
+			FROM %s.%s LEFT JOIN goods.sku ON sku.id = cart.sku_id LEFT JOIN goods.spu ON spu.id = cart.spu_id 
+			WHERE cart.user_id = ? ORDER BY cart.id DESC`, DBName, TableName),
+		fmt.Sprintf(`DELETE FROM %s.%s WHERE id = ? AND user_id = ? LIMIT 1`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET count = ? WHERE id = ? AND user_id = ? LIMIT 1`, DBName, TableName),
+		fmt.Sprintf(`DELETE FROM %s.%s WHERE user_id = ? AND id IN (%%s)`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET active = ? WHERE user_id = ? AND id IN (%%s)`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s c JOIN (SELECT MIN(id) AS id, SUM(count) AS total FROM %s.%s 
+			GROUP BY user_id, sku_id HAVING COUNT(*) > 1) d ON c.id = d.id SET c.count = d.total`,
+			DBName, TableName, DBName, TableName),
+		fmt.Sprintf(`DELETE c FROM %s.%s c JOIN %s.%s k ON c.user_id = k.user_id AND c.sku_id = k.sku_id 
+			AND c.id > k.id`, DBName, TableName, DBName, TableName),
+		`SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = ? AND table_name = ? 
+			AND index_name = ?`,
+		fmt.Sprintf(`ALTER TABLE %s.%s ADD UNIQUE INDEX %s (user_id, sku_id)`, DBName, TableName, uniqueIndexName),
+		fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s WHERE spu_id = ?`, DBName, TableName),
+		fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s WHERE sku_id = ?`, DBName, TableName),
+		fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s WHERE user_id = ? AND id IN (%%s) FOR UPDATE`, DBName, TableName),
 	}
 )
 
@@ -60,8 +102,59 @@ func CreateCartTable(db *sql.DB) error {
 	return nil
 }
 
-func InsertCart(db *sql.DB, userID uint32, skuID uint32, spuID uint32, count uint32) error {
-	result, err := db.Exec(cartSQLString[mysqlCartInsert], userID, skuID, spuID, count)
+// MergeDuplicateCart merges the rows of the same sku of a user into the oldest
+// one, and adds the unique index of (user_id, sku_id) to tables created before it.
+func MergeDuplicateCart(db *sql.DB) error {
+	var n int
+	if err := db.QueryRow(cartSQLString[mysqlCartHasUniqueIndex], DBName, TableName, uniqueIndexName).Scan(&n); err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(cartSQLString[mysqlCartMergeDuplicate]); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(cartSQLString[mysqlCartDeleteDuplicate]); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(cartSQLString[mysqlCartAddUniqueIndex])
+	return err
+}
+
+// InsertCart adds the sku to the cart of the user with its current price, or
+// adds count to the row of the sku if it is already in the cart. The spu is
+// taken from the sku, it returns sql.ErrNoRows if there is no such sku.
+func InsertCart(db *sql.DB, userID uint32, skuID uint32, count uint32) error {
+	result, err := db.Exec(cartSQLString[mysqlCartInsert], userID, count, skuID)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// TxDeleteCartByID remove a cart row of the user
+func TxDeleteCartByID(tx *sql.Tx, userID uint32, id uint32) error {
+	result, err := tx.Exec(cartSQLString[mysqlCartDeleteByID], id, userID)
 	if err != nil {
 		return err
 	}
@@ -73,38 +166,221 @@ func InsertCart(db *sql.DB, userID uint32, skuID uint32, spuID uint32, count uin
 	return nil
 }
 
+// TxCountCartBySpuID returns the number of cart rows of the spu
+func TxCountCartBySpuID(tx *sql.Tx, spuID uint32) (int, error) {
+	var n int
+	err := tx.QueryRow(cartSQLString[mysqlCartCountBySpuID], spuID).Scan(&n)
+	return n, err
+}
+
+// TxCountCartBySkuID returns the number of cart rows of the sku
+func TxCountCartBySkuID(tx *sql.Tx, skuID uint32) (int, error) {
+	var n int
+	err := tx.QueryRow(cartSQLString[mysqlCartCountBySkuID], skuID).Scan(&n)
+	return n, err
+}
+
+// ModifyCartCount sets the count of a cart row of the user, it returns
+// sql.ErrNoRows if the user has no such row.
+func ModifyCartCount(db *sql.DB, userID uint32, id uint32, count uint32) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := txCheckCartIDs(tx, userID, []uint32{id}); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(cartSQLString[mysqlCartModifyCount], count, id, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteCart removes the cart rows of the user, it returns the number of removed rows.
+func DeleteCart(db *sql.DB, userID uint32, ids []uint32) (int64, error) {
+	result, err := db.Exec(fmt.Sprintf(cartSQLString[mysqlCartDeleteByIDs], placeholders(len(ids))),
+		append([]interface{}{userID}, toArgs(ids)...)...)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// ModifyCartActive selects or unselects the cart rows of the user, it returns
+// sql.ErrNoRows and changes nothing if any of ids is not a row of the user.
+func ModifyCartActive(db *sql.DB, userID uint32, ids []uint32, active bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := txCheckCartIDs(tx, userID, ids); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(cartSQLString[mysqlCartModifyActive], placeholders(len(ids))),
+		append([]interface{}{active, userID}, toArgs(ids)...)...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// txCheckCartIDs locks the cart rows of ids, it returns sql.ErrNoRows if any
+// of them is not a row of the user. The rows are counted rather than taken
+// from the affected rows of the update, which are 0 when nothing changes.
+func txCheckCartIDs(tx *sql.Tx, userID uint32, ids []uint32) error {
+	ids = uniqueIDs(ids)
+
+	var n int
+	if err := tx.QueryRow(fmt.Sprintf(cartSQLString[mysqlCartCountByIDs], placeholders(len(ids))),
+		append([]interface{}{userID}, toArgs(ids)...)...).Scan(&n); err != nil {
+		return err
+	}
+
+	if n != len(ids) {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func uniqueIDs(ids []uint32) []uint32 {
+	seen := make(map[uint32]bool, len(ids))
+	unique := make([]uint32, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+func placeholders(n int) string {
+	if n <= 0 {
+		return "NULL"
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func toArgs(ids []uint32) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+// Availability of a cart row.
+const (
+	StatusAvailable         = "available"
+	StatusOutOfStock        = "out_of_stock"
+	StatusInsufficientStock = "insufficient_stock"
+	StatusDelisted          = "delisted"
+)
+
+// CartGoods is a cart row with the current sku and spu.
 type CartGoods struct {
 	ID     uint32
 	SkuID  uint32
+	SpuID  uint32
 	Count  uint32
 	Active bool
+
+	Title    string
+	Image    string
+	Spec     string
+	Price    money.Money
+	Stock    uint32
+	InStock  bool
+	Subtotal money.Money
+
+	// Status is one of StatusAvailable, StatusOutOfStock,
+	// StatusInsufficientStock and StatusDelisted.
+	Status string
+	// AddedPrice is the price when the sku was added to the cart.
+	AddedPrice   money.Money
+	PriceChanged bool
+}
+
+// CartSummary is the total of the selected and available cart rows.
+type CartSummary struct {
+	TotalPrice money.Money
+	TotalCount uint32
 }
 
+// InfoByUserID returns the cart of the user joined with goods in one query,
+// each row is validated against the current sku and spu.
 func InfoByUserID(db *sql.DB, userID uint32) ([]*CartGoods, error) {
 	rows, err := db.Query(cartSQLString[mysqlCartInfoByUserID], userID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var result []*CartGoods
 	for rows.Next() {
 		var (
-			id     uint32
-			skuID  uint32
-			count  uint32
-			active bool
+			goods  CartGoods
+			listed bool
 		)
-		if err := rows.Scan(&id, &skuID, &count, &active); err != nil {
+		if err := rows.Scan(&goods.ID, &goods.SkuID, &goods.SpuID, &goods.Count, &goods.Active,
+			&goods.AddedPrice, &goods.Title, &goods.Image, &goods.Spec, &goods.Price, &goods.Stock,
+			&listed); err != nil {
 			return nil, err
 		}
 
-		result = append(result, &CartGoods{
-			ID:     id,
-			SkuID:  skuID,
-			Count:  count,
-			Active: active,
-		})
+		goods.validate(listed)
+		result = append(result, &goods)
 	}
 
-	return result, nil
+	return result, rows.Err()
+}
+
+// validate sets the status, subtotal and price change of the row from the
+// current sku, listed is false if the sku or its spu is gone or inactive.
+func (goods *CartGoods) validate(listed bool) {
+	switch {
+	case !listed:
+		goods.Status = StatusDelisted
+	case goods.Stock == 0:
+		goods.Status = StatusOutOfStock
+	case goods.Stock < goods.Count:
+		goods.Status = StatusInsufficientStock
+	default:
+		goods.Status = StatusAvailable
+	}
+
+	goods.InStock = goods.Status == StatusAvailable
+	goods.Subtotal = goods.Price.Mul(int64(goods.Count))
+	goods.PriceChanged = listed && goods.AddedPrice.Cmp(goods.Price) != 0
+}
+
+// Summarize returns the total of the selected rows.
+func Summarize(goods []*CartGoods) CartSummary {
+	var summary CartSummary
+	for _, g := range goods {
+		if !g.Active || g.Status != StatusAvailable {
+			continue
+		}
+
+		summary.TotalPrice = summary.TotalPrice.Add(g.Subtotal)
+		summary.TotalCount += g.Count
+	}
+
+	return summary
+}
+
+// MigrateMoney converts the DOUBLE price of cart tables created before money
+// was stored in cents.
+func MigrateMoney(db *sql.DB) error {
+	return sqlutil.ConvertDoubleToCents(db, DBName, TableName, "price",
+		"BIGINT NOT NULL DEFAULT 0 COMMENT 'sku price in cents when added'")
 }
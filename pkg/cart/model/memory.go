@@ -0,0 +1,172 @@
+package model
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+
+	"github.com/dovics/wx-demo/util/money"
+)
+
+// MemorySku is the goods of a sku as the MemoryStore sees them, in place of
+// the join with the goods schema.
+type MemorySku struct {
+	SkuID  uint32
+	SpuID  uint32
+	Title  string
+	Image  string
+	Spec   string
+	Price  money.Money
+	Stock  uint32
+	Listed bool
+}
+
+type memoryCart struct {
+	id     uint32
+	userID uint32
+	skuID  uint32
+	spuID  uint32
+	count  uint32
+	price  money.Money
+	active bool
+}
+
+// MemoryStore is a Store kept in process memory, for tests.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	nextID uint32
+	carts  map[uint32]*memoryCart
+	skus   map[uint32]MemorySku
+}
+
+// NewMemoryStore returns an empty MemoryStore. Ids start at 1000 like the
+// cart table.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nextID: 1000,
+		carts:  make(map[uint32]*memoryCart),
+		skus:   make(map[uint32]MemorySku),
+	}
+}
+
+// PutSku adds or replaces the goods of a sku.
+func (s *MemoryStore) PutSku(sku MemorySku) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.skus[sku.SkuID] = sku
+}
+
+func (s *MemoryStore) InsertCart(userID uint32, skuID uint32, count uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sku, ok := s.skus[skuID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	for _, c := range s.carts {
+		if c.userID == userID && c.skuID == skuID {
+			c.count += count
+			c.price = sku.Price
+			c.active = true
+			return nil
+		}
+	}
+
+	s.carts[s.nextID] = &memoryCart{
+		id:     s.nextID,
+		userID: userID,
+		skuID:  skuID,
+		spuID:  sku.SpuID,
+		count:  count,
+		price:  sku.Price,
+		active: true,
+	}
+	s.nextID++
+
+	return nil
+}
+
+func (s *MemoryStore) InfoByUserID(userID uint32) ([]*CartGoods, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*CartGoods
+	for _, c := range s.carts {
+		if c.userID != userID {
+			continue
+		}
+
+		sku, listed := s.skus[c.skuID]
+		goods := &CartGoods{
+			ID:         c.id,
+			SkuID:      c.skuID,
+			SpuID:      c.spuID,
+			Count:      c.count,
+			Active:     c.active,
+			AddedPrice: c.price,
+			Price:      money.New(0),
+		}
+		if listed {
+			goods.Title, goods.Image, goods.Spec = sku.Title, sku.Image, sku.Spec
+			goods.Price, goods.Stock = sku.Price, sku.Stock
+			listed = sku.Listed
+		}
+
+		goods.validate(listed)
+		result = append(result, goods)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID > result[j].ID
+	})
+
+	return result, nil
+}
+
+func (s *MemoryStore) ModifyCartCount(userID uint32, id uint32, count uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.carts[id]
+	if !ok || c.userID != userID {
+		return sql.ErrNoRows
+	}
+
+	c.count = count
+	return nil
+}
+
+func (s *MemoryStore) DeleteCart(userID uint32, ids []uint32) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for _, id := range ids {
+		if c, ok := s.carts[id]; ok && c.userID == userID {
+			delete(s.carts, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *MemoryStore) ModifyCartActive(userID uint32, ids []uint32, active bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if c, ok := s.carts[id]; !ok || c.userID != userID {
+			return sql.ErrNoRows
+		}
+	}
+
+	for _, id := range ids {
+		s.carts[id].active = active
+	}
+
+	return nil
+}
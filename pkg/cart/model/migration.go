@@ -0,0 +1,63 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/dovics/wx-demo/util/migrate"
+)
+
+// Migrations is the versioned schema of the cart module.
+var Migrations = migrate.Module{
+	Name: "cart",
+	Migrations: []migrate.Migration{
+		{
+			Version:     1,
+			Description: "create cart table",
+			Up: migrate.Exec(
+				fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s`, DBName),
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+					id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+					user_id			BIGINT NOT NULL,
+					sku_id			BIGINT NOT NULL,
+					spu_id			BIGINT NOT NULL,
+					count			INT NOT NULL DEFAULT 1,
+					active   		BOOLEAN DEFAULT TRUE,
+					created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (id),
+					INDEX user_index (user_id)
+				) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, TableName),
+			),
+			Down: migrate.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, TableName)),
+		},
+		{
+			// Carts of releases before the price snapshot have no price
+			// column, the ones between store it as DOUBLE. A sku used to get
+			// a row per add, they are merged before the unique index. Down
+			// keeps the merged rows.
+			Version:     2,
+			Description: "add cart price snapshot and one row per sku",
+			Up: func(db *sql.DB) error {
+				if err := migrate.AddColumn(db, DBName, TableName, "price",
+					"BIGINT NOT NULL DEFAULT 0 COMMENT 'sku price in cents when added' AFTER count"); err != nil {
+					return err
+				}
+
+				if err := MigrateMoney(db); err != nil {
+					return err
+				}
+
+				// rows added before the price column was snapshotted take the
+				// current sku price, so they are not reported as changed.
+				if _, err := db.Exec(fmt.Sprintf(`UPDATE %s.%s JOIN goods.sku ON sku.id = cart.sku_id
+					SET cart.price = sku.price WHERE cart.price = 0`, DBName, TableName)); err != nil {
+					return err
+				}
+
+				return MergeDuplicateCart(db)
+			},
+			Down: migrate.Exec(fmt.Sprintf(`ALTER TABLE %s.%s DROP INDEX %s, DROP COLUMN price`,
+				DBName, TableName, uniqueIndexName)),
+		},
+	},
+}
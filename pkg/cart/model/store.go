@@ -0,0 +1,41 @@
+package model
+
+import "database/sql"
+
+// Store is the persistence of carts used by the controller.
+type Store interface {
+	InsertCart(userID uint32, skuID uint32, count uint32) error
+	InfoByUserID(userID uint32) ([]*CartGoods, error)
+	ModifyCartCount(userID uint32, id uint32, count uint32) error
+	DeleteCart(userID uint32, ids []uint32) (int64, error)
+	ModifyCartActive(userID uint32, ids []uint32, active bool) error
+}
+
+type mysqlStore struct {
+	db *sql.DB
+}
+
+// NewMySQLStore returns the Store backed by the cart schema.
+func NewMySQLStore(db *sql.DB) Store {
+	return &mysqlStore{db: db}
+}
+
+func (s *mysqlStore) InsertCart(userID uint32, skuID uint32, count uint32) error {
+	return InsertCart(s.db, userID, skuID, count)
+}
+
+func (s *mysqlStore) InfoByUserID(userID uint32) ([]*CartGoods, error) {
+	return InfoByUserID(s.db, userID)
+}
+
+func (s *mysqlStore) ModifyCartCount(userID uint32, id uint32, count uint32) error {
+	return ModifyCartCount(s.db, userID, id, count)
+}
+
+func (s *mysqlStore) DeleteCart(userID uint32, ids []uint32) (int64, error) {
+	return DeleteCart(s.db, userID, ids)
+}
+
+func (s *mysqlStore) ModifyCartActive(userID uint32, ids []uint32, active bool) error {
+	return ModifyCartActive(s.db, userID, ids, active)
+}
@@ -2,22 +2,31 @@ package controller
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/dovics/wx-demo/pkg/goods/model"
 	"github.com/gin-gonic/gin"
 )
 
+var errEmptyCatagoryName = errors.New("catagory name is empty")
+
 // Controller external service interface
 type CatagoryController struct {
-	db *sql.DB
+	store model.Store
 }
 
 // New create an external service interface
 func NewCatagoryController(db *sql.DB) *CatagoryController {
+	return NewCatagoryControllerWithStore(model.NewMySQLStore(db))
+}
+
+// NewCatagoryControllerWithStore create an external service interface on store.
+func NewCatagoryControllerWithStore(store model.Store) *CatagoryController {
 	return &CatagoryController{
-		db: db,
+		store: store,
 	}
 }
 
@@ -26,21 +35,27 @@ func (c *CatagoryController) RegisterRouter(r gin.IRouter) {
 		log.Fatal("[InitRouter]: server is nil")
 	}
 
-	if err := model.CreateDatabase(c.db); err != nil {
-		log.Fatal(err)
-	}
+	r.GET("/all", c.getAll)
+}
 
-	if err := model.CreateCatagoryTable(c.db); err != nil {
-		log.Fatal(err)
+// RegisterManageRouter register the routes modifying the catagorys, r should
+// only be reachable by operators.
+func (c *CatagoryController) RegisterManageRouter(r gin.IRouter) {
+	if r == nil {
+		log.Fatal("[InitRouter]: server is nil")
 	}
 
-	r.GET("/all", c.getAll)
 	r.POST("/insert", c.insert)
+	r.POST("/modify", c.modify)
+	r.POST("/delete", c.delete)
 }
 
 func (c *CatagoryController) insert(ctx *gin.Context) {
 	var req struct {
 		CatagoryName string `json:"catagory_name,omitempty"`
+		ParentID     uint32 `json:"parent_id,omitempty"`
+		Icon         string `json:"icon,omitempty"`
+		Sort         int    `json:"sort,omitempty"`
 	}
 
 	if err := ctx.ShouldBind(&req); err != nil {
@@ -49,7 +64,23 @@ func (c *CatagoryController) insert(ctx *gin.Context) {
 		return
 	}
 
-	if err := model.InsertCatagory(c.db, req.CatagoryName); err != nil {
+	err := c.store.InsertCatagory(&model.Catagory{
+		Name:     req.CatagoryName,
+		ParentID: req.ParentID,
+		Icon:     req.Icon,
+		Sort:     req.Sort,
+	})
+	if err == model.ErrCatagoryParentNotFound {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "errors": fieldErrors("parent_id", err)})
+		return
+	}
+	if err == model.ErrCatagoryNameTaken {
+		ctx.Error(err)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict})
+		return
+	}
+	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
@@ -58,14 +89,109 @@ func (c *CatagoryController) insert(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
 }
 
+// getAll returns the catagorys as a tree.
 func (c *CatagoryController) getAll(ctx *gin.Context) {
-	catagorys, err := model.InfoAllCatagory(c.db)
+	catagorys, err := c.store.InfoAllCatagory()
+	if err != nil {
+
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "catagorys": model.CatagoryTree(catagorys)})
+}
+
+// modify changes the fields set in the request, the others are kept.
+func (c *CatagoryController) modify(ctx *gin.Context) {
+	var req struct {
+		ID uint32 `json:"id"    binding:"required"`
+		model.CatagoryPatch
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		ctx.Error(errEmptyCatagoryName)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	err := c.store.ModifyCatagory(req.ID, &req.CatagoryPatch)
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
+	if err == model.ErrCatagoryCycle || err == model.ErrEmptyPatch {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+	if err == model.ErrCatagoryParentNotFound {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "errors": fieldErrors("parent_id", err)})
+		return
+	}
+	if err == model.ErrCatagoryNameTaken {
+		ctx.Error(err)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict})
+		return
+	}
 	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
+
+// delete removes the catagory, its children are moved to its parent. The spus
+// of the catagory are moved to reassign_to, it is refused if reassign_to is not set.
+func (c *CatagoryController) delete(ctx *gin.Context) {
+	var req struct {
+		ID         uint32 `json:"id"    binding:"required"`
+		ReassignTo uint32 `json:"reassign_to,omitempty"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
 
+	err := c.store.DeleteCatagory(req.ID, req.ReassignTo)
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
+	if err == model.ErrCatagoryInUse {
+		ctx.Error(err)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict})
+		return
+	}
+	if err == model.ErrReassignNotFound {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "errors": fieldErrors("reassign_to", err)})
+		return
+	}
+	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "catagorys": catagorys})
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
+
+// fieldErrors reports err against the request field, in the shape of the spu
+// validation errors.
+func fieldErrors(field string, err error) model.ValidationError {
+	return model.ValidationError{{Field: field, Message: err.Error()}}
 }
@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dovics/wx-demo/pkg/goods/model"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCatagoryAll(t *testing.T) {
+	r, _ := newTestRouter(t)
+	for _, body := range []gin.H{
+		{"catagory_name": "food", "sort": 2},
+		{"catagory_name": "toy", "sort": 1},
+		{"catagory_name": "dry food", "parent_id": 1000},
+	} {
+		if w, _ := do(t, r, http.MethodPost, "/api/v1/category/insert", body); w.Code != http.StatusOK {
+			t.Fatalf("insert %v status = %d, body %s", body, w.Code, w.Body)
+		}
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/category/insert", gin.H{"catagory_name": "toy"}); w.Code != http.StatusConflict {
+		t.Errorf("insert of duplicate name status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	w, resp := do(t, r, http.MethodPost, "/api/v1/category/insert", gin.H{"catagory_name": "orphan", "parent_id": 9999})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("insert under unknown parent status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if field := firstErrorField(resp); field != "parent_id" {
+		t.Errorf("insert under unknown parent error field = %q, want parent_id", field)
+	}
+
+	w, resp = do(t, r, http.MethodGet, "/api/v1/category/all", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("all status = %d, body %s", w.Code, w.Body)
+	}
+
+	roots, _ := resp["catagorys"].([]interface{})
+	if len(roots) != 2 {
+		t.Fatalf("catagorys = %v, want 2 roots", resp["catagorys"])
+	}
+
+	first, second := roots[0].(map[string]interface{}), roots[1].(map[string]interface{})
+	if first["name"] != "toy" || second["name"] != "food" {
+		t.Errorf("roots = %v, %v, want toy before food", first["name"], second["name"])
+	}
+	if children, _ := second["children"].([]interface{}); len(children) != 1 {
+		t.Errorf("children of food = %v, want dry food", second["children"])
+	}
+}
+
+func TestCatagoryModify(t *testing.T) {
+	r, store := newTestRouter(t)
+	do(t, r, http.MethodPost, "/api/v1/category/insert", gin.H{"catagory_name": "food"})
+	do(t, r, http.MethodPost, "/api/v1/category/insert", gin.H{"catagory_name": "dry food", "parent_id": 1000})
+
+	do(t, r, http.MethodPost, "/api/v1/category/insert", gin.H{"catagory_name": "wet food", "parent_id": 1001, "sort": 3})
+
+	for name, c := range map[string]struct {
+		body   gin.H
+		status int
+	}{
+		"empty":          {gin.H{"id": 1000}, http.StatusBadRequest},
+		"empty name":     {gin.H{"id": 1000, "catagory_name": " "}, http.StatusBadRequest},
+		"unknown":        {gin.H{"id": 9999, "sort": 1}, http.StatusNotFound},
+		"child cycle":    {gin.H{"id": 1000, "parent_id": 1001}, http.StatusBadRequest},
+		"grandchild":     {gin.H{"id": 1000, "parent_id": 1002}, http.StatusBadRequest},
+		"under itself":   {gin.H{"id": 1001, "parent_id": 1001}, http.StatusBadRequest},
+		"unknown parent": {gin.H{"id": 1001, "parent_id": 9999}, http.StatusBadRequest},
+		"duplicate name": {gin.H{"id": 1001, "catagory_name": "food"}, http.StatusConflict},
+	} {
+		if w, _ := do(t, r, http.MethodPost, "/api/v1/category/modify", c.body); w.Code != c.status {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, c.status)
+		}
+	}
+
+	w, _ := do(t, r, http.MethodPost, "/api/v1/category/modify",
+		gin.H{"id": 1000, "catagory_name": "cat food", "icon": "food.png"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("modify status = %d, body %s", w.Code, w.Body)
+	}
+
+	// a rename keeps the parent, the icon and the sort
+	w, _ = do(t, r, http.MethodPost, "/api/v1/category/modify", gin.H{"id": 1002, "catagory_name": "cans"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("rename status = %d, body %s", w.Code, w.Body)
+	}
+
+	all, _ := store.InfoAllCatagory()
+	byID := make(map[uint32]*model.Catagory)
+	for _, c := range all {
+		byID[c.ID] = c
+	}
+	if c := byID[1000]; c.Name != "cat food" || c.Icon != "food.png" {
+		t.Errorf("catagory = %+v", c)
+	}
+	if c := byID[1002]; c.Name != "cans" || c.ParentID != 1001 || c.Sort != 3 {
+		t.Errorf("renamed catagory = %+v, want the parent and the sort kept", c)
+	}
+}
+
+func TestCatagoryDelete(t *testing.T) {
+	r, store := newTestRouter(t)
+	do(t, r, http.MethodPost, "/api/v1/category/insert", gin.H{"catagory_name": "food"})
+	do(t, r, http.MethodPost, "/api/v1/category/insert", gin.H{"catagory_name": "dry food", "parent_id": 1000})
+	do(t, r, http.MethodPost, "/api/v1/category/insert", gin.H{"catagory_name": "toy"})
+	insertSpu(t, r, store, "kibble", 1000, false)
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/category/delete", gin.H{"id": 9999}); w.Code != http.StatusNotFound {
+		t.Errorf("delete of unknown catagory status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/category/delete", gin.H{"id": 1000}); w.Code != http.StatusConflict {
+		t.Errorf("delete of used catagory status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	w, resp := do(t, r, http.MethodPost, "/api/v1/category/delete", gin.H{"id": 1000, "reassign_to": 9999})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("delete reassigning to unknown catagory status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if field := firstErrorField(resp); field != "reassign_to" {
+		t.Errorf("delete reassigning to unknown catagory error field = %q, want reassign_to", field)
+	}
+
+	w, _ = do(t, r, http.MethodPost, "/api/v1/category/delete", gin.H{"id": 1000, "reassign_to": 1002})
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete status = %d, body %s", w.Code, w.Body)
+	}
+
+	spus, _, _ := store.QuerySpu(&model.SpuQuery{CatagoryIDs: []uint32{1002}})
+	if len(spus) != 1 {
+		t.Errorf("spus of toy = %v, want the reassigned kibble", spus)
+	}
+
+	all, _ := store.InfoAllCatagory()
+	for _, c := range all {
+		if c.ID == 1001 && c.ParentID != 0 {
+			t.Errorf("dry food parent = %d, want 0", c.ParentID)
+		}
+	}
+}
+
+// firstErrorField returns the field of the first error of the response.
+func firstErrorField(resp map[string]interface{}) string {
+	errs, _ := resp["errors"].([]interface{})
+	if len(errs) == 0 {
+		return ""
+	}
+	first, _ := errs[0].(map[string]interface{})
+	field, _ := first["field"].(string)
+	return field
+}
@@ -6,20 +6,27 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/dovics/wx-demo/pkg/goods/model"
+	"github.com/dovics/wx-demo/util/money"
 	"github.com/gin-gonic/gin"
 )
 
 // Controller external service interface
 type SpuController struct {
-	db *sql.DB
+	store model.Store
 }
 
 // New create an external service interface
 func NewSpuController(db *sql.DB) *SpuController {
+	return NewSpuControllerWithStore(model.NewMySQLStore(db))
+}
+
+// NewSpuControllerWithStore create an external service interface on store.
+func NewSpuControllerWithStore(store model.Store) *SpuController {
 	return &SpuController{
-		db: db,
+		store: store,
 	}
 }
 
@@ -29,26 +36,26 @@ func (c *SpuController) RegisterRouter(r gin.IRouter) {
 		log.Fatal("[InitRouter]: server is nil")
 	}
 
-	if err := model.CreateDatabase(c.db); err != nil {
-		log.Fatal(err)
-	}
-
-	if err := model.CreateSpuTable(c.db); err != nil {
-		log.Fatal(err)
-	}
-
-	if err := model.CreateSkuTable(c.db); err != nil {
-		log.Fatal(err)
-	}
+	r.GET("/info", c.getSpuInfoByKind)
+	r.GET("/info/recommend", c.getRecommendSpuInfo)
+	r.GET("/info/detail", c.getSpuInfoDetail)
+	r.GET("/search", c.search)
+	r.GET("/search/suggest", c.suggest)
+	r.POST("/sku/resolve", c.resolveSku)
+	r.POST("/sku/available", c.availableSpec)
+}
 
-	if err := model.CreateSpecTable(c.db); err != nil {
-		log.Fatal(err)
+// RegisterManageRouter register the routes modifying the catalog, r should
+// only be reachable by operators.
+func (c *SpuController) RegisterManageRouter(r gin.IRouter) {
+	if r == nil {
+		log.Fatal("[InitRouter]: server is nil")
 	}
 
-	r.GET("/info", c.getSpuInfoByKind)
-	r.GET("/info/recommend", c.getRecommendSpuInfo)
 	r.POST("/insert", c.insertSpu)
-	r.GET("/info/detail", c.getSpuInfoDetail)
+	r.POST("/modify", c.modifySpu)
+	r.POST("/modify/active", c.modifySpuActive)
+	r.POST("/delete", c.deleteSpu)
 }
 
 func (c *SpuController) insertSpu(ctx *gin.Context) {
@@ -60,131 +67,438 @@ func (c *SpuController) insertSpu(ctx *gin.Context) {
 		return
 	}
 
-	tx, err := c.db.Begin()
+	if err := model.ValidateSpu(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "errors": err})
+		return
+	}
+
+	if _, err := c.store.InsertSpu(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
+
+func (c *SpuController) getSpuInfoByKind(ctx *gin.Context) {
+	catagoryIDStr, ok := ctx.GetQuery("catagory")
+	if !ok {
+		ctx.Error(errors.New("request should contain catagory id"))
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+	catagoryID, err := strconv.Atoi(catagoryIDStr)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	catagoryIDs, err := c.withDescendants(ctx, uint32(catagoryID))
 	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
 	}
-	defer tx.Rollback()
 
-	spuID, err := model.TxInsertSpu(tx, req)
+	query, err := parseSpuQuery(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+	query.CatagoryIDs = catagoryIDs
+
+	c.querySpu(ctx, query)
+}
+
+// withDescendants returns the catagory and, if the query has
+// descendants=true, every catagory below it.
+func (c *SpuController) withDescendants(ctx *gin.Context, catagoryID uint32) ([]uint32, error) {
+	if ctx.Query("descendants") != "true" {
+		return []uint32{catagoryID}, nil
+	}
+
+	all, err := c.store.InfoAllCatagory()
+	if err != nil {
+		return nil, err
+	}
+
+	return model.Descendants(all, catagoryID), nil
+}
+
+func (c *SpuController) getRecommendSpuInfo(ctx *gin.Context) {
+	query, err := parseSpuQuery(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+	query.Recommend = true
+
+	c.querySpu(ctx, query)
+}
+
+func (c *SpuController) querySpu(ctx *gin.Context, query *model.SpuQuery) {
+	spus, total, err := c.store.QuerySpu(query)
+	if err == model.ErrInvalidSort {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
 	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
 	}
 
-	for _, spec := range req.Spec {
-		if err := model.TxInsertSpec(tx, spuID, spec.Kind, spec.Value); err != nil {
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":    http.StatusOK,
+		"data":      spus,
+		"total":     total,
+		"page":      query.Page,
+		"page_size": query.PageSize,
+	})
+}
+
+// parseSpuQuery reads page, page_size, sort, min_price, max_price and in_stock
+// from the query string.
+func parseSpuQuery(ctx *gin.Context) (*model.SpuQuery, error) {
+	query := &model.SpuQuery{
+		Page:     1,
+		PageSize: model.DefaultPageSize,
+		Sort:     ctx.Query("sort"),
+		InStock:  ctx.Query("in_stock") == "true",
+	}
+
+	if s, ok := ctx.GetQuery("page"); ok {
+		page, err := strconv.Atoi(s)
+		if err != nil || page < 1 {
+			return nil, errors.New("invalid page")
+		}
+		query.Page = page
+	}
+
+	if s, ok := ctx.GetQuery("page_size"); ok {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 1 || size > model.MaxPageSize {
+			return nil, errors.New("invalid page size")
+		}
+		query.PageSize = size
+	}
+
+	for name, dst := range map[string]**money.Money{"min_price": &query.MinPrice, "max_price": &query.MaxPrice} {
+		if s, ok := ctx.GetQuery(name); ok {
+			price, err := money.Parse(s)
+			if err != nil {
+				return nil, errors.New("invalid " + name)
+			}
+			*dst = &price
+		}
+	}
+
+	return query, nil
+}
+
+// search returns the spus matching the keyword q, ranked by relevance unless
+// sort is set. It takes the filters of the lists, catagory and descendants
+// are optional.
+func (c *SpuController) search(ctx *gin.Context) {
+	keyword := strings.TrimSpace(ctx.Query("q"))
+	if keyword == "" {
+		ctx.Error(errors.New("request should contain keyword"))
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	query, err := parseSpuQuery(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+	query.Keyword = keyword
+
+	if s, ok := ctx.GetQuery("catagory"); ok {
+		catagoryID, err := strconv.Atoi(s)
+		if err != nil {
 			ctx.Error(err)
-			ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
 			return
 		}
-	}
 
-	for _, sku := range req.Sku {
-		if err := model.TxInsertSku(tx, spuID, sku.Spec, sku.Price, sku.Stock); err != nil {
+		if query.CatagoryIDs, err = c.withDescendants(ctx, uint32(catagoryID)); err != nil {
 			ctx.Error(err)
 			ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 			return
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
+	c.querySpu(ctx, query)
+}
+
+// suggest returns the titles starting with q for the autocomplete box.
+func (c *SpuController) suggest(ctx *gin.Context) {
+	prefix := strings.TrimSpace(ctx.Query("q"))
+	if prefix == "" {
+		ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": []string{}})
+		return
+	}
+
+	limit := model.DefaultSuggestLimit
+	if s, ok := ctx.GetQuery("limit"); ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > model.MaxPageSize {
+			ctx.Error(errors.New("invalid limit"))
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+			return
+		}
+		limit = n
+	}
+
+	suggestions, err := c.store.SuggestSpu(prefix, limit)
+	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": suggestions})
 }
 
-func (c *SpuController) getSpuInfoByKind(ctx *gin.Context) {
-	catagoryIDStr, ok := ctx.GetQuery("catagory")
+func (c *SpuController) getSpuInfoDetail(ctx *gin.Context) {
+	spuIDStr, ok := ctx.GetQuery("spu_id")
 	if !ok {
-		ctx.Error(errors.New("request should contain catagory id"))
+		ctx.Error(errors.New("request should contain spu id"))
 		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
 		return
 	}
-	catagoryID, err := strconv.Atoi(catagoryIDStr)
+
+	spuID, err := strconv.Atoi(spuIDStr)
 	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
 		return
 	}
 
-	spus, err := model.GetSpuByCatagory(c.db, uint32(catagoryID))
+	spu, err := c.store.InfoSpuDetail(uint32(spuID))
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
 	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": spus})
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": spu})
 }
 
-func (c *SpuController) getRecommendSpuInfo(ctx *gin.Context) {
-	spus, err := model.GetRecommendSpu(c.db)
+// modifySpu patches the spu fields. If spec is present the specs are replaced,
+// if sku is present the skus are diffed: skus with id are updated, skus
+// without id are inserted and the missing ones are deleted.
+func (c *SpuController) modifySpu(ctx *gin.Context) {
+	var req struct {
+		ID uint32 `json:"id"    binding:"required"`
+		model.SpuPatch
+		Spec []*model.Spec `json:"spec"`
+		Sku  []*model.Sku  `json:"sku"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	current, err := c.store.InfoSpuDetail(req.ID)
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
 	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": spus})
-}
+	// validate the spu as it will be after the modification.
+	after := &model.Spu{Title: current.Title, Spec: req.Spec, Sku: req.Sku}
+	if req.Title != nil {
+		after.Title = *req.Title
+	}
+	if req.Price != nil {
+		after.Price = *req.Price
+	}
+	if after.Spec == nil {
+		after.Spec = current.Spec
+	}
+	if after.Sku == nil {
+		after.Sku = current.Sku
+	}
 
-func (c *SpuController) getSpuInfoDetail(ctx *gin.Context) {
-	spuIDStr, ok := ctx.GetQuery("spu_id")
-	if !ok {
-		ctx.Error(errors.New("request should contain spu id"))
-		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+	validate := model.ValidateSpu
+	if req.Spec == nil && req.Sku == nil {
+		validate = model.ValidateSpuFields
+	}
+	if err := validate(after); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "errors": err})
 		return
 	}
+	if len(after.Sku) > 0 && (req.Price != nil || req.Sku != nil) {
+		req.Price = &after.Price
+	}
 
-	spuID, err := strconv.Atoi(spuIDStr)
-	if err != nil {
+	err = c.store.ModifySpu(req.ID, &req.SpuPatch, req.Spec, req.Sku)
+	var (
+		notFound   *model.SkuNotFoundError
+		referenced *model.SkuReferencedError
+	)
+	if errors.As(err, &notFound) {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "sku_id": notFound.ID})
+		return
+	}
+	if errors.As(err, &referenced) {
+		ctx.Error(err)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict, "sku_id": referenced.ID,
+			"carts": referenced.Carts, "orders": referenced.Orders})
+		return
+	}
+	if err == model.ErrEmptyPatch {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
 		return
 	}
-
-	tx, err := c.db.Begin()
 	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
 	}
 
-	defer tx.Rollback()
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
+
+// modifySpuActive delists or relists the spu.
+func (c *SpuController) modifySpuActive(ctx *gin.Context) {
+	var req struct {
+		SpuID  uint32 `json:"spu_id"    binding:"required"`
+		Active bool   `json:"active"`
+	}
 
-	spu, err := model.TxInfoSpuByID(tx, uint32(spuID))
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	err := c.store.ModifySpuActive(req.SpuID, req.Active)
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
 	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
 	}
 
-	spu.Spec, err = model.TxInfoSpecBySpuID(tx, uint32(spuID))
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
+
+// deleteSpu removes the spu with its specs and skus, it refuses while open
+// orders or carts reference the spu.
+func (c *SpuController) deleteSpu(ctx *gin.Context) {
+	var req struct {
+		SpuID uint32 `json:"spu_id"    binding:"required"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	err := c.store.DeleteSpu(req.SpuID)
+	var referenced *model.SpuReferencedError
+	if errors.As(err, &referenced) {
+		ctx.Error(err)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict,
+			"carts": referenced.Carts, "orders": referenced.Orders})
+		return
+	}
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
 	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
 	}
 
-	spu.Sku, err = model.TxInfoSkuBySpuID(tx, uint32(spuID))
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
+
+// resolveSku returns the sku of the spu matching the selected spec values.
+func (c *SpuController) resolveSku(ctx *gin.Context) {
+	var req struct {
+		SpuID     uint32          `json:"spu_id"    binding:"required"`
+		Selection model.Selection `json:"selection" binding:"required"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	sku, err := c.store.ResolveSku(req.SpuID, req.Selection)
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
 	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
 	}
 
-	if err := tx.Commit(); err != nil {
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": gin.H{
+		"sku_id": sku.ID,
+		"price":  sku.Price,
+		"stock":  sku.Stock,
+	}})
+}
+
+// availableSpec returns which spec values can still be bought given a partial selection.
+func (c *SpuController) availableSpec(ctx *gin.Context) {
+	var req struct {
+		SpuID     uint32          `json:"spu_id"    binding:"required"`
+		Selection model.Selection `json:"selection"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	specs, skus, err := c.store.InfoSpecAndSku(req.SpuID)
+	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": spu})
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": model.AvailableSpec(specs, skus, req.Selection)})
 }
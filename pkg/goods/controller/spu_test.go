@@ -0,0 +1,453 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/dovics/wx-demo/pkg/goods/model"
+	"github.com/dovics/wx-demo/util/money"
+	"github.com/gin-gonic/gin"
+)
+
+func newTestRouter(t *testing.T) (*gin.Engine, *model.MemoryStore) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := model.NewMemoryStore()
+	r := gin.New()
+	spu := NewSpuControllerWithStore(store)
+	spu.RegisterRouter(r.Group("/api/v1/spu"))
+	spu.RegisterManageRouter(r.Group("/api/v1/spu"))
+	catagory := NewCatagoryControllerWithStore(store)
+	catagory.RegisterRouter(r.Group("/api/v1/category"))
+	catagory.RegisterManageRouter(r.Group("/api/v1/category"))
+
+	return r, store
+}
+
+func do(t *testing.T, r http.Handler, method, path string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+// insertSpu adds a spu with a size spec and a sku per size through the API
+// and returns its id.
+func insertSpu(t *testing.T, r http.Handler, store *model.MemoryStore, title string, catagoryID uint32, recommend bool) uint32 {
+	t.Helper()
+
+	w, _ := do(t, r, http.MethodPost, "/api/v1/spu/insert", gin.H{
+		"title":       title,
+		"catagory_id": catagoryID,
+		"recommend":   recommend,
+		"spec": []gin.H{
+			{"kind": "size", "value": "S"},
+			{"kind": "size", "value": "L"},
+		},
+		"sku": []gin.H{
+			{"spec": `{"size":"S"}`, "price": 9.9, "stock": 3},
+			{"spec": `{"size":"L"}`, "price": "12.50", "stock": 0},
+		},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("insert status = %d, body %s", w.Code, w.Body)
+	}
+
+	spus, _, err := store.QuerySpu(&model.SpuQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return spus[0].ID
+}
+
+func titles(resp map[string]interface{}) []string {
+	var result []string
+	data, _ := resp["data"].([]interface{})
+	for _, spu := range data {
+		result = append(result, spu.(map[string]interface{})["title"].(string))
+	}
+
+	return result
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestInsertSpu(t *testing.T) {
+	r, store := newTestRouter(t)
+	id := insertSpu(t, r, store, "cat food", 1, false)
+
+	spu, err := store.InfoSpuDetail(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spu.Inventory != 3 || len(spu.Sku) != 2 || len(spu.Spec) != 2 {
+		t.Errorf("spu = %+v, want inventory 3 with 2 skus and specs", spu)
+	}
+
+	w, resp := do(t, r, http.MethodPost, "/api/v1/spu/insert", gin.H{
+		"title": "bad",
+		"spec":  []gin.H{{"kind": "size", "value": "S"}},
+		"sku":   []gin.H{{"spec": `{"size":"M"}`, "price": -1}},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("insert of invalid spu status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if errs, _ := resp["errors"].([]interface{}); len(errs) != 2 {
+		t.Errorf("errors = %v, want price and spec errors", resp["errors"])
+	}
+
+	// a spu without skus keeps its own inventory
+	w, _ = do(t, r, http.MethodPost, "/api/v1/spu/insert", gin.H{"title": "cat toy", "price": 5, "inventory": 7})
+	if w.Code != http.StatusOK {
+		t.Fatalf("insert without sku status = %d, body %s", w.Code, w.Body)
+	}
+
+	spus, _, err := store.QuerySpu(&model.SpuQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, listed := range spus {
+		if listed.Title != "cat toy" {
+			continue
+		}
+
+		spu, err := store.InfoSpuDetail(listed.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spu.Inventory != 7 {
+			t.Errorf("inventory without sku = %d, want 7", spu.Inventory)
+		}
+	}
+}
+
+func TestSpuInfo(t *testing.T) {
+	r, store := newTestRouter(t)
+	do(t, r, http.MethodPost, "/api/v1/category/insert", gin.H{"catagory_name": "food"})
+	do(t, r, http.MethodPost, "/api/v1/category/insert", gin.H{"catagory_name": "dry food", "parent_id": 1000})
+	insertSpu(t, r, store, "fish", 1000, false)
+	insertSpu(t, r, store, "kibble", 1001, true)
+	insertSpu(t, r, store, "toy", 2000, false)
+
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/spu/info", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("info without catagory status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/spu/info?catagory=1000&sort=unknown", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("info with unknown sort status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	for query, want := range map[string][]string{
+		"catagory=1000":                               {"fish"},
+		"catagory=1000&descendants=true":              {"kibble", "fish"},
+		"catagory=1000&descendants=true&page_size=1":  {"kibble"},
+		"catagory=1000&descendants=true&min_price=10": {},
+	} {
+		w, resp := do(t, r, http.MethodGet, "/api/v1/spu/info?"+query, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("info?%s status = %d, body %s", query, w.Code, w.Body)
+		}
+		if got := titles(resp); !equal(got, want) {
+			t.Errorf("info?%s = %v, want %v", query, got, want)
+		}
+	}
+
+	w, resp := do(t, r, http.MethodGet, "/api/v1/spu/info?catagory=1000&descendants=true&page_size=1", nil)
+	if w.Code != http.StatusOK || resp["total"] != 2.0 {
+		t.Errorf("total = %v, want 2", resp["total"])
+	}
+
+	w, resp = do(t, r, http.MethodGet, "/api/v1/spu/info/recommend", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("recommend status = %d, body %s", w.Code, w.Body)
+	}
+	if got := titles(resp); !equal(got, []string{"kibble"}) {
+		t.Errorf("recommend = %v, want [kibble]", got)
+	}
+}
+
+func TestSpuInfoDetail(t *testing.T) {
+	r, store := newTestRouter(t)
+	id := insertSpu(t, r, store, "cat food", 1, false)
+
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/spu/info/detail", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("detail without spu id status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/spu/info/detail?spu_id=9999", nil); w.Code != http.StatusNotFound {
+		t.Errorf("detail of unknown spu status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w, resp := do(t, r, http.MethodGet, "/api/v1/spu/info/detail?spu_id="+strconv.Itoa(int(id)), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("detail status = %d, body %s", w.Code, w.Body)
+	}
+
+	data, _ := resp["data"].(map[string]interface{})
+	if data["title"] != "cat food" {
+		t.Errorf("detail = %v", data)
+	}
+	if skus, _ := data["sku"].([]interface{}); len(skus) != 2 {
+		t.Errorf("detail skus = %v, want 2", data["sku"])
+	}
+}
+
+func TestModifySpu(t *testing.T) {
+	r, store := newTestRouter(t)
+	id := insertSpu(t, r, store, "cat food", 1, false)
+	spu, _ := store.InfoSpuDetail(id)
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/spu/modify", gin.H{"id": 9999, "title": "x"}); w.Code != http.StatusNotFound {
+		t.Errorf("modify of unknown spu status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/spu/modify", gin.H{"id": id}); w.Code != http.StatusBadRequest {
+		t.Errorf("empty modify status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/spu/modify", gin.H{"id": id, "title": " "}); w.Code != http.StatusBadRequest {
+		t.Errorf("modify to empty title status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w, resp := do(t, r, http.MethodPost, "/api/v1/spu/modify", gin.H{"id": id, "sku": []gin.H{
+		{"id": 9999, "spec": `{"size":"S"}`, "price": 1, "stock": 1},
+	}})
+	if w.Code != http.StatusBadRequest || resp["sku_id"] != 9999.0 {
+		t.Errorf("modify of unknown sku status = %d, body %s", w.Code, w.Body)
+	}
+
+	keep := gin.H{"id": id, "title": "cat treats", "sku": []gin.H{
+		{"id": spu.Sku[0].ID, "spec": `{"size":"S"}`, "price": 8, "stock": 4},
+	}}
+
+	removed := spu.Sku[len(spu.Sku)-1].ID
+	store.SetSkuReferences(removed, 1, 2)
+	w, resp = do(t, r, http.MethodPost, "/api/v1/spu/modify", keep)
+	if w.Code != http.StatusConflict || resp["sku_id"] != float64(removed) || resp["orders"] != 2.0 {
+		t.Errorf("removal of a referenced sku status = %d, body %s", w.Code, w.Body)
+	}
+	if detail, _ := store.InfoSpuDetail(id); len(detail.Sku) != len(spu.Sku) {
+		t.Errorf("skus after a refused modify = %d, want %d", len(detail.Sku), len(spu.Sku))
+	}
+
+	store.SetSkuReferences(removed, 0, 0)
+	w, _ = do(t, r, http.MethodPost, "/api/v1/spu/modify", keep)
+	if w.Code != http.StatusOK {
+		t.Fatalf("modify status = %d, body %s", w.Code, w.Body)
+	}
+
+	spu, _ = store.InfoSpuDetail(id)
+	if spu.Title != "cat treats" || len(spu.Sku) != 1 || spu.Inventory != 4 {
+		t.Errorf("spu = %+v, want the new title and one sku of stock 4", spu)
+	}
+}
+
+func TestModifyLegacySpu(t *testing.T) {
+	r, store := newTestRouter(t)
+
+	// skus stored before specs were validated may not be JSON.
+	id, err := store.InsertSpu(&model.Spu{Title: "cat food", Sku: []*model.Sku{
+		{Spec: "red L", Price: money.New(990), Stock: 3},
+		{Spec: "red S", Price: money.New(790), Stock: 1},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []gin.H{
+		{"id": id, "title": "cat treats"},
+		{"id": id, "price": 1},
+	} {
+		if w, _ := do(t, r, http.MethodPost, "/api/v1/spu/modify", body); w.Code != http.StatusOK {
+			t.Errorf("modify %v of legacy spu status = %d, body %s", body, w.Code, w.Body)
+		}
+	}
+
+	spus, _, err := store.QuerySpu(&model.SpuQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spus[0].Title != "cat treats" || spus[0].Price != money.New(790) {
+		t.Errorf("spu = %+v, want the new title and the lowest sku price", spus[0])
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/spu/modify", gin.H{"id": id, "spec": []gin.H{
+		{"kind": "size", "value": "L"},
+	}}); w.Code != http.StatusBadRequest {
+		t.Errorf("modify of the specs of legacy skus status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestModifySpuActive(t *testing.T) {
+	r, store := newTestRouter(t)
+	id := insertSpu(t, r, store, "cat food", 1, true)
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/spu/modify/active", gin.H{}); w.Code != http.StatusBadRequest {
+		t.Errorf("modify active without spu id status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/spu/modify/active", gin.H{"spu_id": 9999, "active": false}); w.Code != http.StatusNotFound {
+		t.Errorf("modify active of unknown spu status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w, _ := do(t, r, http.MethodPost, "/api/v1/spu/modify/active", gin.H{"spu_id": id, "active": false})
+	if w.Code != http.StatusOK {
+		t.Fatalf("modify active status = %d, body %s", w.Code, w.Body)
+	}
+
+	_, resp := do(t, r, http.MethodGet, "/api/v1/spu/info/recommend", nil)
+	if got := titles(resp); len(got) != 0 {
+		t.Errorf("delisted spu is listed: %v", got)
+	}
+}
+
+func TestDeleteSpu(t *testing.T) {
+	r, store := newTestRouter(t)
+	id := insertSpu(t, r, store, "cat food", 1, false)
+
+	store.SetReferences(id, 1, 2)
+	w, resp := do(t, r, http.MethodPost, "/api/v1/spu/delete", gin.H{"spu_id": id})
+	if w.Code != http.StatusConflict || resp["carts"] != 1.0 || resp["orders"] != 2.0 {
+		t.Errorf("delete of referenced spu status = %d, body %s", w.Code, w.Body)
+	}
+
+	store.SetReferences(id, 0, 0)
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/spu/delete", gin.H{"spu_id": id}); w.Code != http.StatusOK {
+		t.Fatalf("delete status = %d, body %s", w.Code, w.Body)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/spu/delete", gin.H{"spu_id": id}); w.Code != http.StatusNotFound {
+		t.Errorf("delete of deleted spu status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	r, store := newTestRouter(t)
+	insertSpu(t, r, store, "cat food", 1, false)
+	insertSpu(t, r, store, "dog food", 2, false)
+	insertSpu(t, r, store, "cat toy", 1, false)
+
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/spu/search", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("search without keyword status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w, resp := do(t, r, http.MethodGet, "/api/v1/spu/search?q=food", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("search status = %d, body %s", w.Code, w.Body)
+	}
+	if got := titles(resp); !equal(got, []string{"dog food", "cat food"}) || resp["total"] != 2.0 {
+		t.Errorf("search = %v total %v", got, resp["total"])
+	}
+
+	for query, want := range map[string][]string{
+		"q=cat":                     {"cat toy", "cat food"},
+		"q=cat&sort=price_desc":     {"cat toy", "cat food"},
+		"q=food&catagory=2":         {"dog food"},
+		"q=food&catagory=2&sort=xx": nil,
+		"q=toy&min_price=100":       {},
+	} {
+		w, resp := do(t, r, http.MethodGet, "/api/v1/spu/search?"+query, nil)
+		if want == nil {
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("search?%s status = %d, want %d", query, w.Code, http.StatusBadRequest)
+			}
+			continue
+		}
+		if w.Code != http.StatusOK {
+			t.Fatalf("search?%s status = %d, body %s", query, w.Code, w.Body)
+		}
+		if got := titles(resp); !equal(got, want) {
+			t.Errorf("search?%s = %v, want %v", query, got, want)
+		}
+	}
+
+	w, resp = do(t, r, http.MethodGet, "/api/v1/spu/search/suggest?q=cat", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("suggest status = %d, body %s", w.Code, w.Body)
+	}
+	if data, _ := resp["data"].([]interface{}); len(data) != 2 || data[0] != "cat food" {
+		t.Errorf("suggest = %v", resp["data"])
+	}
+
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/spu/search/suggest?q=cat&limit=0", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("suggest with limit 0 status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestResolveSku(t *testing.T) {
+	r, store := newTestRouter(t)
+	id := insertSpu(t, r, store, "cat food", 1, false)
+
+	w, resp := do(t, r, http.MethodPost, "/api/v1/spu/sku/resolve", gin.H{"spu_id": id, "selection": gin.H{"size": "L"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("resolve status = %d, body %s", w.Code, w.Body)
+	}
+	if data, _ := resp["data"].(map[string]interface{}); data["price"] != 12.5 || data["stock"] != 0.0 {
+		t.Errorf("resolve = %v", resp["data"])
+	}
+
+	w, _ = do(t, r, http.MethodPost, "/api/v1/spu/sku/resolve", gin.H{"spu_id": id, "selection": gin.H{"size": "XL"}})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("resolve of unknown selection status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if err := store.ModifySpuActive(id, false); err != nil {
+		t.Fatal(err)
+	}
+	w, _ = do(t, r, http.MethodPost, "/api/v1/spu/sku/resolve", gin.H{"spu_id": id, "selection": gin.H{"size": "L"}})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("resolve of inactive spu status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAvailableSpec(t *testing.T) {
+	r, store := newTestRouter(t)
+	id := insertSpu(t, r, store, "cat food", 1, false)
+
+	w, resp := do(t, r, http.MethodPost, "/api/v1/spu/sku/available", gin.H{"spu_id": id})
+	if w.Code != http.StatusOK {
+		t.Fatalf("available status = %d, body %s", w.Code, w.Body)
+	}
+
+	data, _ := resp["data"].(map[string]interface{})
+	available := make(map[string]bool)
+	sizes, _ := data["size"].([]interface{})
+	for _, v := range sizes {
+		v := v.(map[string]interface{})
+		available[v["value"].(string)] = v["available"].(bool)
+	}
+	if !available["S"] || available["L"] {
+		t.Errorf("available = %v, want only S", available)
+	}
+}
@@ -2,7 +2,12 @@ package model
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 const CatagoryTableName = "catagory"
@@ -11,22 +16,55 @@ const (
 	mysqlCatagoryCreateTable = iota
 	mysqlCatagoryInsert
 	mysqlCatagoryInfoAll
+	mysqlCatagoryModify
+	mysqlCatagoryReparent
+	mysqlCatagoryDelete
+	mysqlCatagoryCountSpu
+	mysqlCatagoryReassignSpu
+	mysqlCatagoryLockParent
 )
 
-var catagorySQLString = []string{
-	fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+var (
+	// ErrCatagoryCycle returned when a catagory is moved under itself or its descendants.
+	ErrCatagoryCycle = errors.New("catagory can not be a child of itself or its descendants")
+	// ErrCatagoryInUse returned when deleting a catagory referenced by spus.
+	ErrCatagoryInUse = errors.New("catagory is referenced by spus")
+	// ErrCatagoryNameTaken returned when another catagory already has the name.
+	ErrCatagoryNameTaken = errors.New("catagory name is taken")
+	// ErrCatagoryParentNotFound returned when the parent_id catagory does not exist.
+	ErrCatagoryParentNotFound = errors.New("parent_id catagory does not exist")
+	// ErrReassignNotFound returned when the reassign_to catagory does not exist.
+	ErrReassignNotFound = errors.New("reassign_to catagory does not exist")
+
+	catagorySQLString = []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
 		id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+		parent_id		BIGINT UNSIGNED NOT NULL DEFAULT 0,
 		name			VARCHAR(100) UNIQUE NOT NULL DEFAULT " ",
+		icon			VARCHAR(512) NOT NULL DEFAULT "",
+		sort			INT NOT NULL DEFAULT 0,
 		created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		PRIMARY KEY (id)
+		PRIMARY KEY (id),
+		INDEX parent_index (parent_id)
 	) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, CatagoryTableName),
-	fmt.Sprintf(`INSERT INTO %s.%s(name) VALUES (?)`, DBName, CatagoryTableName),
-	fmt.Sprintf(`SELECT id, name FROM %s.%s`, DBName, CatagoryTableName),
-}
+		fmt.Sprintf(`INSERT INTO %s.%s(name, parent_id, icon, sort) VALUES (?, ?, ?, ?)`, DBName, CatagoryTableName),
+		fmt.Sprintf(`SELECT id, parent_id, name, icon, sort FROM %s.%s ORDER BY sort, id`, DBName, CatagoryTableName),
+		fmt.Sprintf(`UPDATE %s.%s SET %%s WHERE id = ? LIMIT 1`, DBName, CatagoryTableName),
+		fmt.Sprintf(`UPDATE %s.%s SET parent_id = ? WHERE parent_id = ?`, DBName, CatagoryTableName),
+		fmt.Sprintf(`DELETE FROM %s.%s WHERE id = ? LIMIT 1`, DBName, CatagoryTableName),
+		fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s WHERE catagory_id = ?`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET catagory_id = ? WHERE catagory_id = ?`, DBName, TableName),
+		fmt.Sprintf(`SELECT parent_id FROM %s.%s WHERE id = ? FOR UPDATE`, DBName, CatagoryTableName),
+	}
+)
 
 type Catagory struct {
-	ID   uint32 `json:"id,omitempty"`
-	Name string `json:"name,omitempty"`
+	ID       uint32      `json:"id,omitempty"`
+	ParentID uint32      `json:"parent_id,omitempty"`
+	Name     string      `json:"name,omitempty"`
+	Icon     string      `json:"icon,omitempty"`
+	Sort     int         `json:"sort,omitempty"`
+	Children []*Catagory `json:"children,omitempty"`
 }
 
 // CreateTable create catagory table.
@@ -39,12 +77,47 @@ func CreateCatagoryTable(db *sql.DB) error {
 	return nil
 }
 
-func InsertCatagory(db *sql.DB, catagoryName string) error {
-	result, err := db.Exec(catagorySQLString[mysqlCatagoryInsert], catagoryName)
-	if err != nil {
+// mysqlDuplicateEntry is the error number of a unique key violation.
+const mysqlDuplicateEntry = 1062
+
+// catagoryNameError maps a violation of the unique name to ErrCatagoryNameTaken.
+func catagoryNameError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return ErrCatagoryNameTaken
+	}
+
+	return err
+}
+
+// txLockCatagoryParent locks the parent catagory, or returns
+// ErrCatagoryParentNotFound when it does not exist. 0 is the root.
+func txLockCatagoryParent(tx *sql.Tx, parentID uint32) error {
+	if parentID == 0 {
+		return nil
+	}
+
+	var unused uint32
+	err := tx.QueryRow(catagorySQLString[mysqlCatagoryLockParent], parentID).Scan(&unused)
+	if err == sql.ErrNoRows {
+		return ErrCatagoryParentNotFound
+	}
+
+	return err
+}
+
+// TxInsertCatagory inserts the catagory under its locked parent.
+func TxInsertCatagory(tx *sql.Tx, catagory *Catagory) error {
+	if err := txLockCatagoryParent(tx, catagory.ParentID); err != nil {
 		return err
 	}
 
+	result, err := tx.Exec(catagorySQLString[mysqlCatagoryInsert], catagory.Name, catagory.ParentID,
+		catagory.Icon, catagory.Sort)
+	if err != nil {
+		return catagoryNameError(err)
+	}
+
 	if rows, _ := result.RowsAffected(); rows == 0 {
 		return errInvalidMysql
 	}
@@ -52,27 +125,196 @@ func InsertCatagory(db *sql.DB, catagoryName string) error {
 	return nil
 }
 
+// InfoAllCatagory returns every catagory ordered by sort.
 func InfoAllCatagory(db *sql.DB) ([]*Catagory, error) {
 	rows, err := db.Query(catagorySQLString[mysqlCatagoryInfoAll])
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var result []*Catagory
 	for rows.Next() {
-		var (
-			id   uint32
-			name string
-		)
-		if err := rows.Scan(&id, &name); err != nil {
+		var c Catagory
+		if err := rows.Scan(&c.ID, &c.ParentID, &c.Name, &c.Icon, &c.Sort); err != nil {
 			return nil, err
 		}
 
-		result = append(result, &Catagory{
-			ID:   id,
-			Name: name,
-		})
+		result = append(result, &c)
+	}
+
+	return result, rows.Err()
+}
+
+// CatagoryTree nests the catagories under their parents and returns the roots.
+// A catagory whose parent does not exist is a root.
+func CatagoryTree(catagorys []*Catagory) []*Catagory {
+	byID := make(map[uint32]*Catagory, len(catagorys))
+	for _, c := range catagorys {
+		byID[c.ID] = c
+	}
+
+	var roots []*Catagory
+	for _, c := range catagorys {
+		if parent, ok := byID[c.ParentID]; ok && c.ParentID != c.ID {
+			parent.Children = append(parent.Children, c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+
+	return roots
+}
+
+// Descendants returns id and the ids of all its descendants.
+func Descendants(catagorys []*Catagory, id uint32) []uint32 {
+	children := make(map[uint32][]uint32)
+	for _, c := range catagorys {
+		children[c.ParentID] = append(children[c.ParentID], c.ID)
+	}
+
+	result := []uint32{id}
+	visited := map[uint32]bool{id: true}
+	for i := 0; i < len(result); i++ {
+		for _, child := range children[result[i]] {
+			if !visited[child] {
+				visited[child] = true
+				result = append(result, child)
+			}
+		}
 	}
 
-	return result, nil
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// CatagoryPatch is a partial modification of a catagory, nil fields are kept.
+type CatagoryPatch struct {
+	Name     *string `json:"catagory_name"`
+	ParentID *uint32 `json:"parent_id"`
+	Icon     *string `json:"icon"`
+	Sort     *int    `json:"sort"`
+}
+
+func (patch *CatagoryPatch) empty() bool {
+	return patch.Name == nil && patch.ParentID == nil && patch.Icon == nil && patch.Sort == nil
+}
+
+// TxModifyCatagory renames, moves or reorders the catagory by the non nil
+// fields of the patch. The catagory and the ancestors of its new parent are
+// locked, so a concurrent move can not build a cycle between the check and the
+// update. The new parent must exist.
+func TxModifyCatagory(tx *sql.Tx, id uint32, patch *CatagoryPatch) error {
+	if patch.empty() {
+		return ErrEmptyPatch
+	}
+
+	var parentID uint32
+	if err := tx.QueryRow(catagorySQLString[mysqlCatagoryLockParent], id).Scan(&parentID); err != nil {
+		return err
+	}
+
+	var (
+		columns []string
+		args    []interface{}
+	)
+	set := func(column string, value interface{}) {
+		columns = append(columns, column+" = ?")
+		args = append(args, value)
+	}
+
+	if patch.ParentID != nil {
+		if err := txLockCatagoryParent(tx, *patch.ParentID); err != nil {
+			return err
+		}
+		if err := txCheckCatagoryCycle(tx, id, *patch.ParentID); err != nil {
+			return err
+		}
+		set("parent_id", *patch.ParentID)
+	}
+	if patch.Name != nil {
+		set("name", *patch.Name)
+	}
+	if patch.Icon != nil {
+		set("icon", *patch.Icon)
+	}
+	if patch.Sort != nil {
+		set("sort", *patch.Sort)
+	}
+
+	query := fmt.Sprintf(catagorySQLString[mysqlCatagoryModify], strings.Join(columns, ", "))
+	_, err := tx.Exec(query, append(args, id)...)
+	return catagoryNameError(err)
+}
+
+// txCheckCatagoryCycle walks up from parentID and returns ErrCatagoryCycle if
+// it reaches id. A missing parent ends the walk, the catagory is then a root.
+func txCheckCatagoryCycle(tx *sql.Tx, id uint32, parentID uint32) error {
+	visited := make(map[uint32]bool)
+	for parentID != 0 && !visited[parentID] {
+		if parentID == id {
+			return ErrCatagoryCycle
+		}
+		visited[parentID] = true
+
+		err := tx.QueryRow(catagorySQLString[mysqlCatagoryLockParent], parentID).Scan(&parentID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TxDeleteCatagory removes the catagory and moves its children to its parent.
+// If spus reference the catagory they are moved to reassignTo, or
+// ErrCatagoryInUse is returned when reassignTo is 0, ErrReassignNotFound when
+// it does not exist.
+func TxDeleteCatagory(tx *sql.Tx, id uint32, reassignTo uint32) error {
+	var parentID uint32
+	if err := tx.QueryRow(catagorySQLString[mysqlCatagoryLockParent], id).Scan(&parentID); err != nil {
+		return err
+	}
+
+	var n int
+	if err := tx.QueryRow(catagorySQLString[mysqlCatagoryCountSpu], id).Scan(&n); err != nil {
+		return err
+	}
+
+	if n > 0 {
+		if reassignTo == 0 || reassignTo == id {
+			return ErrCatagoryInUse
+		}
+
+		var unused uint32
+		err := tx.QueryRow(catagorySQLString[mysqlCatagoryLockParent], reassignTo).Scan(&unused)
+		if err == sql.ErrNoRows {
+			return ErrReassignNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(catagorySQLString[mysqlCatagoryReassignSpu], reassignTo, id); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(catagorySQLString[mysqlCatagoryReparent], parentID, id); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(catagorySQLString[mysqlCatagoryDelete], id)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errInvalidMysql
+	}
+
+	return nil
 }
@@ -0,0 +1,158 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dovics/wx-demo/util/money"
+)
+
+const (
+	SortDefault   = ""
+	SortPrice     = "price"
+	SortPriceDesc = "price_desc"
+	SortNewest    = "newest"
+	SortSales     = "sales"
+
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+var (
+	// ErrInvalidSort returned when the sort of a query is unknown.
+	ErrInvalidSort = errors.New("invalid sort")
+
+	spuOrderBy = map[string]string{
+		SortDefault:   "spu.id DESC",
+		SortPrice:     "spu.price ASC, spu.id DESC",
+		SortPriceDesc: "spu.price DESC, spu.id DESC",
+		SortNewest:    "spu.created_at DESC, spu.id DESC",
+		SortSales:     "spu.sales DESC, spu.id DESC",
+	}
+)
+
+// SpuQuery filters, sorts and pages the active spus. A zero PageSize returns
+// every matching spu. A Keyword keeps the spus matching it in title, codes,
+// catagory name or spec values, ranked by relevance under the default sort.
+type SpuQuery struct {
+	Keyword     string
+	CatagoryIDs []uint32
+	Recommend   bool
+	MinPrice    *money.Money
+	MaxPrice    *money.Money
+	InStock     bool
+	Sort        string
+
+	Page     int
+	PageSize int
+}
+
+func (q *SpuQuery) where() (string, []interface{}) {
+	conditions := []string{"spu.active = true"}
+	var args []interface{}
+
+	if q.Keyword != "" {
+		conditions = append(conditions, searchMatch)
+		args = append(args, q.Keyword, q.Keyword, q.Keyword)
+	}
+
+	if len(q.CatagoryIDs) > 0 {
+		conditions = append(conditions, fmt.Sprintf("spu.catagory_id IN (%s)",
+			strings.TrimSuffix(strings.Repeat("?, ", len(q.CatagoryIDs)), ", ")))
+		for _, id := range q.CatagoryIDs {
+			args = append(args, id)
+		}
+	}
+	if q.Recommend {
+		conditions = append(conditions, "spu.recommend = true")
+	}
+	if q.MinPrice != nil {
+		conditions = append(conditions, "spu.price >= ?")
+		args = append(args, *q.MinPrice)
+	}
+	if q.MaxPrice != nil {
+		conditions = append(conditions, "spu.price <= ?")
+		args = append(args, *q.MaxPrice)
+	}
+	if q.InStock {
+		conditions = append(conditions, "spu.inventory > 0")
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// QuerySpu returns a page of the spus matching the query and the total number
+// of matching spus.
+func QuerySpu(db *sql.DB, q *SpuQuery) ([]*Spu, int, error) {
+	orderBy, ok := spuOrderBy[q.Sort]
+	if !ok {
+		return nil, 0, ErrInvalidSort
+	}
+
+	where, args := q.where()
+	if q.Keyword != "" && q.Sort == SortDefault {
+		orderBy = searchRelevance
+		args = append(args, q.Keyword, q.Keyword)
+	}
+
+	query := fmt.Sprintf(spuSQLString[mysqlSpuList], where, orderBy)
+	if q.PageSize > 0 {
+		page := q.Page
+		if page < 1 {
+			page = 1
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, q.PageSize, (page-1)*q.PageSize)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	result, err := scanSpuList(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if q.PageSize == 0 {
+		return result, len(result), nil
+	}
+
+	var total int
+	where, args = q.where()
+	if err := db.QueryRow(fmt.Sprintf(spuSQLString[mysqlSpuCount], where), args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	return result, total, nil
+}
+
+func scanSpuList(rows *sql.Rows) ([]*Spu, error) {
+	var result []*Spu
+	for rows.Next() {
+		var (
+			id           uint32
+			catagoryName string
+			title        string
+			images       string
+			price        money.Money
+		)
+		if err := rows.Scan(&id, &catagoryName, &title, &images, &price); err != nil {
+			return nil, err
+		}
+
+		result = append(result, &Spu{
+			ID:           id,
+			CatagoryName: catagoryName,
+			Title:        title,
+			Images:       images,
+			Price:        price,
+		})
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,640 @@
+package model
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryReference struct {
+	carts  int
+	orders int
+}
+
+// MemoryStore is a Store kept in process memory, for tests.
+type MemoryStore struct {
+	mu           sync.RWMutex
+	nextID       uint32
+	spus         map[uint32]*Spu
+	catagorys    map[uint32]*Catagory
+	references   map[uint32]memoryReference
+	skuRefs      map[uint32]memoryReference
+	lastModified time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore. Ids start at 1000 like the
+// goods tables.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nextID:     1000,
+		spus:       make(map[uint32]*Spu),
+		catagorys:  make(map[uint32]*Catagory),
+		references: make(map[uint32]memoryReference),
+		skuRefs:    make(map[uint32]memoryReference),
+	}
+}
+
+// SetReferences records the carts and open orders of the spu, which the
+// MySQL store reads from the cart and orders schemas.
+func (s *MemoryStore) SetReferences(spuID uint32, carts, orders int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.references[spuID] = memoryReference{carts: carts, orders: orders}
+}
+
+// SetSkuReferences records the carts and open orders of the sku.
+func (s *MemoryStore) SetSkuReferences(skuID uint32, carts, orders int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.skuRefs[skuID] = memoryReference{carts: carts, orders: orders}
+}
+
+func (s *MemoryStore) id() uint32 {
+	id := s.nextID
+	s.nextID++
+	return id
+}
+
+// now returns strictly increasing times so newest first sorting is stable.
+func (s *MemoryStore) now() time.Time {
+	now := time.Now()
+	if !now.After(s.lastModified) {
+		now = s.lastModified.Add(time.Microsecond)
+	}
+	s.lastModified = now
+	return now
+}
+
+func (s *MemoryStore) InsertSpu(spu *Spu) (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *spu
+	stored.ID = s.id()
+	stored.Active = true
+	stored.CreatedAt = s.now()
+	stored.Spec = nil
+	stored.Sku = nil
+
+	for _, spec := range spu.Spec {
+		stored.Spec = append(stored.Spec, &Spec{ID: s.id(), SpuID: stored.ID, Kind: spec.Kind, Value: spec.Value})
+	}
+
+	for _, sku := range spu.Sku {
+		stored.Sku = append(stored.Sku, &Sku{ID: s.id(), Spec: CanonicalSpec(sku.Spec), Price: sku.Price, Stock: sku.Stock})
+	}
+
+	if len(stored.Sku) > 0 {
+		reconcile(&stored)
+	}
+
+	s.spus[stored.ID] = &stored
+	return stored.ID, nil
+}
+
+func reconcile(spu *Spu) {
+	if len(spu.Sku) == 0 {
+		return
+	}
+
+	spu.Inventory = 0
+	for _, sku := range spu.Sku {
+		spu.Inventory += sku.Stock
+	}
+}
+
+func (s *MemoryStore) catagoryName(id uint32) string {
+	if c, ok := s.catagorys[id]; ok {
+		return c.Name
+	}
+	return ""
+}
+
+// listed returns the spu in the shape of the spu lists.
+func (s *MemoryStore) listed(spu *Spu) *Spu {
+	return &Spu{
+		ID:           spu.ID,
+		CatagoryName: s.catagoryName(spu.CatagoryID),
+		Title:        spu.Title,
+		Images:       spu.Images,
+		Price:        spu.Price,
+	}
+}
+
+func (s *MemoryStore) match(q *SpuQuery, spu *Spu) bool {
+	if q.Keyword != "" && !s.matchKeyword(spu, strings.ToLower(q.Keyword)) {
+		return false
+	}
+
+	if !spu.Active || (q.Recommend && !spu.Recommend) || (q.InStock && spu.Inventory == 0) {
+		return false
+	}
+
+	if q.MinPrice != nil && spu.Price.Amount < q.MinPrice.Amount {
+		return false
+	}
+
+	if q.MaxPrice != nil && spu.Price.Amount > q.MaxPrice.Amount {
+		return false
+	}
+
+	if len(q.CatagoryIDs) == 0 {
+		return true
+	}
+
+	for _, id := range q.CatagoryIDs {
+		if id == spu.CatagoryID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// less orders the spus like spuOrderBy.
+func less(sortBy string, a, b *Spu) bool {
+	switch sortBy {
+	case SortPrice:
+		if a.Price.Amount != b.Price.Amount {
+			return a.Price.Amount < b.Price.Amount
+		}
+	case SortPriceDesc:
+		if a.Price.Amount != b.Price.Amount {
+			return a.Price.Amount > b.Price.Amount
+		}
+	case SortNewest:
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+	case SortSales:
+		if a.Sales != b.Sales {
+			return a.Sales > b.Sales
+		}
+	}
+
+	return a.ID > b.ID
+}
+
+func paginate(spus []*Spu, page, pageSize int) []*Spu {
+	if pageSize == 0 {
+		return spus
+	}
+
+	if page < 1 {
+		page = 1
+	}
+
+	start := (page - 1) * pageSize
+	if start >= len(spus) {
+		return nil
+	}
+
+	end := start + pageSize
+	if end > len(spus) {
+		end = len(spus)
+	}
+
+	return spus[start:end]
+}
+
+func (s *MemoryStore) QuerySpu(query *SpuQuery) ([]*Spu, int, error) {
+	if _, ok := spuOrderBy[query.Sort]; !ok {
+		return nil, 0, ErrInvalidSort
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []*Spu
+	for _, spu := range s.spus {
+		if s.match(query, spu) {
+			matched = append(matched, spu)
+		}
+	}
+
+	keyword := strings.ToLower(query.Keyword)
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if keyword != "" && query.Sort == SortDefault {
+			if ra, rb := s.relevance(a, keyword), s.relevance(b, keyword); ra != rb {
+				return ra > rb
+			}
+		}
+		return less(query.Sort, a, b)
+	})
+
+	var result []*Spu
+	for _, spu := range paginate(matched, query.Page, query.PageSize) {
+		result = append(result, s.listed(spu))
+	}
+
+	return result, len(matched), nil
+}
+
+// matchKeyword matches a substring of the title, codes, catagory name or spec
+// values, like the full-text search.
+func (s *MemoryStore) matchKeyword(spu *Spu, keyword string) bool {
+	if s.relevance(spu, keyword) > 0 {
+		return true
+	}
+
+	for _, spec := range spu.Spec {
+		if strings.Contains(strings.ToLower(spec.Value), keyword) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// relevance ranks the title and codes above the catagory name, like
+// searchRelevance.
+func (s *MemoryStore) relevance(spu *Spu, keyword string) int {
+	contains := func(v string) bool {
+		return strings.Contains(strings.ToLower(v), keyword)
+	}
+
+	score := 0
+	if contains(spu.Title) || contains(spu.ProductionCode) || contains(spu.StandardCode) {
+		score += 2
+	}
+
+	if contains(s.catagoryName(spu.CatagoryID)) {
+		score++
+	}
+
+	return score
+}
+
+func (s *MemoryStore) SuggestSpu(prefix string, limit int) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sales := make(map[string]uint32)
+	for _, spu := range s.spus {
+		if spu.Active && strings.HasPrefix(spu.Title, prefix) && spu.Sales >= sales[spu.Title] {
+			sales[spu.Title] = spu.Sales
+		}
+	}
+
+	for _, c := range s.catagorys {
+		if _, ok := sales[c.Name]; !ok && strings.HasPrefix(c.Name, prefix) {
+			sales[c.Name] = 0
+		}
+	}
+
+	result := []string{}
+	for title := range sales {
+		result = append(result, title)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if sales[result[i]] != sales[result[j]] {
+			return sales[result[i]] > sales[result[j]]
+		}
+		return result[i] < result[j]
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+func copySpecAndSku(spu *Spu) ([]*Spec, []*Sku) {
+	var (
+		specs []*Spec
+		skus  []*Sku
+	)
+	for _, spec := range spu.Spec {
+		copied := *spec
+		specs = append(specs, &copied)
+	}
+
+	for _, sku := range spu.Sku {
+		copied := *sku
+		skus = append(skus, &copied)
+	}
+
+	return specs, skus
+}
+
+func (s *MemoryStore) InfoSpuDetail(spuID uint32) (*Spu, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	spu, ok := s.spus[spuID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	detail := &Spu{
+		ID:             spu.ID,
+		CatagoryID:     spu.CatagoryID,
+		Title:          spu.Title,
+		ProductionCode: spu.ProductionCode,
+		StandardCode:   spu.StandardCode,
+		Inventory:      spu.Inventory,
+		ShelfLife:      spu.ShelfLife,
+		Images:         spu.Images,
+		DetailImages:   spu.DetailImages,
+		CreatedAt:      spu.CreatedAt,
+	}
+	detail.Spec, detail.Sku = copySpecAndSku(spu)
+
+	return detail, nil
+}
+
+func (s *MemoryStore) InfoSpecAndSku(spuID uint32) ([]*Spec, []*Sku, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	spu, ok := s.spus[spuID]
+	if !ok {
+		return nil, nil, nil
+	}
+
+	specs, skus := copySpecAndSku(spu)
+	return specs, skus, nil
+}
+
+func (patch *SpuPatch) empty() bool {
+	return patch.CatagoryID == nil && patch.Title == nil && patch.ProductionCode == nil &&
+		patch.StandardCode == nil && patch.Price == nil && patch.ShelfLife == nil &&
+		patch.Images == nil && patch.DetailImages == nil && patch.Recommend == nil
+}
+
+func (s *MemoryStore) ModifySpu(spuID uint32, patch *SpuPatch, specs []*Spec, skus []*Sku) error {
+	if patch.empty() && specs == nil && skus == nil {
+		return ErrEmptyPatch
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.spus[spuID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	// changes are made on a copy so a failure leaves the spu unchanged.
+	spu := *current
+	if patch.CatagoryID != nil {
+		spu.CatagoryID = *patch.CatagoryID
+	}
+	if patch.Title != nil {
+		spu.Title = *patch.Title
+	}
+	if patch.ProductionCode != nil {
+		spu.ProductionCode = *patch.ProductionCode
+	}
+	if patch.StandardCode != nil {
+		spu.StandardCode = *patch.StandardCode
+	}
+	if patch.Price != nil {
+		spu.Price = *patch.Price
+	}
+	if patch.ShelfLife != nil {
+		spu.ShelfLife = patch.ShelfLife
+	}
+	if patch.Images != nil {
+		spu.Images = patch.Images
+	}
+	if patch.DetailImages != nil {
+		spu.DetailImages = patch.DetailImages
+	}
+	if patch.Recommend != nil {
+		spu.Recommend = *patch.Recommend
+	}
+
+	if specs != nil {
+		spu.Spec = nil
+		for _, spec := range specs {
+			spu.Spec = append(spu.Spec, &Spec{ID: s.id(), SpuID: spuID, Kind: spec.Kind, Value: spec.Value})
+		}
+	}
+
+	if skus != nil {
+		existing := make(map[uint32]bool)
+		for _, sku := range current.Sku {
+			existing[sku.ID] = true
+		}
+
+		keep := make(map[uint32]bool)
+		for _, sku := range skus {
+			keep[sku.ID] = true
+		}
+		for _, sku := range current.Sku {
+			if r := s.skuRefs[sku.ID]; !keep[sku.ID] && (r.carts > 0 || r.orders > 0) {
+				return &SkuReferencedError{ID: sku.ID, Carts: r.carts, Orders: r.orders}
+			}
+		}
+
+		spu.Sku = nil
+		for _, sku := range skus {
+			id := sku.ID
+			if id == 0 {
+				id = s.id()
+			} else if !existing[id] {
+				return &SkuNotFoundError{ID: id}
+			}
+
+			spu.Sku = append(spu.Sku, &Sku{ID: id, Spec: CanonicalSpec(sku.Spec), Price: sku.Price, Stock: sku.Stock})
+		}
+
+		reconcile(&spu)
+	}
+
+	s.spus[spuID] = &spu
+	return nil
+}
+
+func (s *MemoryStore) ModifySpuActive(spuID uint32, active bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	spu, ok := s.spus[spuID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	spu.Active = active
+	return nil
+}
+
+func (s *MemoryStore) DeleteSpu(spuID uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r := s.references[spuID]; r.carts > 0 || r.orders > 0 {
+		return &SpuReferencedError{Carts: r.carts, Orders: r.orders}
+	}
+
+	if _, ok := s.spus[spuID]; !ok {
+		return sql.ErrNoRows
+	}
+
+	delete(s.spus, spuID)
+	return nil
+}
+
+func (s *MemoryStore) ResolveSku(spuID uint32, selection Selection) (*Sku, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if spu, ok := s.spus[spuID]; ok && spu.Active {
+		spec := EncodeSpec(selection)
+		for _, sku := range spu.Sku {
+			if sku.Spec == spec {
+				copied := *sku
+				return &copied, nil
+			}
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) InsertCatagory(catagory *Catagory) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.catagorys[catagory.ParentID]; catagory.ParentID != 0 && !ok {
+		return ErrCatagoryParentNotFound
+	}
+
+	for _, c := range s.catagorys {
+		if c.Name == catagory.Name {
+			return ErrCatagoryNameTaken
+		}
+	}
+
+	stored := *catagory
+	stored.ID = s.id()
+	stored.Children = nil
+	s.catagorys[stored.ID] = &stored
+
+	return nil
+}
+
+func (s *MemoryStore) InfoAllCatagory() ([]*Catagory, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*Catagory
+	for _, c := range s.catagorys {
+		copied := *c
+		result = append(result, &copied)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Sort != result[j].Sort {
+			return result[i].Sort < result[j].Sort
+		}
+		return result[i].ID < result[j].ID
+	})
+
+	return result, nil
+}
+
+func (s *MemoryStore) ModifyCatagory(id uint32, patch *CatagoryPatch) error {
+	if patch.empty() {
+		return ErrEmptyPatch
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.catagorys[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if patch.ParentID != nil {
+		if _, ok := s.catagorys[*patch.ParentID]; *patch.ParentID != 0 && !ok {
+			return ErrCatagoryParentNotFound
+		}
+
+		visited := make(map[uint32]bool)
+		for parentID := *patch.ParentID; parentID != 0 && !visited[parentID]; {
+			if parentID == id {
+				return ErrCatagoryCycle
+			}
+			visited[parentID] = true
+
+			parent, ok := s.catagorys[parentID]
+			if !ok {
+				break
+			}
+			parentID = parent.ParentID
+		}
+	}
+
+	if patch.Name != nil {
+		for _, other := range s.catagorys {
+			if other.ID != id && other.Name == *patch.Name {
+				return ErrCatagoryNameTaken
+			}
+		}
+	}
+
+	if patch.Name != nil {
+		c.Name = *patch.Name
+	}
+	if patch.ParentID != nil {
+		c.ParentID = *patch.ParentID
+	}
+	if patch.Icon != nil {
+		c.Icon = *patch.Icon
+	}
+	if patch.Sort != nil {
+		c.Sort = *patch.Sort
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteCatagory(id uint32, reassignTo uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.catagorys[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	var used []*Spu
+	for _, spu := range s.spus {
+		if spu.CatagoryID == id {
+			used = append(used, spu)
+		}
+	}
+
+	if len(used) > 0 {
+		if reassignTo == 0 || reassignTo == id {
+			return ErrCatagoryInUse
+		}
+
+		if _, ok := s.catagorys[reassignTo]; !ok {
+			return ErrReassignNotFound
+		}
+
+		for _, spu := range used {
+			spu.CatagoryID = reassignTo
+		}
+	}
+
+	for _, child := range s.catagorys {
+		if child.ParentID == id {
+			child.ParentID = c.ParentID
+		}
+	}
+
+	delete(s.catagorys, id)
+	return nil
+}
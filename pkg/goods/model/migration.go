@@ -0,0 +1,162 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/dovics/wx-demo/util/migrate"
+)
+
+// Migrations is the versioned schema of the goods module.
+var Migrations = migrate.Module{
+	Name: "goods",
+	Migrations: []migrate.Migration{
+		{
+			// The tables as the first release created them, frozen here so
+			// later changes of the Create*Table statements do not rewrite
+			// history.
+			Version:     1,
+			Description: "create spu, sku, spec and catagory tables",
+			Up: migrate.Exec(
+				fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s`, DBName),
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+					id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+					catagory_id		BIGINT NOT NULL DEFAULT 0,
+					title			VARCHAR(100) NOT NULL DEFAULT " ",
+					production_code VARCHAR(100) NOT NULL DEFAULT " ",
+					standard_code	VARCHAR(100) NOT NULL DEFAULT " ",
+					inventory		INT NOT NULL DEFAULT 0,
+					price			DOUBLE NOT NULL DEFAULT 9999.99,
+					shelf_life		JSON,
+					images			JSON,
+					detail_images	JSON,
+					recommend		BOOLEAN DEFAULT FALSE,
+					active   		BOOLEAN DEFAULT TRUE,
+					created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (id),
+					INDEX catagory_index (catagory_id)
+				) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, TableName),
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+					id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+					spu_id			BIGINT UNSIGNED NOT NULL,
+					spec			VARCHAR(512) NOT NULL,
+					price			DOUBLE NOT NULL DEFAULT 9999.99,
+					stock			INT UNSIGNED NOT NULL DEFAULT 0,
+					created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (id),
+					INDEX spu_index (spu_id)
+				) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, SkuTableName),
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+					id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+					spu_id			BIGINT UNSIGNED NOT NULL,
+					kind 			VARCHAR(100) NOT NULL,
+					value 			VARCHAR(100) NOT NULL,
+					created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (id),
+					INDEX spu_index (spu_id)
+				) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, SpecTableName),
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+					id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+					name			VARCHAR(100) UNIQUE NOT NULL DEFAULT " ",
+					created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (id)
+				) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, CatagoryTableName),
+			),
+			Down: migrate.Exec(
+				fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, CatagoryTableName),
+				fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, SpecTableName),
+				fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, SkuTableName),
+				fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, TableName),
+			),
+		},
+		{
+			// Sales, price sorting and the catagory tree were added to the
+			// table statements while RegisterRouter still created the tables.
+			// The steps are idempotent so databases created by any of those
+			// releases end up with the same shape.
+			Version:     2,
+			Description: "add spu sales, catagory tree and cents prices",
+			Up: func(db *sql.DB) error {
+				for _, column := range []struct{ table, name, definition string }{
+					{TableName, "sales", "INT NOT NULL DEFAULT 0 AFTER inventory"},
+					{CatagoryTableName, "parent_id", "BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER id"},
+					{CatagoryTableName, "icon", `VARCHAR(512) NOT NULL DEFAULT "" AFTER name`},
+					{CatagoryTableName, "sort", "INT NOT NULL DEFAULT 0 AFTER icon"},
+				} {
+					if err := migrate.AddColumn(db, DBName, column.table, column.name, column.definition); err != nil {
+						return err
+					}
+				}
+
+				// The price column is replaced by its cents column, which
+				// drops the indexes on it, before price_index is added.
+				if err := MigrateMoney(db); err != nil {
+					return err
+				}
+
+				for _, index := range []struct{ table, name, definition string }{
+					{TableName, "price_index", "INDEX price_index (price)"},
+					{TableName, "created_index", "INDEX created_index (created_at)"},
+					{CatagoryTableName, "parent_index", "INDEX parent_index (parent_id)"},
+				} {
+					if err := migrate.AddIndex(db, DBName, index.table, index.name, index.definition); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Down: func(db *sql.DB) error {
+				if err := migrate.Exec(
+					fmt.Sprintf(`ALTER TABLE %s.%s DROP INDEX parent_index, DROP COLUMN sort, DROP COLUMN icon, DROP COLUMN parent_id`,
+						DBName, CatagoryTableName),
+					fmt.Sprintf(`ALTER TABLE %s.%s DROP INDEX created_index, DROP INDEX price_index, DROP COLUMN sales`,
+						DBName, TableName),
+				)(db); err != nil {
+					return err
+				}
+
+				return RevertMoney(db)
+			},
+		},
+		{
+			Version:     3,
+			Description: "add full-text search index",
+			Up:          EnsureSearchIndex,
+			Down: migrate.Exec(
+				fmt.Sprintf(`ALTER TABLE %s.%s DROP INDEX %s`, DBName, TableName, searchIndexName),
+				fmt.Sprintf(`ALTER TABLE %s.%s DROP INDEX %s`, DBName, CatagoryTableName, searchIndexName),
+				fmt.Sprintf(`ALTER TABLE %s.%s DROP INDEX %s`, DBName, SpecTableName, searchIndexName),
+			),
+		},
+		{
+			// The inventory used to be reconciled on every start, this runs it
+			// once for databases upgraded since. It only fixes data, there is
+			// nothing to revert.
+			Version:     4,
+			Description: "reconcile spu inventory with sku stock",
+			Up:          ReconcileInventory,
+			Down:        migrate.Exec(),
+		},
+		{
+			// Specs inserted before they were re-encoded on write keep the
+			// spacing and key order of the operator, ResolveSku never matches
+			// them. The old encoding is lost, Down leaves the specs canonical.
+			Version:     5,
+			Description: "re-encode sku specs canonically",
+			Up:          CanonicalizeSkuSpec,
+			Down:        migrate.Exec(),
+		},
+		{
+			// Version 2 used to add price_index before converting the price
+			// to cents, and the conversion dropped it with the old column.
+			// The index belongs to version 2, which drops it on the way down.
+			Version:     6,
+			Description: "restore spu price index",
+			Up: func(db *sql.DB) error {
+				return migrate.AddIndex(db, DBName, TableName, "price_index", "INDEX price_index (price)")
+			},
+			Down: migrate.Exec(),
+		},
+	},
+}
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const (
+	searchIndexName = "search_index"
+
+	// DefaultSuggestLimit is the default number of suggestions.
+	DefaultSuggestLimit = 10
+)
+
+const (
+	mysqlSearchHasIndex = iota
+	mysqlSearchAddSpuIndex
+	mysqlSearchAddCatagoryIndex
+	mysqlSearchAddSpecIndex
+	mysqlSearchSuggest
+)
+
+var (
+	// searchMatch keeps the spus whose title or codes match the keyword, or
+	// whose catagory name or a spec value does. The subqueries do not depend
+	// on the spu, each runs once on its FULLTEXT index.
+	searchMatch = fmt.Sprintf(`(MATCH (spu.title, spu.production_code, spu.standard_code) AGAINST (? IN NATURAL LANGUAGE MODE) 
+		OR spu.catagory_id IN (SELECT id FROM %s.%s WHERE MATCH (name) AGAINST (? IN NATURAL LANGUAGE MODE)) 
+		OR spu.id IN (SELECT spu_id FROM %s.%s WHERE MATCH (value) AGAINST (? IN NATURAL LANGUAGE MODE)))`,
+		DBName, CatagoryTableName, DBName, SpecTableName)
+
+	// searchRelevance ranks the title and codes of the spu above its
+	// catagory, the spus matched by a spec value only come last.
+	searchRelevance = `MATCH (spu.title, spu.production_code, spu.standard_code) AGAINST (? IN NATURAL LANGUAGE MODE) * 2 
+		+ COALESCE(MATCH (catagory.name) AGAINST (? IN NATURAL LANGUAGE MODE), 0) DESC, spu.id DESC`
+)
+
+var searchSQLString = []string{
+	`SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = ? AND table_name = ? 
+		AND index_name = ?`,
+	fmt.Sprintf(`ALTER TABLE %s.%s ADD FULLTEXT INDEX %s (title, production_code, standard_code) WITH PARSER ngram`,
+		DBName, TableName, searchIndexName),
+	fmt.Sprintf(`ALTER TABLE %s.%s ADD FULLTEXT INDEX %s (name) WITH PARSER ngram`,
+		DBName, CatagoryTableName, searchIndexName),
+	fmt.Sprintf(`ALTER TABLE %s.%s ADD FULLTEXT INDEX %s (value) WITH PARSER ngram`,
+		DBName, SpecTableName, searchIndexName),
+	fmt.Sprintf(`SELECT title FROM (
+			SELECT title, sales FROM %s.%s WHERE active = true AND title LIKE ? 
+			UNION ALL 
+			SELECT name AS title, 0 AS sales FROM %s.%s WHERE name LIKE ?
+		) suggest GROUP BY title ORDER BY MAX(sales) DESC, title LIMIT ?`,
+		DBName, TableName, DBName, CatagoryTableName),
+}
+
+// EnsureSearchIndex adds the ngram FULLTEXT indexes used by the keyword of
+// SpuQuery to the spu, catagory and spec tables if they are missing.
+func EnsureSearchIndex(db *sql.DB) error {
+	for table, stmt := range map[string]string{
+		TableName:         searchSQLString[mysqlSearchAddSpuIndex],
+		CatagoryTableName: searchSQLString[mysqlSearchAddCatagoryIndex],
+		SpecTableName:     searchSQLString[mysqlSearchAddSpecIndex],
+	} {
+		var n int
+		if err := db.QueryRow(searchSQLString[mysqlSearchHasIndex], DBName, table, searchIndexName).Scan(&n); err != nil {
+			return err
+		}
+
+		if n > 0 {
+			continue
+		}
+
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SuggestSpu returns spu titles and catagory names starting with prefix, best
+// selling first.
+func SuggestSpu(db *sql.DB, prefix string, limit int) ([]string, error) {
+	like := escapeLike(prefix) + "%"
+	rows, err := db.Query(searchSQLString[mysqlSearchSuggest], like, like, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []string{}
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return nil, err
+		}
+
+		result = append(result, title)
+	}
+
+	return result, rows.Err()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -2,7 +2,11 @@ package model
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/dovics/wx-demo/util/money"
 )
 
 const SkuTableName = "sku"
@@ -12,6 +16,17 @@ const (
 	mysqlSkuInsert
 	mysqlSkuInfoBySpuID
 	mysqlSkuInfoBySpecAndSpuID
+	mysqlSkuReserve
+	mysqlSkuRelease
+	mysqlSkuReconcileSpu
+	mysqlSkuReconcileBySpuID
+	mysqlSkuReconcileAll
+	mysqlSkuModify
+	mysqlSkuCountByIDAndSpuID
+	mysqlSkuDeleteBySpuID
+	mysqlSkuDeleteNotIn
+	mysqlSkuInfoAllSpec
+	mysqlSkuModifySpec
 )
 
 var skuSQLString = []string{
@@ -19,7 +34,7 @@ var skuSQLString = []string{
 		id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
 		spu_id			BIGINT UNSIGNED NOT NULL,
 		spec			VARCHAR(512) NOT NULL,
-		price			DOUBLE NOT NULL DEFAULT 9999.99,
+		price			BIGINT NOT NULL DEFAULT 999999 COMMENT 'in cents',
 		stock			INT UNSIGNED NOT NULL DEFAULT 0,
 		created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 		PRIMARY KEY (id),
@@ -27,14 +42,36 @@ var skuSQLString = []string{
 	)  ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, SkuTableName),
 	fmt.Sprintf(`INSERT INTO %s.%s (spu_id, spec, price, stock) VALUES (?, ?, ?, ?)`, DBName, SkuTableName),
 	fmt.Sprintf(`SELECT id, spec, price, stock FROM %s.%s WHERE spu_id = ?`, DBName, SkuTableName),
-	fmt.Sprintf(`SELECT id, spec, price, stock FROM %s.%s WHERE spu_id = ? AND spec = ?`, DBName, SkuTableName),
+	fmt.Sprintf(`SELECT sku.id, sku.spec, sku.price, sku.stock FROM %s.%s JOIN %s.%s ON spu.id = sku.spu_id 
+		WHERE sku.spu_id = ? AND sku.spec = ? AND spu.active = TRUE`, DBName, SkuTableName, DBName, TableName),
+	fmt.Sprintf(`UPDATE %s.%s SET stock = stock - ? WHERE id = ? AND stock >= ? LIMIT 1`, DBName, SkuTableName),
+	fmt.Sprintf(`UPDATE %s.%s SET stock = stock + ? WHERE id = ? LIMIT 1`, DBName, SkuTableName),
+	fmt.Sprintf(`UPDATE %s.%s SET inventory = (SELECT COALESCE(SUM(stock), 0) FROM %s.%s WHERE spu_id = spu.id) 
+		WHERE id = (SELECT spu_id FROM %s.%s WHERE id = ?)`,
+		DBName, TableName, DBName, SkuTableName, DBName, SkuTableName),
+	fmt.Sprintf(`UPDATE %s.%s SET inventory = (SELECT COALESCE(SUM(stock), 0) FROM %s.%s WHERE spu_id = spu.id) 
+		WHERE id = ? AND EXISTS (SELECT 1 FROM %s.%s WHERE spu_id = spu.id)`,
+		DBName, TableName, DBName, SkuTableName, DBName, SkuTableName),
+	fmt.Sprintf(`UPDATE %s.%s SET inventory = (SELECT COALESCE(SUM(stock), 0) FROM %s.%s WHERE spu_id = spu.id) 
+		WHERE EXISTS (SELECT 1 FROM %s.%s WHERE spu_id = spu.id)`,
+		DBName, TableName, DBName, SkuTableName, DBName, SkuTableName),
+	fmt.Sprintf(`UPDATE %s.%s SET spec = ?, price = ?, stock = ? WHERE id = ? AND spu_id = ? LIMIT 1`,
+		DBName, SkuTableName),
+	fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s WHERE id = ? AND spu_id = ?`, DBName, SkuTableName),
+	fmt.Sprintf(`DELETE FROM %s.%s WHERE spu_id = ?`, DBName, SkuTableName),
+	fmt.Sprintf(`DELETE FROM %s.%s WHERE spu_id = ? AND id NOT IN (%%s)`, DBName, SkuTableName),
+	fmt.Sprintf(`SELECT id, spec FROM %s.%s FOR UPDATE`, DBName, SkuTableName),
+	fmt.Sprintf(`UPDATE %s.%s SET spec = ? WHERE id = ? LIMIT 1`, DBName, SkuTableName),
 }
 
+// ErrInsufficientStock returned when the sku has not enough stock to reserve.
+var ErrInsufficientStock = errors.New("insufficient sku stock")
+
 type Sku struct {
-	ID    uint32  `json:"id,omitempty"`
-	Spec  string  `json:"spec,omitempty"`
-	Price float64 `json:"price,omitempty"`
-	Stock uint32  `json:"stock,omitempty"`
+	ID    uint32      `json:"id,omitempty"`
+	Spec  string      `json:"spec,omitempty"`
+	Price money.Money `json:"price,omitempty"`
+	Stock uint32      `json:"stock,omitempty"`
 }
 
 func CreateSkuTable(db *sql.DB) error {
@@ -46,8 +83,8 @@ func CreateSkuTable(db *sql.DB) error {
 	return nil
 }
 
-func TxInsertSku(tx *sql.Tx, spuID uint32, spec string, price float64, stock uint32) error {
-	result, err := tx.Exec(skuSQLString[mysqlSkuInsert], spuID, spec, price, stock)
+func TxInsertSku(tx *sql.Tx, spuID uint32, spec string, price money.Money, stock uint32) error {
+	result, err := tx.Exec(skuSQLString[mysqlSkuInsert], spuID, CanonicalSpec(spec), price, stock)
 	if err != nil {
 		return err
 	}
@@ -59,9 +96,49 @@ func TxInsertSku(tx *sql.Tx, spuID uint32, spec string, price float64, stock uin
 	return nil
 }
 
+// TxModifySku updates the sku of the spu, it returns sql.ErrNoRows if the sku
+// does not belong to the spu.
+func TxModifySku(tx *sql.Tx, spuID uint32, sku *Sku) error {
+	result, err := tx.Exec(skuSQLString[mysqlSkuModify], CanonicalSpec(sku.Spec), sku.Price, sku.Stock, sku.ID, spuID)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		var n int
+		if err := tx.QueryRow(skuSQLString[mysqlSkuCountByIDAndSpuID], sku.ID, spuID).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			return sql.ErrNoRows
+		}
+	}
+
+	return nil
+}
+
+// TxDeleteSkuNotIn removes the skus of the spu except keepIDs
+func TxDeleteSkuNotIn(tx *sql.Tx, spuID uint32, keepIDs []uint32) error {
+	if len(keepIDs) == 0 {
+		_, err := tx.Exec(skuSQLString[mysqlSkuDeleteBySpuID], spuID)
+		return err
+	}
+
+	args := []interface{}{spuID}
+	for _, id := range keepIDs {
+		args = append(args, id)
+	}
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(keepIDs)), ", ")
+
+	_, err := tx.Exec(fmt.Sprintf(skuSQLString[mysqlSkuDeleteNotIn], in), args...)
+	return err
+}
+
+// InfoSkuBySpecAndSpuID returns the sku of the active spu with the spec, or
+// sql.ErrNoRows if there is none.
 func InfoSkuBySpecAndSpuID(db *sql.DB, spuID uint32, spec string) (*Sku, error) {
 	var sku Sku
-	if err := db.QueryRow(skuSQLString[mysqlSkuInfoBySpecAndSpuID]).Scan(
+	if err := db.QueryRow(skuSQLString[mysqlSkuInfoBySpecAndSpuID], spuID, spec).Scan(
 		&sku.ID, &sku.Spec, &sku.Price, &sku.Stock); err != nil {
 		return nil, err
 	}
@@ -69,6 +146,11 @@ func InfoSkuBySpecAndSpuID(db *sql.DB, spuID uint32, spec string) (*Sku, error)
 	return &sku, nil
 }
 
+// ResolveSku returns the sku of the spu matching the full selection.
+func ResolveSku(db *sql.DB, spuID uint32, selection Selection) (*Sku, error) {
+	return InfoSkuBySpecAndSpuID(db, spuID, EncodeSpec(selection))
+}
+
 func TxInfoSkuBySpuID(tx *sql.Tx, spuID uint32) ([]*Sku, error) {
 	rows, err := tx.Query(skuSQLString[mysqlSkuInfoBySpuID], spuID)
 	if err != nil {
@@ -80,7 +162,7 @@ func TxInfoSkuBySpuID(tx *sql.Tx, spuID uint32) ([]*Sku, error) {
 		var (
 			id    uint32
 			spec  string
-			price float64
+			price money.Money
 			stock uint32
 		)
 		if err := rows.Scan(&id, &spec, &price, &stock); err != nil {
@@ -97,3 +179,96 @@ func TxInfoSkuBySpuID(tx *sql.Tx, spuID uint32) ([]*Sku, error) {
 
 	return result, nil
 }
+
+// TxReserveSku takes count out of the sku stock, it fails with ErrInsufficientStock
+// instead of letting the stock go below zero. The spu inventory is reconciled.
+func TxReserveSku(tx *sql.Tx, skuID uint32, count uint32) error {
+	result, err := tx.Exec(skuSQLString[mysqlSkuReserve], count, skuID, count)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrInsufficientStock
+	}
+
+	return txReconcileInventoryBySkuID(tx, skuID)
+}
+
+// TxReleaseSku puts count back to the sku stock and reconciles the spu
+// inventory. A deleted sku has nothing to restock: a completed order can be
+// refunded after its sku was removed.
+func TxReleaseSku(tx *sql.Tx, skuID uint32, count uint32) error {
+	result, err := tx.Exec(skuSQLString[mysqlSkuRelease], count, skuID)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil
+	}
+
+	return txReconcileInventoryBySkuID(tx, skuID)
+}
+
+func txReconcileInventoryBySkuID(tx *sql.Tx, skuID uint32) error {
+	_, err := tx.Exec(skuSQLString[mysqlSkuReconcileSpu], skuID)
+	return err
+}
+
+// TxReconcileInventory sets the inventory of the spu to the sum of its sku
+// stock. A spu without skus keeps its own inventory.
+func TxReconcileInventory(tx *sql.Tx, spuID uint32) error {
+	_, err := tx.Exec(skuSQLString[mysqlSkuReconcileBySpuID], spuID)
+	return err
+}
+
+// CanonicalizeSkuSpec re-encodes the specs stored before they were made
+// canonical, ResolveSku compares the encoded selection with them.
+func CanonicalizeSkuSpec(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(skuSQLString[mysqlSkuInfoAllSpec])
+	if err != nil {
+		return err
+	}
+
+	changed := make(map[uint32]string)
+	for rows.Next() {
+		var (
+			id   uint32
+			spec string
+		)
+		if err := rows.Scan(&id, &spec); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if canonical := CanonicalSpec(spec); canonical != spec {
+			changed[id] = canonical
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, spec := range changed {
+		if _, err := tx.Exec(skuSQLString[mysqlSkuModifySpec], spec, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReconcileInventory sets the inventory of every spu with skus to the sum of
+// its sku stock, it repairs inventories drifted by hand edits of the tables.
+func ReconcileInventory(db *sql.DB) error {
+	_, err := db.Exec(skuSQLString[mysqlSkuReconcileAll])
+	return err
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 )
 
@@ -11,6 +12,7 @@ const (
 	mysqlSpecCreateTable = iota
 	mysqlSpecInsert
 	mysqlSpecInfoBySpuID
+	mysqlSpecDeleteBySpuID
 )
 
 var specSQLString = []string{
@@ -25,6 +27,7 @@ var specSQLString = []string{
 	)  ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, SpecTableName),
 	fmt.Sprintf(`INSERT INTO %s.%s (spu_id, kind, value) VALUES (?, ?, ?)`, DBName, SpecTableName),
 	fmt.Sprintf(`SELECT id, spu_id, kind, value FROM %s.%s WHERE spu_id = ?`, DBName, SpecTableName),
+	fmt.Sprintf(`DELETE FROM %s.%s WHERE spu_id = ?`, DBName, SpecTableName),
 }
 
 func CreateSpecTable(db *sql.DB) error {
@@ -49,6 +52,12 @@ func TxInsertSpec(tx *sql.Tx, spuID uint32, kind string, value string) error {
 	return nil
 }
 
+// TxDeleteSpecBySpuID removes the specs of the spu
+func TxDeleteSpecBySpuID(tx *sql.Tx, spuID uint32) error {
+	_, err := tx.Exec(specSQLString[mysqlSpecDeleteBySpuID], spuID)
+	return err
+}
+
 type Spec struct {
 	ID    uint32 `json:"id,omitempty"`
 	SpuID uint32 `json:"spu_id,omitempty"`
@@ -84,3 +93,87 @@ func TxInfoSpecBySpuID(tx *sql.Tx, spuID uint32) ([]*Spec, error) {
 
 	return result, nil
 }
+
+// Selection maps spec kinds to the selected values.
+type Selection map[string]string
+
+// EncodeSpec returns the canonical sku spec of a selection, a JSON object with
+// sorted kinds, e.g. {"color":"red","size":"L"}.
+func EncodeSpec(selection Selection) string {
+	if selection == nil {
+		selection = Selection{}
+	}
+
+	// encoding/json sorts map keys, and a map of strings can not fail.
+	buf, _ := json.Marshal(selection)
+	return string(buf)
+}
+
+// ParseSpec parses a canonical sku spec.
+func ParseSpec(spec string) (Selection, error) {
+	var selection Selection
+	if err := json.Unmarshal([]byte(spec), &selection); err != nil {
+		return nil, err
+	}
+
+	return selection, nil
+}
+
+// CanonicalSpec re-encodes the spec if it is a JSON object, otherwise it is
+// returned unchanged.
+func CanonicalSpec(spec string) string {
+	selection, err := ParseSpec(spec)
+	if err != nil || selection == nil {
+		return spec
+	}
+
+	return EncodeSpec(selection)
+}
+
+// SpecValue is a value of a spec kind and whether a sku in stock can still be
+// bought when it is selected.
+type SpecValue struct {
+	Value     string `json:"value"`
+	Available bool   `json:"available"`
+}
+
+// AvailableSpec returns, for every spec kind, its values and whether each one
+// leads to a sku in stock when combined with the selection of the other kinds.
+func AvailableSpec(specs []*Spec, skus []*Sku, selection Selection) map[string][]*SpecValue {
+	var inStock []Selection
+	for _, sku := range skus {
+		if sku.Stock == 0 {
+			continue
+		}
+
+		if s, err := ParseSpec(sku.Spec); err == nil {
+			inStock = append(inStock, s)
+		}
+	}
+
+	result := make(map[string][]*SpecValue)
+	for _, spec := range specs {
+		value := &SpecValue{Value: spec.Value}
+		for _, s := range inStock {
+			if s[spec.Kind] == spec.Value && matches(s, selection, spec.Kind) {
+				value.Available = true
+				break
+			}
+		}
+
+		result[spec.Kind] = append(result[spec.Kind], value)
+	}
+
+	return result
+}
+
+// matches reports whether the sku spec agrees with every selected kind but skip.
+func matches(sku Selection, selection Selection, skip string) bool {
+	for kind, value := range selection {
+		if kind != skip && sku[kind] != value {
+			return false
+		}
+	}
+
+	return true
+}
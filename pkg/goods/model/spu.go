@@ -5,7 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/dovics/wx-demo/util/money"
+	sqlutil "github.com/dovics/wx-demo/util/sql"
 )
 
 const (
@@ -17,14 +21,21 @@ const (
 	mysqlSpuCreateDatabase = iota
 	mysqlSpuCreateTable
 	mysqlSpuInsert
-	mysqlSpuInfoByCatagory
-	mysqlSpuInfoRecommend
+	mysqlSpuList
+	mysqlSpuCount
 	mysqlSpuInfoByID
+	mysqlSpuModify
+	mysqlSpuModifyActive
+	mysqlSpuDelete
+	mysqlSpuAddSales
 )
 
 var (
 	errInvalidMysql = errors.New("affected 0 rows")
 
+	// ErrEmptyPatch returned when a patch does not modify any field.
+	ErrEmptyPatch = errors.New("nothing to modify")
+
 	spuSQLString = []string{
 		fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s ;`, DBName),
 		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
@@ -34,7 +45,8 @@ var (
 			production_code VARCHAR(100) NOT NULL DEFAULT " ",
 			standard_code	VARCHAR(100) NOT NULL DEFAULT " ",
 			inventory		INT NOT NULL DEFAULT 0,
-			price			DOUBLE NOT NULL DEFAULT 9999.99,
+			sales			INT NOT NULL DEFAULT 0,
+			price			BIGINT NOT NULL DEFAULT 999999 COMMENT 'in cents',
 			shelf_life		JSON,
 			images			JSON,
 			detail_images	JSON,
@@ -43,19 +55,23 @@ var (
 			active   		BOOLEAN DEFAULT TRUE,
 			created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			PRIMARY KEY (id),
-			INDEX catagory_index (catagory_id)
+			INDEX catagory_index (catagory_id),
+			INDEX price_index (price),
+			INDEX created_index (created_at)
 		)  ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, TableName),
 		fmt.Sprintf(`INSERT INTO %s.%s (catagory_id, title, production_code, standard_code, inventory, 
 			price, shelf_life, images, detail_images, recommend) 
 			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, DBName, TableName),
-		fmt.Sprintf(`SELECT spu.id, catagory.name as catagory, spu.title, spu.images, spu.price 
-			FROM %s.%s LEFT JOIN goods.catagory ON catagory.id = spu.catagory_id 
-			WHERE spu.catagory_id = ? AND spu.active = true`, DBName, TableName),
-		fmt.Sprintf(`SELECT spu.id, catagory.name as catagory, spu.title, spu.images, spu.price 
-			FROM %s.%s LEFT JOIN goods.catagory ON catagory.id = spu.catagory_id 
-			WHERE spu.recommend = true AND spu.active = true`, DBName, TableName),
+		fmt.Sprintf(`SELECT spu.id, COALESCE(catagory.name, "") as catagory, spu.title, spu.images, spu.price 
+			FROM %s.%s LEFT JOIN %s.%s ON catagory.id = spu.catagory_id 
+			WHERE %%s ORDER BY %%s`, DBName, TableName, DBName, CatagoryTableName),
+		fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s WHERE %%s`, DBName, TableName),
 		fmt.Sprintf(`SELECT id, catagory_id, title, production_code, standard_code, inventory, 
 		shelf_life, images, detail_images, created_at FROM %s.%s WHERE id = ?`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET %%s WHERE id = ? LIMIT 1`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET active = ? WHERE id = ? LIMIT 1`, DBName, TableName),
+		fmt.Sprintf(`DELETE FROM %s.%s WHERE id = ? LIMIT 1`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET sales = sales + ? WHERE id = ? LIMIT 1`, DBName, TableName),
 	}
 )
 
@@ -68,7 +84,8 @@ type Spu struct {
 	ProductionCode string      `json:"production_code,omitempty"`
 	StandardCode   string      `json:"standard_code,omitempty"`
 	Inventory      uint32      `json:"inventory,omitempty"`
-	Price          float64     `json:"price,omitempty"`
+	Sales          uint32      `json:"sales,omitempty"`
+	Price          money.Money `json:"price,omitempty"`
 	ShelfLife      interface{} `json:"shelf_life,omitempty"`
 	Images         interface{} `json:"images,omitempty"`
 	DetailImages   interface{} `json:"detail_images,omitempty"`
@@ -136,66 +153,19 @@ func TxInsertSpu(tx *sql.Tx, spu Spu) (uint32, error) {
 
 // GetSpuByCatagory returns the spu belong to catagory
 func GetSpuByCatagory(db *sql.DB, catagoryID uint32) ([]*Spu, error) {
-	rows, err := db.Query(spuSQLString[mysqlSpuInfoByCatagory], catagoryID)
-	if err != nil {
-		return nil, err
-	}
-
-	var result []*Spu
-	for rows.Next() {
-		var (
-			id           uint32
-			catagoryName string
-			title        string
-			images       string
-			price        float64
-		)
-		if err := rows.Scan(&id, &catagoryName, &title, &images, &price); err != nil {
-			return nil, err
-		}
-
-		result = append(result, &Spu{
-			ID:           id,
-			CatagoryName: catagoryName,
-			Title:        title,
-			Images:       images,
-			Price:        price,
-		})
-	}
+	return GetSpuByCatagorys(db, []uint32{catagoryID})
+}
 
-	return result, nil
+// GetSpuByCatagorys returns the spu belong to any of the catagorys
+func GetSpuByCatagorys(db *sql.DB, catagoryIDs []uint32) ([]*Spu, error) {
+	spus, _, err := QuerySpu(db, &SpuQuery{CatagoryIDs: catagoryIDs})
+	return spus, err
 }
 
-// GetSpuByCatagory returns the spu belong to catagory
+// GetRecommendSpu returns the recommended spu
 func GetRecommendSpu(db *sql.DB) ([]*Spu, error) {
-	rows, err := db.Query(spuSQLString[mysqlSpuInfoRecommend])
-	if err != nil {
-		return nil, err
-	}
-
-	var result []*Spu
-	for rows.Next() {
-		var (
-			id           uint32
-			catagoryName string
-			title        string
-			images       string
-			price        float64
-		)
-		if err := rows.Scan(&id, &catagoryName, &title, &images, &price); err != nil {
-			return nil, err
-		}
-
-		result = append(result, &Spu{
-			ID:           id,
-			CatagoryName: catagoryName,
-			Title:        title,
-			Images:       images,
-			Price:        price,
-		})
-	}
-
-	return result, nil
+	spus, _, err := QuerySpu(db, &SpuQuery{Recommend: true})
+	return spus, err
 }
 
 func TxInfoSpuByID(tx *sql.Tx, spuID uint32) (*Spu, error) {
@@ -229,3 +199,149 @@ func TxInfoSpuByID(tx *sql.Tx, spuID uint32) (*Spu, error) {
 		CreatedAt:      createdAt,
 	}, nil
 }
+
+// SpuPatch holds the spu fields to modify, nil fields are kept.
+type SpuPatch struct {
+	CatagoryID     *uint32      `json:"catagory_id"`
+	Title          *string      `json:"title"`
+	ProductionCode *string      `json:"production_code"`
+	StandardCode   *string      `json:"standard_code"`
+	Price          *money.Money `json:"price"`
+	ShelfLife      interface{}  `json:"shelf_life"`
+	Images         interface{}  `json:"images"`
+	DetailImages   interface{}  `json:"detail_images"`
+	Recommend      *bool        `json:"recommend"`
+}
+
+// TxModifySpu updates the non nil fields of the patch.
+func TxModifySpu(tx *sql.Tx, spuID uint32, patch *SpuPatch) error {
+	var (
+		columns []string
+		args    []interface{}
+	)
+	set := func(column string, value interface{}) {
+		columns = append(columns, column+" = ?")
+		args = append(args, value)
+	}
+	setJSON := func(column string, value interface{}) error {
+		buf, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		set(column, buf)
+		return nil
+	}
+
+	if patch.CatagoryID != nil {
+		set("catagory_id", *patch.CatagoryID)
+	}
+	if patch.Title != nil {
+		set("title", *patch.Title)
+	}
+	if patch.ProductionCode != nil {
+		set("production_code", *patch.ProductionCode)
+	}
+	if patch.StandardCode != nil {
+		set("standard_code", *patch.StandardCode)
+	}
+	if patch.Price != nil {
+		set("price", *patch.Price)
+	}
+	if patch.ShelfLife != nil {
+		if err := setJSON("shelf_life", patch.ShelfLife); err != nil {
+			return err
+		}
+	}
+	if patch.Images != nil {
+		if err := setJSON("images", patch.Images); err != nil {
+			return err
+		}
+	}
+	if patch.DetailImages != nil {
+		if err := setJSON("detail_images", patch.DetailImages); err != nil {
+			return err
+		}
+	}
+	if patch.Recommend != nil {
+		set("recommend", *patch.Recommend)
+	}
+
+	if len(columns) == 0 {
+		return ErrEmptyPatch
+	}
+
+	query := fmt.Sprintf(spuSQLString[mysqlSpuModify], strings.Join(columns, ", "))
+	_, err := tx.Exec(query, append(args, spuID)...)
+	return err
+}
+
+// ModifySpuActive lists or delists the spu, it returns sql.ErrNoRows if there
+// is no such spu.
+func ModifySpuActive(db *sql.DB, spuID uint32, active bool) error {
+	result, err := db.Exec(spuSQLString[mysqlSpuModifyActive], active, spuID)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		// nothing changed if the spu already has the value.
+		var n int
+		if err := db.QueryRow(fmt.Sprintf(spuSQLString[mysqlSpuCount], "id = ?"), spuID).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			return sql.ErrNoRows
+		}
+	}
+
+	return nil
+}
+
+// TxDeleteSpu removes the spu with its specs and skus
+func TxDeleteSpu(tx *sql.Tx, spuID uint32) error {
+	if err := TxDeleteSpecBySpuID(tx, spuID); err != nil {
+		return err
+	}
+
+	if err := TxDeleteSkuNotIn(tx, spuID, nil); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(spuSQLString[mysqlSpuDelete], spuID)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// TxAddSpuSales adds count to the sales of the spu
+func TxAddSpuSales(tx *sql.Tx, spuID uint32, count uint32) error {
+	_, err := tx.Exec(spuSQLString[mysqlSpuAddSales], count, spuID)
+	return err
+}
+
+// MigrateMoney converts the DOUBLE prices of spu and sku tables created before
+// money was stored in cents.
+func MigrateMoney(db *sql.DB) error {
+	definition := "BIGINT NOT NULL DEFAULT 999999 COMMENT 'in cents'"
+	if err := sqlutil.ConvertDoubleToCents(db, DBName, TableName, "price", definition); err != nil {
+		return err
+	}
+
+	return sqlutil.ConvertDoubleToCents(db, DBName, SkuTableName, "price", definition)
+}
+
+// RevertMoney converts the prices of spu and sku tables back to DOUBLE.
+func RevertMoney(db *sql.DB) error {
+	definition := "DOUBLE NOT NULL DEFAULT 9999.99"
+	if err := sqlutil.ConvertCentsToDouble(db, DBName, TableName, "price", definition); err != nil {
+		return err
+	}
+
+	return sqlutil.ConvertCentsToDouble(db, DBName, SkuTableName, "price", definition)
+}
@@ -0,0 +1,348 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+
+	cart "github.com/dovics/wx-demo/pkg/cart/model"
+	order "github.com/dovics/wx-demo/pkg/order/model"
+)
+
+// SpuReferencedError is returned when deleting a spu that carts or open
+// orders still reference.
+type SpuReferencedError struct {
+	Carts  int
+	Orders int
+}
+
+func (e *SpuReferencedError) Error() string {
+	return fmt.Sprintf("the spu is referenced by %d open orders and %d carts", e.Orders, e.Carts)
+}
+
+// SkuReferencedError is returned when a modification removes a sku that carts
+// or open orders still reference, cancelling or refunding such an order puts
+// the stock back to the sku.
+type SkuReferencedError struct {
+	ID     uint32
+	Carts  int
+	Orders int
+}
+
+func (e *SkuReferencedError) Error() string {
+	return fmt.Sprintf("sku %d is referenced by %d open orders and %d carts", e.ID, e.Orders, e.Carts)
+}
+
+// SkuNotFoundError is returned when a modified sku does not belong to the spu.
+type SkuNotFoundError struct {
+	ID uint32
+}
+
+func (e *SkuNotFoundError) Error() string {
+	return fmt.Sprintf("sku %d does not belong to the spu", e.ID)
+}
+
+// Store is the persistence of spus and catagorys used by the controllers.
+type Store interface {
+	// InsertSpu adds the spu with its specs and skus.
+	InsertSpu(spu *Spu) (uint32, error)
+	QuerySpu(query *SpuQuery) ([]*Spu, int, error)
+	SuggestSpu(prefix string, limit int) ([]string, error)
+	// InfoSpuDetail returns the spu with its specs and skus, or sql.ErrNoRows.
+	InfoSpuDetail(spuID uint32) (*Spu, error)
+	InfoSpecAndSku(spuID uint32) ([]*Spec, []*Sku, error)
+	// ModifySpu applies the patch. Non nil specs replace the specs of the
+	// spu, non nil skus are diffed: skus with id are updated, skus without id
+	// are inserted and the missing ones are deleted. It returns
+	// *SkuReferencedError while carts or open orders reference a missing one.
+	ModifySpu(spuID uint32, patch *SpuPatch, specs []*Spec, skus []*Sku) error
+	// ModifySpuActive lists or delists the spu, it returns sql.ErrNoRows for
+	// an unknown spu.
+	ModifySpuActive(spuID uint32, active bool) error
+	// DeleteSpu removes the spu, it returns *SpuReferencedError while carts or
+	// open orders reference it.
+	DeleteSpu(spuID uint32) error
+	ResolveSku(spuID uint32, selection Selection) (*Sku, error)
+
+	InsertCatagory(catagory *Catagory) error
+	InfoAllCatagory() ([]*Catagory, error)
+	// ModifyCatagory applies the non nil fields of the patch. It returns
+	// sql.ErrNoRows for an unknown catagory, ErrEmptyPatch and ErrCatagoryCycle.
+	ModifyCatagory(id uint32, patch *CatagoryPatch) error
+	DeleteCatagory(id uint32, reassignTo uint32) error
+}
+
+type mysqlStore struct {
+	db *sql.DB
+}
+
+// NewMySQLStore returns the Store backed by the goods schema.
+func NewMySQLStore(db *sql.DB) Store {
+	return &mysqlStore{db: db}
+}
+
+func (s *mysqlStore) InsertSpu(spu *Spu) (uint32, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	spuID, err := TxInsertSpu(tx, *spu)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, spec := range spu.Spec {
+		if err := TxInsertSpec(tx, spuID, spec.Kind, spec.Value); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, sku := range spu.Sku {
+		if err := TxInsertSku(tx, spuID, sku.Spec, sku.Price, sku.Stock); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(spu.Sku) > 0 {
+		if err := TxReconcileInventory(tx, spuID); err != nil {
+			return 0, err
+		}
+	}
+
+	return spuID, tx.Commit()
+}
+
+func (s *mysqlStore) QuerySpu(query *SpuQuery) ([]*Spu, int, error) {
+	return QuerySpu(s.db, query)
+}
+
+func (s *mysqlStore) SuggestSpu(prefix string, limit int) ([]string, error) {
+	return SuggestSpu(s.db, prefix, limit)
+}
+
+func (s *mysqlStore) InfoSpuDetail(spuID uint32) (*Spu, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	spu, err := TxInfoSpuByID(tx, spuID)
+	if err != nil {
+		return nil, err
+	}
+
+	if spu.Spec, err = TxInfoSpecBySpuID(tx, spuID); err != nil {
+		return nil, err
+	}
+
+	if spu.Sku, err = TxInfoSkuBySpuID(tx, spuID); err != nil {
+		return nil, err
+	}
+
+	return spu, tx.Commit()
+}
+
+func (s *mysqlStore) InfoSpecAndSku(spuID uint32) ([]*Spec, []*Sku, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	specs, err := TxInfoSpecBySpuID(tx, spuID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	skus, err := TxInfoSkuBySpuID(tx, spuID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return specs, skus, tx.Commit()
+}
+
+func (s *mysqlStore) ModifySpu(spuID uint32, patch *SpuPatch, specs []*Spec, skus []*Sku) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = TxModifySpu(tx, spuID, patch)
+	if err == ErrEmptyPatch && (specs != nil || skus != nil) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if specs != nil {
+		if err := TxDeleteSpecBySpuID(tx, spuID); err != nil {
+			return err
+		}
+
+		for _, spec := range specs {
+			if err := TxInsertSpec(tx, spuID, spec.Kind, spec.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if skus != nil {
+		var keep []uint32
+		for _, sku := range skus {
+			if sku.ID != 0 {
+				keep = append(keep, sku.ID)
+			}
+		}
+
+		if err := txCheckSkuNotIn(tx, spuID, keep); err != nil {
+			return err
+		}
+
+		if err := TxDeleteSkuNotIn(tx, spuID, keep); err != nil {
+			return err
+		}
+
+		for _, sku := range skus {
+			if sku.ID == 0 {
+				err = TxInsertSku(tx, spuID, sku.Spec, sku.Price, sku.Stock)
+			} else {
+				err = TxModifySku(tx, spuID, sku)
+			}
+			if err == sql.ErrNoRows {
+				return &SkuNotFoundError{ID: sku.ID}
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := TxReconcileInventory(tx, spuID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// txCheckSkuNotIn returns *SkuReferencedError if a sku of the spu except
+// keepIDs is referenced by carts or open orders.
+func txCheckSkuNotIn(tx *sql.Tx, spuID uint32, keepIDs []uint32) error {
+	skus, err := TxInfoSkuBySpuID(tx, spuID)
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[uint32]bool)
+	for _, id := range keepIDs {
+		keep[id] = true
+	}
+
+	for _, sku := range skus {
+		if keep[sku.ID] {
+			continue
+		}
+
+		carts, err := cart.TxCountCartBySkuID(tx, sku.ID)
+		if err != nil {
+			return err
+		}
+
+		orders, err := order.TxCountOpenItemBySkuID(tx, sku.ID)
+		if err != nil {
+			return err
+		}
+
+		if carts > 0 || orders > 0 {
+			return &SkuReferencedError{ID: sku.ID, Carts: carts, Orders: orders}
+		}
+	}
+
+	return nil
+}
+
+func (s *mysqlStore) ModifySpuActive(spuID uint32, active bool) error {
+	return ModifySpuActive(s.db, spuID, active)
+}
+
+func (s *mysqlStore) DeleteSpu(spuID uint32) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	carts, err := cart.TxCountCartBySpuID(tx, spuID)
+	if err != nil {
+		return err
+	}
+
+	orders, err := order.TxCountOpenItemBySpuID(tx, spuID)
+	if err != nil {
+		return err
+	}
+
+	if carts > 0 || orders > 0 {
+		return &SpuReferencedError{Carts: carts, Orders: orders}
+	}
+
+	if err := TxDeleteSpu(tx, spuID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *mysqlStore) ResolveSku(spuID uint32, selection Selection) (*Sku, error) {
+	return ResolveSku(s.db, spuID, selection)
+}
+
+func (s *mysqlStore) InsertCatagory(catagory *Catagory) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := TxInsertCatagory(tx, catagory); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *mysqlStore) InfoAllCatagory() ([]*Catagory, error) {
+	return InfoAllCatagory(s.db)
+}
+
+func (s *mysqlStore) ModifyCatagory(id uint32, patch *CatagoryPatch) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := TxModifyCatagory(tx, id, patch); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *mysqlStore) DeleteCatagory(id uint32, reassignTo uint32) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := TxDeleteCatagory(tx, id, reassignTo); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
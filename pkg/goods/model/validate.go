@@ -0,0 +1,151 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError is a validation error of a field of a spu payload.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a spu payload.
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, f := range e {
+		messages[i] = f.Field + ": " + f.Message
+	}
+	return "invalid spu: " + strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	*e = append(*e, &FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateSpu checks that the specs and skus of the spu are consistent: spec
+// kinds and values are set and unique, every sku selects exactly one existing
+// value of every kind, no two skus share a combination and prices are not
+// negative. If the spu has skus its price and inventory are derived from them.
+func ValidateSpu(spu *Spu) error {
+	var errs ValidationError
+
+	errs.addFields(spu)
+
+	values := make(map[string]map[string]bool)
+	for i, spec := range spu.Spec {
+		field := fmt.Sprintf("spec[%d]", i)
+		if strings.TrimSpace(spec.Kind) == "" {
+			errs.add(field+".kind", "is required")
+			continue
+		}
+		if strings.TrimSpace(spec.Value) == "" {
+			errs.add(field+".value", "is required")
+			continue
+		}
+
+		if values[spec.Kind] == nil {
+			values[spec.Kind] = make(map[string]bool)
+		}
+		if values[spec.Kind][spec.Value] {
+			errs.add(field, "duplicate value %q of kind %q", spec.Value, spec.Kind)
+		}
+		values[spec.Kind][spec.Value] = true
+	}
+
+	combinations := make(map[string]int)
+	for i, sku := range spu.Sku {
+		field := fmt.Sprintf("sku[%d]", i)
+		if sku.Price.IsNegative() {
+			errs.add(field+".price", "must not be negative")
+		}
+
+		selection, err := ParseSpec(sku.Spec)
+		if err != nil {
+			errs.add(field+".spec", "must be a JSON object of spec kind to value")
+			continue
+		}
+
+		valid := true
+		for kind, value := range selection {
+			if values[kind] == nil {
+				errs.add(field+".spec", "unknown kind %q", kind)
+				valid = false
+			} else if !values[kind][value] {
+				errs.add(field+".spec", "unknown value %q of kind %q", value, kind)
+				valid = false
+			}
+		}
+		for kind := range values {
+			if _, ok := selection[kind]; !ok {
+				errs.add(field+".spec", "missing kind %q", kind)
+				valid = false
+			}
+		}
+		if !valid {
+			continue
+		}
+
+		spec := EncodeSpec(selection)
+		if j, ok := combinations[spec]; ok {
+			errs.add(field+".spec", "duplicates sku[%d]", j)
+			continue
+		}
+		combinations[spec] = i
+		sku.Spec = spec
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	DeriveSpu(spu)
+	return nil
+}
+
+// ValidateSpuFields checks the fields of the spu but not its specs and skus,
+// for a modification that leaves them alone. Skus stored before they were
+// validated do not block it. The price and inventory are derived like
+// ValidateSpu does.
+func ValidateSpuFields(spu *Spu) error {
+	var errs ValidationError
+
+	errs.addFields(spu)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	DeriveSpu(spu)
+	return nil
+}
+
+// addFields checks the title and, for a spu without skus, the price.
+func (e *ValidationError) addFields(spu *Spu) {
+	if strings.TrimSpace(spu.Title) == "" {
+		e.add("title", "is required")
+	}
+
+	if len(spu.Sku) == 0 && spu.Price.IsNegative() {
+		e.add("price", "must not be negative")
+	}
+}
+
+// DeriveSpu sets the display price of the spu to its lowest sku price and its
+// inventory to the sum of the sku stock. It does nothing for a spu without skus.
+func DeriveSpu(spu *Spu) {
+	if len(spu.Sku) == 0 {
+		return
+	}
+
+	spu.Price = spu.Sku[0].Price
+	spu.Inventory = 0
+	for _, sku := range spu.Sku {
+		if sku.Price.Cmp(spu.Price) < 0 {
+			spu.Price = sku.Price
+		}
+		spu.Inventory += sku.Stock
+	}
+}
@@ -0,0 +1,267 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	goods "github.com/dovics/wx-demo/pkg/goods/model"
+	"github.com/dovics/wx-demo/pkg/order/model"
+	"github.com/dovics/wx-demo/util/user"
+	"github.com/dovics/wx-demo/util/wxpay"
+	"github.com/gin-gonic/gin"
+)
+
+type OrderController struct {
+	store model.Store
+	wxpay *wxpay.Client
+}
+
+func New(db *sql.DB) *OrderController {
+	payClient, err := newPayClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return NewWithStore(model.NewMySQLStore(db, inventory{}), payClient)
+}
+
+// NewWithStore returns a controller using store, pay is disabled if payClient
+// is nil.
+func NewWithStore(store model.Store, payClient *wxpay.Client) *OrderController {
+	return &OrderController{
+		store: store,
+		wxpay: payClient,
+	}
+}
+
+// inventory is the model.Inventory of the goods schema.
+type inventory struct{}
+
+func (inventory) TxReserveSku(tx *sql.Tx, skuID uint32, count uint32) error {
+	err := goods.TxReserveSku(tx, skuID, count)
+	if err == goods.ErrInsufficientStock {
+		return model.ErrInsufficientStock
+	}
+	return err
+}
+
+func (inventory) TxReleaseSku(tx *sql.Tx, skuID uint32, count uint32) error {
+	return goods.TxReleaseSku(tx, skuID, count)
+}
+
+func (inventory) TxAddSpuSales(tx *sql.Tx, spuID uint32, count uint32) error {
+	return goods.TxAddSpuSales(tx, spuID, count)
+}
+
+// RegisterRouter register router. It fatal because there is no service if register failed.
+func (c *OrderController) RegisterRouter(r gin.IRouter) {
+	if r == nil {
+		log.Fatal("[InitRouter]: server is nil")
+	}
+
+	r.POST("/checkout", c.checkout)
+	r.GET("/info", c.info)
+	r.GET("/info/detail", c.infoDetail)
+	r.POST("/cancel", c.cancel)
+	r.POST("/confirm", c.confirm)
+	r.POST("/pay", c.pay)
+	r.POST("/refund", c.refund)
+}
+
+// RegisterManageRouter register the routes operating orders on behalf of the
+// shop, r should only be reachable by operators.
+func (c *OrderController) RegisterManageRouter(r gin.IRouter) {
+	if r == nil {
+		log.Fatal("[InitRouter]: server is nil")
+	}
+
+	r.POST("/modify/status", c.modifyStatus)
+}
+
+// checkout turns the selected cart rows of the user into an order.
+func (c *OrderController) checkout(ctx *gin.Context) {
+	var req struct {
+		Remark string `json:"remark,omitempty"`
+	}
+
+	userID, err := user.GetID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	orderID, err := c.store.Checkout(userID, req.Remark)
+	var checkoutErr *model.CheckoutError
+	switch {
+	case err == model.ErrEmptyCheckout:
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	case errors.As(err, &checkoutErr) && checkoutErr.Err == model.ErrDelisted:
+		ctx.Error(err)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict, "spu_id": checkoutErr.SpuID})
+		return
+	case errors.As(err, &checkoutErr):
+		ctx.Error(err)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict, "sku_id": checkoutErr.SkuID})
+		return
+	case err != nil:
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "order_id": orderID})
+}
+
+func (c *OrderController) info(ctx *gin.Context) {
+	userID, err := user.GetID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	orders, err := c.store.InfoOrderByUserID(userID)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": orders})
+}
+
+func (c *OrderController) infoDetail(ctx *gin.Context) {
+	userID, err := user.GetID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	orderIDStr, ok := ctx.GetQuery("order_id")
+	if !ok {
+		ctx.Error(errors.New("request should contain order id"))
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	orderID, err := strconv.Atoi(orderIDStr)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	order, err := c.store.InfoOrderByID(userID, uint32(orderID))
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": order})
+}
+
+// cancel cancels an unpaid order of the user.
+func (c *OrderController) cancel(ctx *gin.Context) {
+	c.userTransition(ctx, model.StatusCancelled)
+}
+
+// confirm confirms the receipt of a delivered order of the user.
+func (c *OrderController) confirm(ctx *gin.Context) {
+	c.userTransition(ctx, model.StatusCompleted)
+}
+
+func (c *OrderController) userTransition(ctx *gin.Context, to model.Status) {
+	var req struct {
+		OrderID uint32 `json:"order_id"    binding:"required"`
+	}
+
+	userID, err := user.GetID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	c.transition(ctx, req.OrderID, to, model.Actor{Kind: model.ActorUser, ID: userID})
+}
+
+func (c *OrderController) modifyStatus(ctx *gin.Context) {
+	var req struct {
+		OrderID uint32       `json:"order_id"    binding:"required"`
+		Status  model.Status `json:"status"`
+	}
+
+	adminID, err := user.GetAdminID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if !req.Status.Valid() {
+		ctx.Error(errors.New("unknown order status"))
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if !req.Status.Manual() {
+		ctx.Error(fmt.Errorf("%s is only reached by the refund flow", req.Status))
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	c.transition(ctx, req.OrderID, req.Status, model.Actor{Kind: model.ActorAdmin, ID: adminID})
+}
+
+func (c *OrderController) transition(ctx *gin.Context, orderID uint32, to model.Status, actor model.Actor) {
+	err := c.store.Transition(orderID, to, actor)
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
+	if errors.Is(err, model.ErrInvalidTransition) {
+		ctx.Error(err)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict})
+		return
+	}
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
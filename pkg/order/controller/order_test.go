@@ -0,0 +1,367 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	_ "github.com/dovics/wx-demo/config"
+	"github.com/dovics/wx-demo/pkg/order/model"
+	"github.com/dovics/wx-demo/util/config"
+	"github.com/dovics/wx-demo/util/money"
+	"github.com/dovics/wx-demo/util/wxpay"
+	"github.com/dovics/wx-demo/util/wxpay/mock"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testUserID  = 1
+	otherUserID = 2
+	testAdminID = 1
+
+	testAppID    = "wx-test"
+	testMchID    = "1900000001"
+	testAPIv3Key = "0123456789abcdef0123456789abcdef"
+)
+
+var (
+	keysOnce    sync.Once
+	merchantKey *rsa.PrivateKey
+	platformKey *rsa.PrivateKey
+)
+
+type testEnv struct {
+	router *gin.Engine
+	store  *model.MemoryStore
+	pay    *mock.Server
+}
+
+// newTestEnv serves the order routes on a memory store, with WeChat Pay
+// answered by the mock. The mock posts its notifications to the routes.
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	keysOnce.Do(func() {
+		var err error
+		if merchantKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+		if platformKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	env := &testEnv{
+		store: model.NewMemoryStore(),
+		pay:   mock.New(testAppID, testMchID, testAPIv3Key, &merchantKey.PublicKey, platformKey),
+	}
+
+	api := httptest.NewServer(env.pay)
+	t.Cleanup(api.Close)
+
+	payClient, err := wxpay.NewClient(wxpay.Config{
+		BaseURL:     api.URL,
+		AppID:       testAppID,
+		MchID:       testMchID,
+		SerialNo:    "MERCHANT",
+		APIv3Key:    testAPIv3Key,
+		PrivateKey:  merchantKey,
+		PlatformKey: &platformKey.PublicKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewWithStore(env.store, payClient)
+	env.router = gin.New()
+	env.router.POST("/api/v1/order/pay/notify", c.PayNotify)
+	env.router.POST("/api/v1/order/refund/notify", c.RefundNotify)
+	c.RegisterRouter(env.router.Group("/api/v1/order", func(ctx *gin.Context) {
+		id, err := strconv.Atoi(ctx.GetHeader("X-User"))
+		if err != nil {
+			id = testUserID
+		}
+		ctx.Set("userID", float64(id))
+	}))
+	c.RegisterManageRouter(env.router.Group("/api/admin/v1/order", func(ctx *gin.Context) {
+		ctx.Set("adminID", float64(testAdminID))
+	}))
+
+	server := httptest.NewServer(env.router)
+	t.Cleanup(server.Close)
+	config.Viper.Set("wx.pay.notify_url", server.URL+"/api/v1/order/pay/notify")
+	config.Viper.Set("wx.pay.refund_notify_url", server.URL+"/api/v1/order/refund/notify")
+
+	env.store.PutOpenID(testUserID, "openid-1")
+	env.store.PutStock(1, 10)
+	env.store.PutStock(2, 1)
+
+	return env
+}
+
+func (env *testEnv) do(t *testing.T, method, path string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	return env.doAs(t, testUserID, method, path, body)
+}
+
+func (env *testEnv) doAs(t *testing.T, userID uint32, method, path string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User", strconv.Itoa(int(userID)))
+	w := httptest.NewRecorder()
+	env.router.ServeHTTP(w, req)
+
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+// putCart selects count of sku 1 of spu 10 at 12.50, and count2 of sku 2 of
+// spu 11 at 3.00 if count2 is not 0.
+func (env *testEnv) putCart(count, count2 uint32) {
+	env.store.PutCart(testUserID, model.CheckoutGoods{Active: true, Item: model.Item{
+		SkuID: 1, SpuID: 10, Title: "cat food", Spec: `{"size":"L"}`, Price: money.New(1250), Count: count,
+	}})
+	if count2 != 0 {
+		env.store.PutCart(testUserID, model.CheckoutGoods{Active: true, Item: model.Item{
+			SkuID: 2, SpuID: 11, Title: "cat toy", Price: money.New(300), Count: count2,
+		}})
+	}
+}
+
+func (env *testEnv) checkout(t *testing.T, count, count2 uint32) uint32 {
+	t.Helper()
+
+	env.putCart(count, count2)
+	w, resp := env.do(t, http.MethodPost, "/api/v1/order/checkout", gin.H{"remark": "leave at the door"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("checkout status = %d, body %s", w.Code, w.Body)
+	}
+
+	return uint32(resp["order_id"].(float64))
+}
+
+func (env *testEnv) order(t *testing.T, orderID uint32) *model.Order {
+	t.Helper()
+
+	order, err := env.store.InfoOrderByID(testUserID, orderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func TestCheckout(t *testing.T) {
+	env := newTestEnv(t)
+
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/checkout", gin.H{}); w.Code != http.StatusBadRequest {
+		t.Errorf("empty cart status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	orderID := env.checkout(t, 2, 1)
+
+	order := env.order(t, orderID)
+	if order.Status != model.StatusPendingPayment || order.TotalPrice.Amount != 2800 ||
+		order.TotalCount != 3 || len(order.Items) != 2 || order.Remark != "leave at the door" {
+		t.Errorf("order = %+v", order)
+	}
+	if len(order.History) != 1 || order.History[0].From != model.StatusCreated ||
+		order.History[0].To != model.StatusPendingPayment {
+		t.Errorf("history = %+v, want the creation", order.History)
+	}
+	if env.store.Stock(1) != 8 || env.store.Stock(2) != 0 {
+		t.Errorf("stock = %d, %d, want 8, 0", env.store.Stock(1), env.store.Stock(2))
+	}
+
+	// the cart rows are gone with the checkout
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/checkout", gin.H{}); w.Code != http.StatusBadRequest {
+		t.Errorf("checkout again status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestCheckoutConflict(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.putCart(1, 2)
+	w, resp := env.do(t, http.MethodPost, "/api/v1/order/checkout", gin.H{})
+	if w.Code != http.StatusConflict || resp["sku_id"] != float64(2) {
+		t.Errorf("insufficient stock = %d %v, want %d of sku 2", w.Code, resp, http.StatusConflict)
+	}
+	if env.store.Stock(1) != 10 {
+		t.Errorf("stock of a refused checkout = %d, want 10", env.store.Stock(1))
+	}
+
+	env = newTestEnv(t)
+	env.store.PutCart(testUserID, model.CheckoutGoods{Active: false, Item: model.Item{
+		SkuID: 1, SpuID: 10, Price: money.New(1250), Count: 1,
+	}})
+	w, resp = env.do(t, http.MethodPost, "/api/v1/order/checkout", gin.H{})
+	if w.Code != http.StatusConflict || resp["spu_id"] != float64(10) {
+		t.Errorf("delisted = %d %v, want %d of spu 10", w.Code, resp, http.StatusConflict)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	env := newTestEnv(t)
+
+	first := env.checkout(t, 1, 0)
+	second := env.checkout(t, 2, 0)
+
+	w, resp := env.do(t, http.MethodGet, "/api/v1/order/info", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("info status = %d", w.Code)
+	}
+	orders := resp["data"].([]interface{})
+	if len(orders) != 2 || orders[0].(map[string]interface{})["id"] != float64(second) {
+		t.Errorf("orders = %v, want newest first", orders)
+	}
+
+	if w, resp := env.doAs(t, otherUserID, http.MethodGet, "/api/v1/order/info", nil); w.Code != http.StatusOK ||
+		resp["data"] != nil {
+		t.Errorf("info of other user = %d %v, want no order", w.Code, resp["data"])
+	}
+
+	path := "/api/v1/order/info/detail?order_id=" + strconv.Itoa(int(first))
+	w, resp = env.do(t, http.MethodGet, path, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("detail status = %d", w.Code)
+	}
+	detail := resp["data"].(map[string]interface{})
+	if items := detail["items"].([]interface{}); len(items) != 1 {
+		t.Errorf("items = %v", items)
+	}
+	if detail["total_price"] != 12.5 {
+		t.Errorf("total price = %v, want 12.5", detail["total_price"])
+	}
+
+	for name, c := range map[string]struct {
+		userID uint32
+		path   string
+		status int
+	}{
+		"other user":   {otherUserID, path, http.StatusNotFound},
+		"unknown":      {testUserID, "/api/v1/order/info/detail?order_id=1", http.StatusNotFound},
+		"no order id":  {testUserID, "/api/v1/order/info/detail", http.StatusBadRequest},
+		"bad order id": {testUserID, "/api/v1/order/info/detail?order_id=x", http.StatusBadRequest},
+	} {
+		if w, _ := env.doAs(t, c.userID, http.MethodGet, c.path, nil); w.Code != c.status {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, c.status)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	env := newTestEnv(t)
+
+	orderID := env.checkout(t, 3, 0)
+	body := gin.H{"order_id": orderID}
+
+	if w, _ := env.doAs(t, otherUserID, http.MethodPost, "/api/v1/order/cancel", body); w.Code != http.StatusNotFound {
+		t.Errorf("cancel by other user status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/cancel", body); w.Code != http.StatusOK {
+		t.Fatalf("cancel status = %d, body %s", w.Code, w.Body)
+	}
+	if env.store.Stock(1) != 10 {
+		t.Errorf("stock after cancel = %d, want 10", env.store.Stock(1))
+	}
+
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/cancel", body); w.Code != http.StatusConflict {
+		t.Errorf("cancel again status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if env.store.Stock(1) != 10 {
+		t.Errorf("stock after cancel again = %d, want 10", env.store.Stock(1))
+	}
+
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/cancel", gin.H{}); w.Code != http.StatusBadRequest {
+		t.Errorf("cancel without order id status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	env := newTestEnv(t)
+
+	orderID := env.checkout(t, 1, 0)
+	body := gin.H{"order_id": orderID}
+
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/confirm", body); w.Code != http.StatusConflict {
+		t.Errorf("confirm unpaid status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	env.payOrder(t, orderID)
+	for _, status := range []model.Status{model.StatusShipped, model.StatusDelivered} {
+		w, _ := env.do(t, http.MethodPost, "/api/admin/v1/order/modify/status",
+			gin.H{"order_id": orderID, "status": status})
+		if w.Code != http.StatusOK {
+			t.Fatalf("move to %s status = %d", status, w.Code)
+		}
+	}
+
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/confirm", body); w.Code != http.StatusOK {
+		t.Fatalf("confirm status = %d, body %s", w.Code, w.Body)
+	}
+
+	order := env.order(t, orderID)
+	last := order.History[len(order.History)-1]
+	if order.Status != model.StatusCompleted || last.Actor.Kind != model.ActorUser || last.Actor.ID != testUserID {
+		t.Errorf("order %s, last history %+v", order.Status, last)
+	}
+}
+
+func TestModifyStatus(t *testing.T) {
+	env := newTestEnv(t)
+
+	orderID := env.checkout(t, 1, 0)
+
+	for name, c := range map[string]struct {
+		body   gin.H
+		status int
+	}{
+		"unknown status": {gin.H{"order_id": orderID, "status": 99}, http.StatusBadRequest},
+		"refunding":      {gin.H{"order_id": orderID, "status": model.StatusRefunding}, http.StatusBadRequest},
+		"refunded":       {gin.H{"order_id": orderID, "status": model.StatusRefunded}, http.StatusBadRequest},
+		"refund failed":  {gin.H{"order_id": orderID, "status": model.StatusRefundFailed}, http.StatusBadRequest},
+		"unknown order":  {gin.H{"order_id": 1, "status": model.StatusShipped}, http.StatusNotFound},
+		"not paid":       {gin.H{"order_id": orderID, "status": model.StatusShipped}, http.StatusConflict},
+	} {
+		if w, _ := env.do(t, http.MethodPost, "/api/admin/v1/order/modify/status", c.body); w.Code != c.status {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, c.status)
+		}
+	}
+
+	w, _ := env.do(t, http.MethodPost, "/api/admin/v1/order/modify/status",
+		gin.H{"order_id": orderID, "status": model.StatusCancelled})
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancel by admin status = %d", w.Code)
+	}
+
+	last := env.order(t, orderID).History[1]
+	if last.Actor.Kind != model.ActorAdmin || last.Actor.ID != testAdminID {
+		t.Errorf("actor = %+v, want the admin", last.Actor)
+	}
+
+	// a paid order may move to refunding, but not by hand
+	paid := env.checkout(t, 1, 0)
+	env.payOrder(t, paid)
+	w, _ = env.do(t, http.MethodPost, "/api/admin/v1/order/modify/status",
+		gin.H{"order_id": paid, "status": model.StatusRefunding})
+	if status := env.order(t, paid).Status; w.Code != http.StatusBadRequest || status != model.StatusPaid {
+		t.Errorf("refunding by hand = %d, order %s, want %d and paid", w.Code, status, http.StatusBadRequest)
+	}
+}
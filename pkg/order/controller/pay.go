@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dovics/wx-demo/pkg/order/model"
+	"github.com/dovics/wx-demo/util/config"
+	"github.com/dovics/wx-demo/util/user"
+	"github.com/dovics/wx-demo/util/wxpay"
+	"github.com/gin-gonic/gin"
+)
+
+var errPayDisabled = errors.New("wechat pay is not configured")
+
+// newPayClient create the WeChat Pay client from config, it returns nil if
+// wx.pay.mchid is not set.
+func newPayClient() (*wxpay.Client, error) {
+	if config.GetString("wx.pay.mchid") == "" {
+		return nil, nil
+	}
+
+	privateKey, err := wxpay.LoadPrivateKey(config.GetString("wx.pay.private_key"))
+	if err != nil {
+		return nil, err
+	}
+
+	platformKey, err := wxpay.LoadPublicKey(config.GetString("wx.pay.platform_key"))
+	if err != nil {
+		return nil, err
+	}
+
+	return wxpay.NewClient(wxpay.Config{
+		BaseURL:     config.GetString("wx.pay.base_url"),
+		AppID:       config.GetString("wx.appid"),
+		MchID:       config.GetString("wx.pay.mchid"),
+		SerialNo:    config.GetString("wx.pay.serial_no"),
+		APIv3Key:    config.GetString("wx.pay.apiv3_key"),
+		PrivateKey:  privateKey,
+		PlatformKey: platformKey,
+	})
+}
+
+// pay creates the WeChat Pay prepay of an order and returns the arguments of
+// wx.requestPayment.
+func (c *OrderController) pay(ctx *gin.Context) {
+	var req struct {
+		OrderID uint32 `json:"order_id"    binding:"required"`
+	}
+
+	if c.wxpay == nil {
+		ctx.Error(errPayDisabled)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": http.StatusServiceUnavailable})
+		return
+	}
+
+	userID, err := user.GetID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	openID, err := c.store.GetOpenID(userID)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	order, err := c.store.InfoOrderByID(userID, req.OrderID)
+	if err == sql.ErrNoRows {
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	}
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	if order.Status != model.StatusPendingPayment {
+		ctx.Error(model.ErrOrderNotPayable)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict})
+		return
+	}
+
+	// the prepay is requested without a transaction, a slow WeChat Pay must not
+	// hold the lock of the order.
+	amount := order.TotalPrice
+	prepayID, err := c.wxpay.Prepay(ctx, wxpay.PrepayRequest{
+		Description: fmt.Sprintf("order %d", order.ID),
+		OutTradeNo:  model.OutTradeNo(order.ID),
+		NotifyURL:   config.GetString("wx.pay.notify_url"),
+		Amount:      wxpay.Amount{Total: amount.Amount, Currency: amount.Currency},
+		Payer:       wxpay.Payer{OpenID: openID},
+	})
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	err = c.store.SavePrepay(order.ID, prepayID, amount)
+	if err == model.ErrOrderNotPayable {
+		ctx.Error(err)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict})
+		return
+	}
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	params, err := c.wxpay.RequestPayment(prepayID)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": params})
+}
+
+// PayNotify handles the payment notification of WeChat Pay. It must be
+// registered without the JWT middleware.
+func (c *OrderController) PayNotify(ctx *gin.Context) {
+	if c.wxpay == nil {
+		ctx.Error(errPayDisabled)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"code": "FAIL", "message": errPayDisabled.Error()})
+		return
+	}
+
+	var transaction wxpay.Transaction
+	if _, err := c.wxpay.ParseNotification(ctx.Request, &transaction); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"code": "FAIL", "message": err.Error()})
+		return
+	}
+
+	// the key may be shared by several apps of the merchant, a transaction
+	// of another one must not pay the order.
+	if err := c.wxpay.CheckMerchant(transaction.AppID, transaction.MchID); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"code": "FAIL", "message": err.Error()})
+		return
+	}
+
+	if transaction.TradeState != wxpay.TradeStateSuccess {
+		ctx.JSON(http.StatusOK, gin.H{"code": "SUCCESS"})
+		return
+	}
+
+	paidAt, err := time.Parse(time.RFC3339, transaction.SuccessTime)
+	if err != nil {
+		paidAt = time.Now()
+	}
+
+	err = c.store.Paid(transaction.OutTradeNo, transaction.TransactionID, transaction.Amount.Total, paidAt)
+	if errors.Is(err, model.ErrPaymentNotFound) || errors.Is(err, model.ErrAmountMismatch) {
+		// a retry of WeChat Pay can not fix it, acknowledge and leave it to
+		// be checked by hand.
+		log.Printf("payment notification %s of %s ignored: %v",
+			transaction.TransactionID, transaction.OutTradeNo, err)
+		ctx.JSON(http.StatusOK, gin.H{"code": "SUCCESS"})
+		return
+	}
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"code": "FAIL", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"code": "SUCCESS"})
+}
@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dovics/wx-demo/pkg/order/model"
+	"github.com/dovics/wx-demo/util/config"
+	"github.com/dovics/wx-demo/util/wxpay"
+	"github.com/gin-gonic/gin"
+)
+
+// payOrder requests the prepay of the order and pays it by the mock.
+func (env *testEnv) payOrder(t *testing.T, orderID uint32) {
+	t.Helper()
+
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/pay", gin.H{"order_id": orderID}); w.Code != http.StatusOK {
+		t.Fatalf("pay status = %d, body %s", w.Code, w.Body)
+	}
+
+	if err := env.pay.Pay(model.OutTradeNo(orderID)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPay(t *testing.T) {
+	env := newTestEnv(t)
+
+	orderID := env.checkout(t, 2, 0)
+
+	w, resp := env.do(t, http.MethodPost, "/api/v1/order/pay", gin.H{"order_id": orderID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("pay status = %d, body %s", w.Code, w.Body)
+	}
+	params := resp["data"].(map[string]interface{})
+	if params["appId"] != testAppID || params["signType"] != "RSA" {
+		t.Errorf("params = %v", params)
+	}
+
+	payment, ok := env.store.Payment(orderID)
+	if !ok || params["package"] != "prepay_id="+payment.PrepayID || payment.Amount.Amount != 2500 {
+		t.Errorf("payment = %+v, params %v", payment, params)
+	}
+
+	if err := env.pay.Pay(model.OutTradeNo(orderID)); err != nil {
+		t.Fatal(err)
+	}
+
+	order := env.order(t, orderID)
+	if order.Status != model.StatusPaid {
+		t.Errorf("status = %s, want paid", order.Status)
+	}
+	last := order.History[len(order.History)-1]
+	if last.Actor.Kind != model.ActorSystem {
+		t.Errorf("paid by %+v, want the system", last.Actor)
+	}
+	if env.store.Sales(10) != 2 {
+		t.Errorf("sales = %d, want 2", env.store.Sales(10))
+	}
+
+	// WeChat Pay retries the notification
+	if err := env.pay.Pay(model.OutTradeNo(orderID)); err != nil {
+		t.Fatal(err)
+	}
+	if env.store.Sales(10) != 2 {
+		t.Errorf("sales after a retried notification = %d, want 2", env.store.Sales(10))
+	}
+
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/pay", gin.H{"order_id": orderID}); w.Code != http.StatusConflict {
+		t.Errorf("pay a paid order status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w, _ := env.doAs(t, otherUserID, http.MethodPost, "/api/v1/order/pay", gin.H{"order_id": orderID}); w.Code != http.StatusBadGateway {
+		t.Errorf("pay without openid status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
+
+func TestPayNotify(t *testing.T) {
+	env := newTestEnv(t)
+
+	orderID := env.checkout(t, 1, 0)
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/pay", gin.H{"order_id": orderID}); w.Code != http.StatusOK {
+		t.Fatalf("pay status = %d", w.Code)
+	}
+
+	notify := func(outTradeNo string, total int64) error {
+		var transaction wxpay.Transaction
+		transaction.AppID = testAppID
+		transaction.MchID = testMchID
+		transaction.OutTradeNo = outTradeNo
+		transaction.TransactionID = "4200000001"
+		transaction.TradeState = wxpay.TradeStateSuccess
+		transaction.Amount.Total = total
+		return env.pay.Notify(config.GetString("wx.pay.notify_url"), "TRANSACTION.SUCCESS", "transaction", transaction)
+	}
+
+	// both are acknowledged, a retry can not fix them
+	if err := notify("WXO000000000001", 1250); err != nil {
+		t.Errorf("unknown out trade no: %v", err)
+	}
+	if err := notify(model.OutTradeNo(orderID), 1); err != nil {
+		t.Errorf("amount mismatch: %v", err)
+	}
+	if order := env.order(t, orderID); order.Status != model.StatusPendingPayment {
+		t.Errorf("status after an amount mismatch = %s, want pending payment", order.Status)
+	}
+
+	// a notification not signed by the platform key is refused
+	w, _ := env.do(t, http.MethodPost, "/api/v1/order/pay/notify", gin.H{"id": "forged"})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned notification status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// a transaction of another app or merchant is refused
+	for name, merchant := range map[string][2]string{
+		"appid": {"wx-other", testMchID},
+		"mchid": {testAppID, "1900000002"},
+	} {
+		var transaction wxpay.Transaction
+		transaction.AppID, transaction.MchID = merchant[0], merchant[1]
+		transaction.OutTradeNo = model.OutTradeNo(orderID)
+		transaction.TransactionID = "4200000002"
+		transaction.TradeState = wxpay.TradeStateSuccess
+		transaction.Amount.Total = 1250
+		if err := env.pay.Notify(config.GetString("wx.pay.notify_url"), "TRANSACTION.SUCCESS", "transaction", transaction); err == nil {
+			t.Errorf("notification of another %s acknowledged", name)
+		}
+	}
+	if order := env.order(t, orderID); order.Status != model.StatusPendingPayment {
+		t.Errorf("status after a notification of another merchant = %s, want pending payment", order.Status)
+	}
+
+	if err := notify(model.OutTradeNo(orderID), 1250); err != nil {
+		t.Fatal(err)
+	}
+	if order := env.order(t, orderID); order.Status != model.StatusPaid {
+		t.Errorf("status = %s, want paid", order.Status)
+	}
+}
+
+func TestPayCancelled(t *testing.T) {
+	env := newTestEnv(t)
+
+	orderID := env.checkout(t, 1, 0)
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/pay", gin.H{"order_id": orderID}); w.Code != http.StatusOK {
+		t.Fatalf("pay status = %d", w.Code)
+	}
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/cancel", gin.H{"order_id": orderID}); w.Code != http.StatusOK {
+		t.Fatalf("cancel status = %d", w.Code)
+	}
+
+	// paid after the cancel, kept for a manual refund
+	if err := env.pay.Pay(model.OutTradeNo(orderID)); err != nil {
+		t.Fatal(err)
+	}
+	if order := env.order(t, orderID); order.Status != model.StatusCancelled {
+		t.Errorf("status = %s, want cancelled", order.Status)
+	}
+	if payment, _ := env.store.Payment(orderID); payment.Status != model.PaymentPaid {
+		t.Errorf("payment status = %d, want paid", payment.Status)
+	}
+
+	disabled := NewWithStore(env.store, nil)
+	r := gin.New()
+	disabled.RegisterRouter(r.Group("/api/v1/order"))
+	env.router = r
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/pay", gin.H{"order_id": orderID}); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("pay disabled status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
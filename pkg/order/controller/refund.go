@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dovics/wx-demo/pkg/order/model"
+	"github.com/dovics/wx-demo/util/config"
+	"github.com/dovics/wx-demo/util/user"
+	"github.com/dovics/wx-demo/util/wxpay"
+	"github.com/gin-gonic/gin"
+)
+
+// refund requests a refund of one item, or of every item if item_id is not
+// set. A count of 0 refunds the rest of the item. A pending refund of an item
+// is sent again instead of creating a new one, so retrying is safe.
+func (c *OrderController) refund(ctx *gin.Context) {
+	var req struct {
+		OrderID uint32 `json:"order_id"    binding:"required"`
+		ItemID  uint32 `json:"item_id,omitempty"`
+		Count   uint32 `json:"count,omitempty"`
+		Reason  string `json:"reason,omitempty"`
+	}
+
+	if c.wxpay == nil {
+		ctx.Error(errPayDisabled)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": http.StatusServiceUnavailable})
+		return
+	}
+
+	userID, err := user.GetID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	payment, refunds, err := c.store.RequestRefund(userID, &model.RefundRequest{
+		OrderID: req.OrderID,
+		ItemID:  req.ItemID,
+		Count:   req.Count,
+		Reason:  req.Reason,
+	})
+	switch {
+	case err == sql.ErrNoRows, err == model.ErrItemNotExists:
+		ctx.Error(err)
+		ctx.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound})
+		return
+	case err == model.ErrOrderNotPaid, err == model.ErrInvalidRefundCount, errors.Is(err, model.ErrInvalidTransition):
+		ctx.Error(err)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict})
+		return
+	case err != nil:
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	for _, refund := range refunds {
+		resp, err := c.wxpay.Refund(ctx, wxpay.RefundRequest{
+			OutTradeNo:  payment.OutTradeNo,
+			OutRefundNo: refund.OutRefundNo,
+			Reason:      refund.Reason,
+			NotifyURL:   config.GetString("wx.pay.refund_notify_url"),
+			Amount: wxpay.RefundAmount{
+				Refund:   refund.Amount.Amount,
+				Total:    payment.Amount.Amount,
+				Currency: payment.Amount.Currency,
+			},
+		})
+		if err != nil {
+			// the refund stays pending and is sent again by the next request.
+			ctx.Error(err)
+			ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+			return
+		}
+
+		if err := c.refundDone(refund.OutRefundNo, resp.RefundID, resp.Status, time.Now()); err != nil {
+			ctx.Error(err)
+			ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "data": refunds})
+}
+
+// RefundNotify handles the refund notification of WeChat Pay. It must be
+// registered without the JWT middleware.
+func (c *OrderController) RefundNotify(ctx *gin.Context) {
+	if c.wxpay == nil {
+		ctx.Error(errPayDisabled)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"code": "FAIL", "message": errPayDisabled.Error()})
+		return
+	}
+
+	var result wxpay.RefundResult
+	if _, err := c.wxpay.ParseNotification(ctx.Request, &result); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"code": "FAIL", "message": err.Error()})
+		return
+	}
+
+	refundedAt, err := time.Parse(time.RFC3339, result.SuccessTime)
+	if err != nil {
+		refundedAt = time.Now()
+	}
+
+	if err := c.refundDone(result.OutRefundNo, result.RefundID, result.RefundStatus, refundedAt); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"code": "FAIL", "message": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"code": "SUCCESS"})
+}
+
+// refundDone finishes a pending refund by the status of the pay api. A
+// successful refund restocks the sku and, once every item is refunded, moves
+// the order to refunded. A closed or abnormal refund moves a refunding order
+// to refund failed. It does nothing if the refund is not pending or the
+// status is still processing.
+func (c *OrderController) refundDone(outRefundNo, refundID, status string, refundedAt time.Time) error {
+	switch status {
+	case wxpay.RefundStatusSuccess:
+		return c.store.RefundDone(outRefundNo, refundID, model.RefundSuccess, refundedAt)
+	case wxpay.RefundStatusClosed, wxpay.RefundStatusAbnormal:
+		log.Printf("refund %s is %s", outRefundNo, status)
+		return c.store.RefundDone(outRefundNo, refundID, model.RefundClosed, refundedAt)
+	default:
+		return nil
+	}
+}
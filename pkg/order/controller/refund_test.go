@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dovics/wx-demo/pkg/order/model"
+	"github.com/dovics/wx-demo/util/wxpay"
+	"github.com/gin-gonic/gin"
+)
+
+// paidOrder checks out 2 of sku 1 and 1 of sku 2, and pays the order.
+func (env *testEnv) paidOrder(t *testing.T) *model.Order {
+	t.Helper()
+
+	orderID := env.checkout(t, 2, 1)
+	env.payOrder(t, orderID)
+	return env.order(t, orderID)
+}
+
+// requestRefund returns the out refund nos of the requested refunds.
+func (env *testEnv) requestRefund(t *testing.T, body gin.H) []string {
+	t.Helper()
+
+	w, resp := env.do(t, http.MethodPost, "/api/v1/order/refund", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refund %v status = %d, body %s", body, w.Code, w.Body)
+	}
+
+	var result []string
+	for _, r := range resp["data"].([]interface{}) {
+		result = append(result, r.(map[string]interface{})["out_refund_no"].(string))
+	}
+	return result
+}
+
+func TestRefundPartial(t *testing.T) {
+	env := newTestEnv(t)
+
+	order := env.paidOrder(t)
+	food := order.Items[0]
+
+	refunds := env.requestRefund(t, gin.H{"order_id": order.ID, "item_id": food.ID, "count": 1, "reason": "broken"})
+	if len(refunds) != 1 {
+		t.Fatalf("refunds = %v, want 1", refunds)
+	}
+
+	if err := env.pay.CompleteRefund(refunds[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	order = env.order(t, order.ID)
+	if order.Status != model.StatusPaid {
+		t.Errorf("status after a partial refund = %s, want paid", order.Status)
+	}
+	if order.Items[0].RefundedCount != 1 || order.Refunds[0].Status != model.RefundSuccess ||
+		order.Refunds[0].Amount.Amount != 1250 {
+		t.Errorf("item %+v refund %+v", order.Items[0], order.Refunds[0])
+	}
+	if env.store.Stock(1) != 9 {
+		t.Errorf("stock = %d, want 9 after the restock of 1", env.store.Stock(1))
+	}
+
+	for name, c := range map[string]struct {
+		body   gin.H
+		status int
+	}{
+		"more than the rest": {gin.H{"order_id": order.ID, "item_id": food.ID, "count": 2}, http.StatusConflict},
+		"unknown item":       {gin.H{"order_id": order.ID, "item_id": 1}, http.StatusNotFound},
+		"unknown order":      {gin.H{"order_id": 1}, http.StatusNotFound},
+	} {
+		if w, _ := env.do(t, http.MethodPost, "/api/v1/order/refund", c.body); w.Code != c.status {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, c.status)
+		}
+	}
+
+	unpaid := env.checkout(t, 1, 0)
+	if w, _ := env.do(t, http.MethodPost, "/api/v1/order/refund", gin.H{"order_id": unpaid}); w.Code != http.StatusConflict {
+		t.Errorf("refund unpaid status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestRefundFull(t *testing.T) {
+	env := newTestEnv(t)
+
+	order := env.paidOrder(t)
+
+	refunds := env.requestRefund(t, gin.H{"order_id": order.ID})
+	if len(refunds) != 2 {
+		t.Fatalf("refunds = %v, want one of each item", refunds)
+	}
+	if status := env.order(t, order.ID).Status; status != model.StatusRefunding {
+		t.Errorf("status = %s, want refunding", status)
+	}
+
+	for i, outRefundNo := range refunds {
+		if err := env.pay.CompleteRefund(outRefundNo); err != nil {
+			t.Fatal(err)
+		}
+
+		want := model.StatusRefunding
+		if i == len(refunds)-1 {
+			want = model.StatusRefunded
+		}
+		if status := env.order(t, order.ID).Status; status != want {
+			t.Errorf("status after %d refunds = %s, want %s", i+1, status, want)
+		}
+	}
+
+	if env.store.Stock(1) != 10 || env.store.Stock(2) != 1 {
+		t.Errorf("stock = %d, %d, want all restocked", env.store.Stock(1), env.store.Stock(2))
+	}
+
+	// a notification retried by WeChat Pay restocks once
+	if err := env.pay.CompleteRefund(refunds[0]); err != nil {
+		t.Fatal(err)
+	}
+	if env.store.Stock(1) != 10 {
+		t.Errorf("stock after a retried notification = %d, want 10", env.store.Stock(1))
+	}
+}
+
+func TestRefundRetry(t *testing.T) {
+	env := newTestEnv(t)
+
+	order := env.paidOrder(t)
+	food := order.Items[0]
+
+	first := env.requestRefund(t, gin.H{"order_id": order.ID, "item_id": food.ID, "count": 1})
+
+	// the refund is still processing, asking again sends the same refund
+	again := env.requestRefund(t, gin.H{"order_id": order.ID, "item_id": food.ID, "count": 1})
+	if len(again) != 1 || again[0] != first[0] {
+		t.Errorf("retried refunds = %v, want %v", again, first)
+	}
+	if refunds := env.order(t, order.ID).Refunds; len(refunds) != 1 {
+		t.Errorf("refunds = %+v, want 1", refunds)
+	}
+
+	if err := env.pay.CompleteRefund(first[0]); err != nil {
+		t.Fatal(err)
+	}
+	if env.store.Stock(1) != 9 {
+		t.Errorf("stock = %d, want 9", env.store.Stock(1))
+	}
+}
+
+func TestRefundClosed(t *testing.T) {
+	env := newTestEnv(t)
+
+	order := env.paidOrder(t)
+
+	refunds := env.requestRefund(t, gin.H{"order_id": order.ID})
+	if err := env.pay.CompleteRefund(refunds[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.pay.CloseRefund(refunds[0], wxpay.RefundStatusAbnormal); err != nil {
+		t.Fatal(err)
+	}
+
+	detail := env.order(t, order.ID)
+	if detail.Status != model.StatusRefundFailed {
+		t.Errorf("status = %s, want refund failed", detail.Status)
+	}
+	if detail.Refunds[0].Status != model.RefundClosed || detail.Items[0].RefundedCount != 0 {
+		t.Errorf("refund %+v item %+v, want closed and not refunded", detail.Refunds[0], detail.Items[0])
+	}
+	if env.store.Stock(1) != 8 || env.store.Stock(2) != 1 {
+		t.Errorf("stock = %d, %d, want only sku 2 restocked", env.store.Stock(1), env.store.Stock(2))
+	}
+
+	// the rest is requested again with a new refund
+	retry := env.requestRefund(t, gin.H{"order_id": order.ID})
+	if len(retry) != 1 || retry[0] == refunds[0] {
+		t.Fatalf("retried refunds = %v, want a new one", retry)
+	}
+	if status := env.order(t, order.ID).Status; status != model.StatusRefunding {
+		t.Errorf("status = %s, want refunding", status)
+	}
+
+	if err := env.pay.CompleteRefund(retry[0]); err != nil {
+		t.Fatal(err)
+	}
+	if status := env.order(t, order.ID).Status; status != model.StatusRefunded {
+		t.Errorf("status = %s, want refunded", status)
+	}
+	if env.store.Stock(1) != 10 {
+		t.Errorf("stock = %d, want 10", env.store.Stock(1))
+	}
+}
+
+func TestRefundDeletedSku(t *testing.T) {
+	env := newTestEnv(t)
+
+	order := env.paidOrder(t)
+	for _, status := range []model.Status{model.StatusShipped, model.StatusDelivered, model.StatusCompleted} {
+		w, _ := env.do(t, http.MethodPost, "/api/admin/v1/order/modify/status",
+			gin.H{"order_id": order.ID, "status": status})
+		if w.Code != http.StatusOK {
+			t.Fatalf("move to %s status = %d", status, w.Code)
+		}
+	}
+
+	// the sku of a completed order may be deleted before the refund.
+	env.store.DeleteSku(1)
+	refunds := env.requestRefund(t, gin.H{"order_id": order.ID, "item_id": order.Items[0].ID})
+	if err := env.pay.CompleteRefund(refunds[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	order = env.order(t, order.ID)
+	if order.Refunds[0].Status != model.RefundSuccess || order.Items[0].RefundedCount != 2 {
+		t.Errorf("item %+v refund %+v, want the refund recorded", order.Items[0], order.Refunds[0])
+	}
+	if env.store.Stock(1) != 0 {
+		t.Errorf("stock of the deleted sku = %d, want 0", env.store.Stock(1))
+	}
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const HistoryTableName = "history"
+
+const (
+	mysqlHistoryCreateTable = iota
+	mysqlHistoryInsert
+	mysqlHistoryInfoByOrderID
+)
+
+var historySQLString = []string{
+	fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+		id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+		order_id		BIGINT UNSIGNED NOT NULL,
+		from_status		TINYINT UNSIGNED NOT NULL,
+		to_status		TINYINT UNSIGNED NOT NULL,
+		actor_kind		VARCHAR(20) NOT NULL COMMENT 'user, admin or system',
+		actor_id		BIGINT UNSIGNED NOT NULL,
+		created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (id),
+		INDEX order_index (order_id)
+	)  ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, HistoryTableName),
+	fmt.Sprintf(`INSERT INTO %s.%s (order_id, from_status, to_status, actor_kind, actor_id) VALUES (?, ?, ?, ?, ?)`,
+		DBName, HistoryTableName),
+	fmt.Sprintf(`SELECT from_status, to_status, actor_kind, actor_id, created_at FROM %s.%s 
+		WHERE order_id = ? ORDER BY id`, DBName, HistoryTableName),
+}
+
+const (
+	ActorUser   = "user"
+	ActorAdmin  = "admin"
+	ActorSystem = "system"
+)
+
+// Actor is who triggers an order status transition.
+type Actor struct {
+	Kind string `json:"kind"`
+	ID   uint32 `json:"id"`
+}
+
+// History records one status transition of an order.
+type History struct {
+	From      Status    `json:"from"`
+	To        Status    `json:"to"`
+	Actor     Actor     `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateHistoryTable create order history table.
+func CreateHistoryTable(db *sql.DB) error {
+	_, err := db.Exec(historySQLString[mysqlHistoryCreateTable])
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func txInsertHistory(tx *sql.Tx, orderID uint32, from, to Status, actor Actor) error {
+	result, err := tx.Exec(historySQLString[mysqlHistoryInsert], orderID, from, to, actor.Kind, actor.ID)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errInvalidMysql
+	}
+
+	return nil
+}
+
+// TxInfoHistoryByOrderID returns the transitions of the order, oldest first.
+func TxInfoHistoryByOrderID(tx *sql.Tx, orderID uint32) ([]*History, error) {
+	rows, err := tx.Query(historySQLString[mysqlHistoryInfoByOrderID], orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*History
+	for rows.Next() {
+		var h History
+		if err := rows.Scan(&h.From, &h.To, &h.Actor.Kind, &h.Actor.ID, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		result = append(result, &h)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,132 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// recorder is a database/sql driver that records the statements executed on
+// it with their arguments, in place of MySQL.
+type recorder struct {
+	mu    sync.Mutex
+	execs []recordedExec
+}
+
+type recordedExec struct {
+	query string
+	args  []driver.Value
+}
+
+func (r *recorder) Connect(context.Context) (driver.Conn, error) { return &recorderConn{r}, nil }
+func (r *recorder) Driver() driver.Driver                        { return nil }
+
+type recorderConn struct{ r *recorder }
+
+func (c *recorderConn) Prepare(query string) (driver.Stmt, error) {
+	return &recorderStmt{r: c.r, query: query}, nil
+}
+func (c *recorderConn) Close() error              { return nil }
+func (c *recorderConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *recorderConn) Commit() error             { return nil }
+func (c *recorderConn) Rollback() error           { return nil }
+
+type recorderStmt struct {
+	r     *recorder
+	query string
+}
+
+func (s *recorderStmt) Close() error  { return nil }
+func (s *recorderStmt) NumInput() int { return -1 }
+
+func (s *recorderStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.r.execs = append(s.r.execs, recordedExec{query: s.query, args: args})
+	return driver.RowsAffected(1), nil
+}
+
+func (s *recorderStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("recorder: query not supported")
+}
+
+func openRecorder(t *testing.T) (*sql.DB, *recorder) {
+	t.Helper()
+
+	r := &recorder{}
+	db := sql.OpenDB(r)
+	t.Cleanup(func() { db.Close() })
+
+	return db, r
+}
+
+// tinyintMax is the largest value of the TINYINT column types.
+var tinyintMax = map[string]int64{
+	"TINYINT":          127,
+	"TINYINT UNSIGNED": 255,
+}
+
+var statusColumn = regexp.MustCompile(`(from_status|to_status)\s+(TINYINT(?: UNSIGNED)?)`)
+
+func TestHistoryStatusFitsColumn(t *testing.T) {
+	db, r := openRecorder(t)
+
+	// version 3 gives the status columns their type on databases migrated
+	// from before it.
+	for _, m := range Migrations.Migrations {
+		if m.Version != 3 {
+			continue
+		}
+		if err := m.Up(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := CreateHistoryTable(db); err != nil {
+		t.Fatal(err)
+	}
+
+	columns := make(map[string]string)
+	for _, e := range r.execs {
+		for _, m := range statusColumn.FindAllStringSubmatch(e.query, -1) {
+			if prev, ok := columns[m[1]]; ok && prev != m[2] {
+				t.Errorf("%s is %s in one statement and %s in another", m[1], prev, m[2])
+			}
+			columns[m[1]] = m[2]
+		}
+	}
+	if len(columns) != 2 {
+		t.Fatalf("status columns = %v, want from_status and to_status", columns)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	r.execs = nil
+	if err := txInsertHistory(tx, 1000, StatusCreated, StatusPendingPayment, Actor{Kind: ActorUser, ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for s := range statusNames {
+		if err := txInsertHistory(tx, 1000, s, s, Actor{Kind: ActorSystem}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, e := range r.execs {
+		for i, column := range []string{"from_status", "to_status"} {
+			v, ok := e.args[1+i].(int64)
+			if !ok {
+				t.Fatalf("%s argument = %#v, want int64", column, e.args[1+i])
+			}
+			if max := tinyintMax[columns[column]]; v < 0 || v > max {
+				t.Errorf("%s %d does not fit %s", column, v, columns[column])
+			}
+		}
+	}
+}
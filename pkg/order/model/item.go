@@ -0,0 +1,172 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/dovics/wx-demo/util/money"
+)
+
+const ItemTableName = "item"
+
+const (
+	mysqlItemCreateTable = iota
+	mysqlItemInsert
+	mysqlItemInfoByOrderID
+	mysqlItemRefund
+	mysqlItemCountNotRefunded
+	mysqlItemCountNotCovered
+	mysqlItemCountOpenBySpuID
+	mysqlItemCountOpenBySkuID
+)
+
+var itemSQLString = []string{
+	fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+		id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+		order_id		BIGINT UNSIGNED NOT NULL,
+		sku_id			BIGINT UNSIGNED NOT NULL,
+		spu_id			BIGINT UNSIGNED NOT NULL,
+		title			VARCHAR(100) NOT NULL DEFAULT " ",
+		images			JSON,
+		spec			VARCHAR(512) NOT NULL,
+		price			BIGINT NOT NULL COMMENT 'in cents',
+		count			INT NOT NULL DEFAULT 1,
+		refunded_count	INT NOT NULL DEFAULT 0,
+		created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (id),
+		INDEX order_index (order_id)
+	)  ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, ItemTableName),
+	fmt.Sprintf(`INSERT INTO %s.%s (order_id, sku_id, spu_id, title, images, spec, price, count) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, DBName, ItemTableName),
+	fmt.Sprintf(`SELECT id, sku_id, spu_id, title, images, spec, price, count, refunded_count FROM %s.%s 
+		WHERE order_id = ?`, DBName, ItemTableName),
+	fmt.Sprintf(`UPDATE %s.%s SET refunded_count = refunded_count + ? WHERE id = ? AND refunded_count + ? <= count LIMIT 1`,
+		DBName, ItemTableName),
+	fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s WHERE order_id = ? AND refunded_count < count`, DBName, ItemTableName),
+	fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s WHERE order_id = ? AND refunded_count + 
+		(SELECT COALESCE(SUM(count), 0) FROM %s.%s WHERE item_id = item.id AND status = ?) < count`,
+		DBName, ItemTableName, DBName, RefundTableName),
+	fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s JOIN %s.%s ON orders.id = item.order_id 
+		WHERE item.spu_id = ? AND orders.status IN (?, ?, ?, ?, ?, ?)`, DBName, ItemTableName, DBName, TableName),
+	fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s JOIN %s.%s ON orders.id = item.order_id 
+		WHERE item.sku_id = ? AND orders.status IN (?, ?, ?, ?, ?, ?)`, DBName, ItemTableName, DBName, TableName),
+}
+
+// Item is a snapshot of the goods at the time the order is created.
+type Item struct {
+	ID     uint32      `json:"id,omitempty"`
+	SkuID  uint32      `json:"sku_id,omitempty"`
+	SpuID  uint32      `json:"spu_id,omitempty"`
+	Title  string      `json:"title,omitempty"`
+	Images string      `json:"images,omitempty"`
+	Spec   string      `json:"spec,omitempty"`
+	Price  money.Money `json:"price"`
+	Count  uint32      `json:"count"`
+
+	RefundedCount uint32 `json:"refunded_count"`
+}
+
+// CreateItemTable create order item table.
+func CreateItemTable(db *sql.DB) error {
+	_, err := db.Exec(itemSQLString[mysqlItemCreateTable])
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TxInsertItem add an item to the order
+func TxInsertItem(tx *sql.Tx, orderID uint32, item *Item) error {
+	result, err := tx.Exec(itemSQLString[mysqlItemInsert], orderID, item.SkuID, item.SpuID, item.Title,
+		item.Images, item.Spec, item.Price, item.Count)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errInvalidMysql
+	}
+
+	return nil
+}
+
+// TxInfoItemByOrderID returns the items of the order.
+func TxInfoItemByOrderID(tx *sql.Tx, orderID uint32) ([]*Item, error) {
+	rows, err := tx.Query(itemSQLString[mysqlItemInfoByOrderID], orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*Item
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.ID, &item.SkuID, &item.SpuID, &item.Title, &item.Images,
+			&item.Spec, &item.Price, &item.Count, &item.RefundedCount); err != nil {
+			return nil, err
+		}
+
+		result = append(result, &item)
+	}
+
+	return result, rows.Err()
+}
+
+// TxItemRefunded adds count to the refunded count of the item.
+func TxItemRefunded(tx *sql.Tx, itemID uint32, count uint32) error {
+	result, err := tx.Exec(itemSQLString[mysqlItemRefund], count, itemID, count)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errInvalidMysql
+	}
+
+	return nil
+}
+
+// TxIsFullyRefunded reports whether every item of the order is refunded.
+func TxIsFullyRefunded(tx *sql.Tx, orderID uint32) (bool, error) {
+	var n int
+	if err := tx.QueryRow(itemSQLString[mysqlItemCountNotRefunded], orderID).Scan(&n); err != nil {
+		return false, err
+	}
+
+	return n == 0, nil
+}
+
+// TxIsRefundCovered reports whether every item of the order is either refunded
+// or has a pending refund for the rest.
+func TxIsRefundCovered(tx *sql.Tx, orderID uint32) (bool, error) {
+	var n int
+	if err := tx.QueryRow(itemSQLString[mysqlItemCountNotCovered], orderID, RefundPending).Scan(&n); err != nil {
+		return false, err
+	}
+
+	return n == 0, nil
+}
+
+// TxCountOpenItemBySpuID returns the number of items of the spu in orders that
+// are not finished.
+func TxCountOpenItemBySpuID(tx *sql.Tx, spuID uint32) (int, error) {
+	return txCountOpenItem(tx, itemSQLString[mysqlItemCountOpenBySpuID], spuID)
+}
+
+// TxCountOpenItemBySkuID returns the number of items of the sku in orders that
+// are not finished.
+func TxCountOpenItemBySkuID(tx *sql.Tx, skuID uint32) (int, error) {
+	return txCountOpenItem(tx, itemSQLString[mysqlItemCountOpenBySkuID], skuID)
+}
+
+func txCountOpenItem(tx *sql.Tx, query string, id uint32) (int, error) {
+	args := []interface{}{id}
+	for _, status := range openStatuses {
+		args = append(args, status)
+	}
+
+	var n int
+	err := tx.QueryRow(query, args...).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,512 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dovics/wx-demo/util/money"
+)
+
+type memoryCart struct {
+	userID uint32
+	goods  CheckoutGoods
+}
+
+// MemoryStore is a Store kept in process memory, for tests. It keeps the
+// stock and sales of the goods in place of the goods schema.
+type MemoryStore struct {
+	mu         sync.RWMutex
+	nextID     uint32
+	carts      map[uint32]*memoryCart
+	stock      map[uint32]uint32
+	sales      map[uint32]uint32
+	openIDs    map[uint32]string
+	orders     map[uint32]*Order
+	payments   map[uint32]*Payment
+	refundsSeq map[uint32]uint32
+}
+
+// NewMemoryStore returns an empty MemoryStore. Ids start at 1000 like the
+// orders tables.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nextID:     1000,
+		carts:      make(map[uint32]*memoryCart),
+		stock:      make(map[uint32]uint32),
+		sales:      make(map[uint32]uint32),
+		openIDs:    make(map[uint32]string),
+		orders:     make(map[uint32]*Order),
+		payments:   make(map[uint32]*Payment),
+		refundsSeq: make(map[uint32]uint32),
+	}
+}
+
+func (s *MemoryStore) newID() uint32 {
+	id := s.nextID
+	s.nextID++
+	return id
+}
+
+// PutCart adds a selected cart row of the user and returns its id, goods.Active
+// tells whether the spu is listed.
+func (s *MemoryStore) PutCart(userID uint32, goods CheckoutGoods) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	goods.CartID = s.newID()
+	s.carts[goods.CartID] = &memoryCart{userID: userID, goods: goods}
+	return goods.CartID
+}
+
+// PutStock sets the stock of a sku.
+func (s *MemoryStore) PutStock(skuID uint32, stock uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stock[skuID] = stock
+}
+
+// DeleteSku removes the stock of a sku, as if the sku was deleted.
+func (s *MemoryStore) DeleteSku(skuID uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.stock, skuID)
+}
+
+// release puts count back to the stock of the sku unless it is deleted.
+func (s *MemoryStore) release(skuID uint32, count uint32) {
+	if _, ok := s.stock[skuID]; ok {
+		s.stock[skuID] += count
+	}
+}
+
+// Stock returns the stock of a sku.
+func (s *MemoryStore) Stock(skuID uint32) uint32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.stock[skuID]
+}
+
+// Sales returns the sales of a spu.
+func (s *MemoryStore) Sales(spuID uint32) uint32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sales[spuID]
+}
+
+// PutOpenID sets the openid of a user.
+func (s *MemoryStore) PutOpenID(userID uint32, openID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.openIDs[userID] = openID
+}
+
+// Payment returns the payment of the order.
+func (s *MemoryStore) Payment(orderID uint32) (*Payment, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.payments[orderID]
+	if !ok {
+		return nil, false
+	}
+	copied := *p
+	return &copied, true
+}
+
+func (s *MemoryStore) Checkout(userID uint32, remark string) (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var checkout []*memoryCart
+	for _, c := range s.carts {
+		if c.userID == userID {
+			checkout = append(checkout, c)
+		}
+	}
+	sort.Slice(checkout, func(i, j int) bool {
+		return checkout[i].goods.SkuID < checkout[j].goods.SkuID
+	})
+
+	if len(checkout) == 0 {
+		return 0, ErrEmptyCheckout
+	}
+
+	var (
+		totalPrice money.Money
+		totalCount uint32
+		reserved   = make(map[uint32]uint32)
+	)
+	for _, c := range checkout {
+		g := c.goods
+		if !g.Active {
+			return 0, &CheckoutError{SpuID: g.SpuID, SkuID: g.SkuID, Err: ErrDelisted}
+		}
+
+		reserved[g.SkuID] += g.Count
+		if s.stock[g.SkuID] < reserved[g.SkuID] {
+			return 0, &CheckoutError{SpuID: g.SpuID, SkuID: g.SkuID, Err: ErrInsufficientStock}
+		}
+
+		totalPrice = totalPrice.Add(g.Price.Mul(int64(g.Count)))
+		totalCount += g.Count
+	}
+
+	order := &Order{
+		ID:         s.newID(),
+		UserID:     userID,
+		Status:     StatusPendingPayment,
+		TotalPrice: totalPrice,
+		TotalCount: totalCount,
+		Remark:     remark,
+		CreatedAt:  time.Now(),
+	}
+	s.history(order, StatusCreated, StatusPendingPayment, Actor{Kind: ActorUser, ID: userID})
+
+	for _, c := range checkout {
+		item := c.goods.Item
+		item.ID = s.newID()
+		order.Items = append(order.Items, &item)
+
+		s.stock[item.SkuID] -= item.Count
+		delete(s.carts, c.goods.CartID)
+	}
+	s.orders[order.ID] = order
+
+	return order.ID, nil
+}
+
+func (s *MemoryStore) history(order *Order, from, to Status, actor Actor) {
+	order.History = append(order.History, &History{From: from, To: to, Actor: actor, CreatedAt: time.Now()})
+}
+
+func (s *MemoryStore) InfoOrderByUserID(userID uint32) ([]*Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*Order
+	for _, o := range s.orders {
+		if o.UserID != userID {
+			continue
+		}
+
+		result = append(result, &Order{
+			ID:         o.ID,
+			UserID:     o.UserID,
+			Status:     o.Status,
+			TotalPrice: o.TotalPrice,
+			TotalCount: o.TotalCount,
+			Remark:     o.Remark,
+			CreatedAt:  o.CreatedAt,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID > result[j].ID
+	})
+
+	return result, nil
+}
+
+func (s *MemoryStore) InfoOrderByID(userID uint32, orderID uint32) (*Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.orders[orderID]
+	if !ok || o.UserID != userID {
+		return nil, sql.ErrNoRows
+	}
+
+	return copyOrder(o), nil
+}
+
+func copyOrder(o *Order) *Order {
+	copied := *o
+	copied.Items, copied.History, copied.Refunds = nil, nil, nil
+	for _, item := range o.Items {
+		i := *item
+		copied.Items = append(copied.Items, &i)
+	}
+	for _, h := range o.History {
+		c := *h
+		copied.History = append(copied.History, &c)
+	}
+	for _, r := range o.Refunds {
+		c := *r
+		copied.Refunds = append(copied.Refunds, &c)
+	}
+
+	return &copied
+}
+
+func (s *MemoryStore) Transition(orderID uint32, to Status, actor Actor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[orderID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if err := s.transition(o, to, actor); err != nil {
+		return err
+	}
+
+	if to == StatusCancelled {
+		for _, item := range o.Items {
+			s.release(item.SkuID, item.Count)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) transition(o *Order, to Status, actor Actor) error {
+	if actor.Kind == ActorUser && actor.ID != o.UserID {
+		return sql.ErrNoRows
+	}
+
+	if !CanTransition(o.Status, to) {
+		return fmt.Errorf("%w: from %s to %s", ErrInvalidTransition, o.Status, to)
+	}
+
+	s.history(o, o.Status, to, actor)
+	o.Status = to
+	return nil
+}
+
+func (s *MemoryStore) GetOpenID(userID uint32) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	openID, ok := s.openIDs[userID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+
+	return openID, nil
+}
+
+func (s *MemoryStore) SavePrepay(orderID uint32, prepayID string, amount money.Money) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[orderID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if o.Status != StatusPendingPayment {
+		return ErrOrderNotPayable
+	}
+
+	if p, ok := s.payments[orderID]; ok {
+		p.PrepayID, p.Amount = prepayID, amount
+		return nil
+	}
+
+	s.payments[orderID] = &Payment{
+		ID:         s.newID(),
+		OrderID:    orderID,
+		OutTradeNo: OutTradeNo(orderID),
+		PrepayID:   prepayID,
+		Amount:     amount,
+		Status:     PaymentPending,
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Paid(outTradeNo, transactionID string, total int64, paidAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var payment *Payment
+	for _, p := range s.payments {
+		if p.OutTradeNo == outTradeNo {
+			payment = p
+		}
+	}
+
+	if payment == nil {
+		return ErrPaymentNotFound
+	}
+
+	if payment.Status == PaymentPaid {
+		return nil
+	}
+
+	if payment.Amount.Amount != total {
+		return fmt.Errorf("%w: payment %s amount %s, paid %d", ErrAmountMismatch, outTradeNo,
+			payment.Amount, total)
+	}
+
+	payment.Status = PaymentPaid
+	payment.TransactionID = transactionID
+
+	o := s.orders[payment.OrderID]
+	if err := s.transition(o, StatusPaid, Actor{Kind: ActorSystem}); err != nil {
+		log.Printf("order %d paid by %s but can not be marked as paid: %v", o.ID, transactionID, err)
+		return nil
+	}
+
+	for _, item := range o.Items {
+		s.sales[item.SpuID] += item.Count
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) RequestRefund(userID uint32, req *RefundRequest) (*Payment, []*Refund, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[req.OrderID]
+	if !ok || o.UserID != userID {
+		return nil, nil, sql.ErrNoRows
+	}
+
+	payment, ok := s.payments[o.ID]
+	if !ok || payment.Status != PaymentPaid {
+		return nil, nil, ErrOrderNotPaid
+	}
+
+	var (
+		refunds []*Refund
+		created []*Refund
+	)
+	for _, item := range o.Items {
+		if req.ItemID != 0 && item.ID != req.ItemID {
+			continue
+		}
+
+		if refund := pendingRefund(o, item.ID); refund != nil {
+			refunds = append(refunds, refund)
+			continue
+		}
+
+		count, err := refundCount(item, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		if count == 0 {
+			continue
+		}
+
+		refund := newRefund(o.ID, item, count, req.Reason)
+		refunds = append(refunds, refund)
+		created = append(created, refund)
+	}
+
+	if len(refunds) == 0 {
+		return nil, nil, ErrItemNotExists
+	}
+
+	covered := true
+	for _, item := range o.Items {
+		pending := item.RefundedCount
+		for _, r := range append(o.Refunds, created...) {
+			if r.ItemID == item.ID && r.Status == RefundPending {
+				pending += r.Count
+			}
+		}
+		if pending < item.Count {
+			covered = false
+		}
+	}
+
+	if covered && o.Status != StatusRefunding {
+		if err := s.transition(o, StatusRefunding, Actor{Kind: ActorUser, ID: userID}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, refund := range created {
+		s.refundsSeq[refund.ItemID]++
+		refund.ID = s.newID()
+		refund.OutRefundNo = fmt.Sprintf("WXR%012d%03d", refund.ItemID, s.refundsSeq[refund.ItemID])
+		refund.Status = RefundPending
+		o.Refunds = append(o.Refunds, refund)
+	}
+
+	var result []*Refund
+	for _, refund := range refunds {
+		copied := *refund
+		result = append(result, &copied)
+	}
+	copied := *payment
+
+	return &copied, result, nil
+}
+
+func pendingRefund(o *Order, itemID uint32) *Refund {
+	for _, r := range o.Refunds {
+		if r.ItemID == itemID && r.Status == RefundPending {
+			return r
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) RefundDone(outRefundNo, refundID string, status uint8, refundedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		o      *Order
+		refund *Refund
+	)
+	for _, order := range s.orders {
+		for _, r := range order.Refunds {
+			if r.OutRefundNo == outRefundNo {
+				o, refund = order, r
+			}
+		}
+	}
+
+	if refund == nil {
+		return sql.ErrNoRows
+	}
+
+	if refund.Status != RefundPending {
+		return nil
+	}
+
+	refund.Status = status
+	refund.RefundID = refundID
+	if status != RefundSuccess {
+		if o.Status == StatusRefunding {
+			return s.transition(o, StatusRefundFailed, Actor{Kind: ActorSystem})
+		}
+		return nil
+	}
+
+	refunded := true
+	for _, item := range o.Items {
+		if item.ID == refund.ItemID {
+			item.RefundedCount += refund.Count
+		}
+		if item.RefundedCount < item.Count {
+			refunded = false
+		}
+	}
+	s.release(refund.SkuID, refund.Count)
+
+	if refunded {
+		err := s.transition(o, StatusRefunded, Actor{Kind: ActorSystem})
+		if errors.Is(err, ErrInvalidTransition) {
+			log.Printf("order %d is refunded but can not be marked as refunded: %v", o.ID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/dovics/wx-demo/util/migrate"
+)
+
+// Migrations is the versioned schema of the order module.
+var Migrations = migrate.Module{
+	Name: "order",
+	Migrations: []migrate.Migration{
+		{
+			Version:     1,
+			Description: "create order and item tables",
+			Up: migrate.Exec(
+				fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s`, DBName),
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+					id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+					user_id			BIGINT NOT NULL,
+					status			TINYINT NOT NULL DEFAULT 0,
+					total_price		DOUBLE NOT NULL DEFAULT 0,
+					total_count		INT NOT NULL DEFAULT 0,
+					remark			VARCHAR(512) NOT NULL DEFAULT " ",
+					created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (id),
+					INDEX user_index (user_id)
+				) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, TableName),
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+					id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+					order_id		BIGINT UNSIGNED NOT NULL,
+					sku_id			BIGINT UNSIGNED NOT NULL,
+					spu_id			BIGINT UNSIGNED NOT NULL,
+					title			VARCHAR(100) NOT NULL DEFAULT " ",
+					images			JSON,
+					spec			VARCHAR(512) NOT NULL,
+					price			DOUBLE NOT NULL,
+					count			INT NOT NULL DEFAULT 1,
+					created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (id),
+					INDEX order_index (order_id)
+				) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, ItemTableName),
+			),
+			Down: migrate.Exec(
+				fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, ItemTableName),
+				fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, TableName),
+			),
+		},
+		{
+			// History, payments and refunds came in releases that created
+			// their tables on start, a database may have any of them already.
+			Version:     2,
+			Description: "add history, payment and refund tables and cents prices",
+			Up: func(db *sql.DB) error {
+				if err := migrate.Exec(
+					fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+						id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+						order_id		BIGINT UNSIGNED NOT NULL,
+						from_status		TINYINT NOT NULL,
+						to_status		TINYINT NOT NULL,
+						actor_kind		VARCHAR(20) NOT NULL COMMENT 'user, admin or system',
+						actor_id		BIGINT UNSIGNED NOT NULL,
+						created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+						PRIMARY KEY (id),
+						INDEX order_index (order_id)
+					) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, HistoryTableName),
+					fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+						id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+						order_id		BIGINT UNSIGNED UNIQUE NOT NULL,
+						out_trade_no	VARCHAR(32) UNIQUE NOT NULL,
+						prepay_id		VARCHAR(64) NOT NULL DEFAULT "",
+						transaction_id	VARCHAR(32) NOT NULL DEFAULT "",
+						amount			BIGINT NOT NULL COMMENT 'in cents',
+						status			TINYINT NOT NULL DEFAULT 0 COMMENT '0 pending 1 paid',
+						paid_at			DATETIME,
+						created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+						PRIMARY KEY (id)
+					) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, PaymentTableName),
+					fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+						id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+						order_id		BIGINT UNSIGNED NOT NULL,
+						item_id			BIGINT UNSIGNED NOT NULL,
+						sku_id			BIGINT UNSIGNED NOT NULL,
+						count			INT NOT NULL,
+						amount			BIGINT NOT NULL COMMENT 'in cents',
+						out_refund_no	VARCHAR(64) UNIQUE NOT NULL,
+						refund_id		VARCHAR(32) NOT NULL DEFAULT "",
+						reason			VARCHAR(80) NOT NULL DEFAULT "",
+						status			TINYINT NOT NULL DEFAULT 0 COMMENT '0 pending 1 success 2 closed',
+						refunded_at		DATETIME,
+						created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+						PRIMARY KEY (id),
+						INDEX order_index (order_id),
+						INDEX item_index (item_id)
+					) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, RefundTableName),
+				)(db); err != nil {
+					return err
+				}
+
+				if err := migrate.AddColumn(db, DBName, ItemTableName, "refunded_count",
+					"INT NOT NULL DEFAULT 0 AFTER count"); err != nil {
+					return err
+				}
+
+				return MigrateMoney(db)
+			},
+			Down: func(db *sql.DB) error {
+				if err := migrate.Exec(
+					fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, RefundTableName),
+					fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, PaymentTableName),
+					fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, HistoryTableName),
+					fmt.Sprintf(`ALTER TABLE %s.%s DROP COLUMN refunded_count`, DBName, ItemTableName),
+				)(db); err != nil {
+					return err
+				}
+
+				return RevertMoney(db)
+			},
+		},
+		{
+			// The history status columns were signed, the StatusCreated of
+			// the first row of every order did not fit. Without strict mode
+			// MySQL stored it as 127.
+			Version:     3,
+			Description: "make history statuses unsigned",
+			Up: migrate.Exec(
+				fmt.Sprintf(`ALTER TABLE %s.%s MODIFY from_status TINYINT UNSIGNED NOT NULL, 
+					MODIFY to_status TINYINT UNSIGNED NOT NULL`, DBName, HistoryTableName),
+				fmt.Sprintf(`UPDATE %s.%s SET from_status = 255 WHERE from_status = 127`, DBName, HistoryTableName),
+			),
+			Down: migrate.Exec(
+				fmt.Sprintf(`UPDATE %s.%s SET from_status = 127 WHERE from_status = 255`, DBName, HistoryTableName),
+				fmt.Sprintf(`ALTER TABLE %s.%s MODIFY from_status TINYINT NOT NULL, 
+					MODIFY to_status TINYINT NOT NULL`, DBName, HistoryTableName),
+			),
+		},
+	},
+}
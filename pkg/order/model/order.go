@@ -0,0 +1,258 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dovics/wx-demo/util/money"
+	sqlutil "github.com/dovics/wx-demo/util/sql"
+)
+
+const (
+	DBName    = "orders"
+	TableName = "orders"
+)
+
+const (
+	mysqlOrderCreateDatabase = iota
+	mysqlOrderCreateTable
+	mysqlOrderInsert
+	mysqlOrderInfoByUserID
+	mysqlOrderInfoByID
+	mysqlOrderCheckoutGoods
+	mysqlOrderLockStatus
+	mysqlOrderUpdateStatus
+)
+
+var (
+	errInvalidMysql = errors.New("affected 0 rows")
+
+	// ErrEmptyCheckout returned when there is no selected goods in the cart.
+	ErrEmptyCheckout = errors.New("no selected goods in the cart")
+	// ErrDelisted returned when a selected goods in the cart is delisted.
+	ErrDelisted = errors.New("the goods is delisted")
+
+	orderSQLString = []string{
+		fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s ;`, DBName),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+			id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+			user_id			BIGINT NOT NULL,
+			status			TINYINT NOT NULL DEFAULT 0,
+			total_price		BIGINT NOT NULL DEFAULT 0 COMMENT 'in cents',
+			total_count		INT NOT NULL DEFAULT 0,
+			remark			VARCHAR(512) NOT NULL DEFAULT " ",
+			created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (id),
+			INDEX user_index (user_id)
+		)  ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, TableName),
+		fmt.Sprintf(`INSERT INTO %s.%s (user_id, total_price, total_count, remark) VALUES (?, ?, ?, ?)`, DBName, TableName),
+		fmt.Sprintf(`SELECT id, status, total_price, total_count, remark, created_at FROM %s.%s 
+			WHERE user_id = ? ORDER BY id DESC`, DBName, TableName),
+		fmt.Sprintf(`SELECT id, user_id, status, total_price, total_count, remark, created_at FROM %s.%s 
+			WHERE id = ? AND user_id = ?`, DBName, TableName),
+		`SELECT cart.id, cart.sku_id, cart.spu_id, cart.count, sku.spec, sku.price, spu.title, spu.images, spu.active 
+			FROM cart.cart JOIN goods.sku ON sku.id = cart.sku_id JOIN goods.spu ON spu.id = cart.spu_id 
+			WHERE cart.user_id = ? AND cart.active = true ORDER BY cart.sku_id FOR UPDATE`,
+		fmt.Sprintf(`SELECT user_id, status FROM %s.%s WHERE id = ? FOR UPDATE`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET status = ? WHERE id = ? AND status = ? LIMIT 1`, DBName, TableName),
+	}
+)
+
+type Order struct {
+	ID         uint32      `json:"id,omitempty"`
+	UserID     uint32      `json:"user_id,omitempty"`
+	Status     Status      `json:"status"`
+	TotalPrice money.Money `json:"total_price"`
+	TotalCount uint32      `json:"total_count"`
+	Remark     string      `json:"remark,omitempty"`
+	Items      []*Item     `json:"items,omitempty"`
+	History    []*History  `json:"history,omitempty"`
+	Refunds    []*Refund   `json:"refunds,omitempty"`
+	CreatedAt  time.Time   `json:"created_at,omitempty"`
+}
+
+// CheckoutGoods is a selected cart row with the goods snapshot.
+type CheckoutGoods struct {
+	CartID uint32
+	Active bool
+	Item
+}
+
+// CreateDatabase create order database.
+func CreateDatabase(db *sql.DB) error {
+	_, err := db.Exec(orderSQLString[mysqlOrderCreateDatabase])
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateOrderTable create order table.
+func CreateOrderTable(db *sql.DB) error {
+	_, err := db.Exec(orderSQLString[mysqlOrderCreateTable])
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TxInfoCheckoutGoods returns the selected cart rows of the user joined with the
+// current sku and spu, and locks the cart rows until the transaction ends.
+func TxInfoCheckoutGoods(tx *sql.Tx, userID uint32) ([]*CheckoutGoods, error) {
+	rows, err := tx.Query(orderSQLString[mysqlOrderCheckoutGoods], userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*CheckoutGoods
+	for rows.Next() {
+		var goods CheckoutGoods
+		if err := rows.Scan(&goods.CartID, &goods.SkuID, &goods.SpuID, &goods.Count,
+			&goods.Spec, &goods.Price, &goods.Title, &goods.Images, &goods.Active); err != nil {
+			return nil, err
+		}
+
+		result = append(result, &goods)
+	}
+
+	return result, rows.Err()
+}
+
+// TxInsertOrder add an order header pending payment, and records its creation
+// by the user as the first history row.
+func TxInsertOrder(tx *sql.Tx, userID uint32, totalPrice money.Money, totalCount uint32, remark string) (uint32, error) {
+	result, err := tx.Exec(orderSQLString[mysqlOrderInsert], userID, totalPrice, totalCount, remark)
+	if err != nil {
+		return 0, err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return 0, errInvalidMysql
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	actor := Actor{Kind: ActorUser, ID: userID}
+	if err := txInsertHistory(tx, uint32(id), StatusCreated, StatusPendingPayment, actor); err != nil {
+		return 0, err
+	}
+
+	return uint32(id), nil
+}
+
+// InfoOrderByUserID returns the orders of the user, newest first.
+func InfoOrderByUserID(db *sql.DB, userID uint32) ([]*Order, error) {
+	rows, err := db.Query(orderSQLString[mysqlOrderInfoByUserID], userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*Order
+	for rows.Next() {
+		order := &Order{UserID: userID}
+		if err := rows.Scan(&order.ID, &order.Status, &order.TotalPrice, &order.TotalCount,
+			&order.Remark, &order.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		result = append(result, order)
+	}
+
+	return result, rows.Err()
+}
+
+// TxInfoOrderByID returns the order of the user with its items.
+func TxInfoOrderByID(tx *sql.Tx, userID uint32, orderID uint32) (*Order, error) {
+	var order Order
+	if err := tx.QueryRow(orderSQLString[mysqlOrderInfoByID], orderID, userID).Scan(&order.ID, &order.UserID,
+		&order.Status, &order.TotalPrice, &order.TotalCount, &order.Remark, &order.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	items, err := TxInfoItemByOrderID(tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	order.Items = items
+
+	history, err := TxInfoHistoryByOrderID(tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	order.History = history
+
+	refunds, err := TxInfoRefundByOrderID(tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	order.Refunds = refunds
+
+	return &order, nil
+}
+
+// TxLockOrderStatus returns the owner and the status of the order, and locks
+// the order until the transaction ends.
+func TxLockOrderStatus(tx *sql.Tx, orderID uint32) (userID uint32, status Status, err error) {
+	err = tx.QueryRow(orderSQLString[mysqlOrderLockStatus], orderID).Scan(&userID, &status)
+	return userID, status, err
+}
+
+// TxTransition moves the order to status to if the transition table allows it,
+// and records the transition with the actor. A user actor can only move its own
+// orders, otherwise sql.ErrNoRows is returned.
+func TxTransition(tx *sql.Tx, orderID uint32, to Status, actor Actor) error {
+	userID, from, err := TxLockOrderStatus(tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	if actor.Kind == ActorUser && actor.ID != userID {
+		return sql.ErrNoRows
+	}
+
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: from %s to %s", ErrInvalidTransition, from, to)
+	}
+
+	result, err := tx.Exec(orderSQLString[mysqlOrderUpdateStatus], to, orderID, from)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errInvalidMysql
+	}
+
+	return txInsertHistory(tx, orderID, from, to, actor)
+}
+
+// MigrateMoney converts the DOUBLE prices of order and item tables created
+// before money was stored in cents.
+func MigrateMoney(db *sql.DB) error {
+	if err := sqlutil.ConvertDoubleToCents(db, DBName, TableName, "total_price",
+		"BIGINT NOT NULL DEFAULT 0 COMMENT 'in cents'"); err != nil {
+		return err
+	}
+
+	return sqlutil.ConvertDoubleToCents(db, DBName, ItemTableName, "price",
+		"BIGINT NOT NULL DEFAULT 0 COMMENT 'in cents'")
+}
+
+// RevertMoney converts the prices of order and item tables back to DOUBLE.
+func RevertMoney(db *sql.DB) error {
+	if err := sqlutil.ConvertCentsToDouble(db, DBName, TableName, "total_price",
+		"DOUBLE NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	return sqlutil.ConvertCentsToDouble(db, DBName, ItemTableName, "price", "DOUBLE NOT NULL")
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/dovics/wx-demo/util/money"
+)
+
+const PaymentTableName = "payment"
+
+const (
+	mysqlPaymentCreateTable = iota
+	mysqlPaymentUpsert
+	mysqlPaymentLockByOutTradeNo
+	mysqlPaymentPaid
+	mysqlPaymentLockByOrderID
+)
+
+const (
+	PaymentPending uint8 = iota
+	PaymentPaid
+)
+
+var paymentSQLString = []string{
+	fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+		id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+		order_id		BIGINT UNSIGNED UNIQUE NOT NULL,
+		out_trade_no	VARCHAR(32) UNIQUE NOT NULL,
+		prepay_id		VARCHAR(64) NOT NULL DEFAULT "",
+		transaction_id	VARCHAR(32) NOT NULL DEFAULT "",
+		amount			BIGINT NOT NULL COMMENT 'in cents',
+		status			TINYINT NOT NULL DEFAULT 0 COMMENT '0 pending 1 paid',
+		paid_at			DATETIME,
+		created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (id)
+	)  ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, PaymentTableName),
+	fmt.Sprintf(`INSERT INTO %s.%s (order_id, out_trade_no, prepay_id, amount) VALUES (?, ?, ?, ?) 
+		ON DUPLICATE KEY UPDATE prepay_id = VALUES(prepay_id), amount = VALUES(amount)`, DBName, PaymentTableName),
+	fmt.Sprintf(`SELECT id, order_id, out_trade_no, prepay_id, transaction_id, amount, status 
+		FROM %s.%s WHERE out_trade_no = ? FOR UPDATE`, DBName, PaymentTableName),
+	fmt.Sprintf(`UPDATE %s.%s SET status = ?, transaction_id = ?, paid_at = ? WHERE id = ? LIMIT 1`,
+		DBName, PaymentTableName),
+	fmt.Sprintf(`SELECT id, order_id, out_trade_no, prepay_id, transaction_id, amount, status 
+		FROM %s.%s WHERE order_id = ? FOR UPDATE`, DBName, PaymentTableName),
+}
+
+// Payment is a WeChat Pay transaction of an order.
+type Payment struct {
+	ID            uint32
+	OrderID       uint32
+	OutTradeNo    string
+	PrepayID      string
+	TransactionID string
+	Amount        money.Money
+	Status        uint8
+}
+
+// OutTradeNo returns the merchant trade number of the order.
+func OutTradeNo(orderID uint32) string {
+	return fmt.Sprintf("WXO%012d", orderID)
+}
+
+// CreatePaymentTable create payment table.
+func CreatePaymentTable(db *sql.DB) error {
+	_, err := db.Exec(paymentSQLString[mysqlPaymentCreateTable])
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TxUpsertPayment saves the prepay of the order.
+func TxUpsertPayment(tx *sql.Tx, orderID uint32, prepayID string, amount money.Money) error {
+	_, err := tx.Exec(paymentSQLString[mysqlPaymentUpsert], orderID, OutTradeNo(orderID), prepayID, amount)
+	return err
+}
+
+// TxLockPaymentByOutTradeNo returns the payment and locks it until the transaction ends.
+func TxLockPaymentByOutTradeNo(tx *sql.Tx, outTradeNo string) (*Payment, error) {
+	return txLockPayment(tx, paymentSQLString[mysqlPaymentLockByOutTradeNo], outTradeNo)
+}
+
+// TxLockPaymentByOrderID returns the payment of the order and locks it until the transaction ends.
+func TxLockPaymentByOrderID(tx *sql.Tx, orderID uint32) (*Payment, error) {
+	return txLockPayment(tx, paymentSQLString[mysqlPaymentLockByOrderID], orderID)
+}
+
+func txLockPayment(tx *sql.Tx, query string, arg interface{}) (*Payment, error) {
+	var p Payment
+	if err := tx.QueryRow(query, arg).Scan(&p.ID, &p.OrderID, &p.OutTradeNo, &p.PrepayID,
+		&p.TransactionID, &p.Amount, &p.Status); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// TxPaymentPaid marks the payment as paid.
+func TxPaymentPaid(tx *sql.Tx, id uint32, transactionID string, paidAt time.Time) error {
+	result, err := tx.Exec(paymentSQLString[mysqlPaymentPaid], PaymentPaid, transactionID, paidAt, id)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errInvalidMysql
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/dovics/wx-demo/util/money"
+)
+
+const RefundTableName = "refund"
+
+const (
+	mysqlRefundCreateTable = iota
+	mysqlRefundInsert
+	mysqlRefundCountByItemID
+	mysqlRefundPendingByItemID
+	mysqlRefundLockByOutRefundNo
+	mysqlRefundDone
+	mysqlRefundInfoByOrderID
+)
+
+const (
+	RefundPending uint8 = iota
+	RefundSuccess
+	RefundClosed
+)
+
+var refundSQLString = []string{
+	fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+		id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+		order_id		BIGINT UNSIGNED NOT NULL,
+		item_id			BIGINT UNSIGNED NOT NULL,
+		sku_id			BIGINT UNSIGNED NOT NULL,
+		count			INT NOT NULL,
+		amount			BIGINT NOT NULL COMMENT 'in cents',
+		out_refund_no	VARCHAR(64) UNIQUE NOT NULL,
+		refund_id		VARCHAR(32) NOT NULL DEFAULT "",
+		reason			VARCHAR(80) NOT NULL DEFAULT "",
+		status			TINYINT NOT NULL DEFAULT 0 COMMENT '0 pending 1 success 2 closed',
+		refunded_at		DATETIME,
+		created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (id),
+		INDEX order_index (order_id),
+		INDEX item_index (item_id)
+	)  ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, RefundTableName),
+	fmt.Sprintf(`INSERT INTO %s.%s (order_id, item_id, sku_id, count, amount, out_refund_no, reason) 
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, DBName, RefundTableName),
+	fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s WHERE item_id = ?`, DBName, RefundTableName),
+	fmt.Sprintf(`SELECT id, order_id, item_id, sku_id, count, amount, out_refund_no, refund_id, reason, status 
+		FROM %s.%s WHERE item_id = ? AND status = ? FOR UPDATE`, DBName, RefundTableName),
+	fmt.Sprintf(`SELECT id, order_id, item_id, sku_id, count, amount, out_refund_no, refund_id, reason, status 
+		FROM %s.%s WHERE out_refund_no = ? FOR UPDATE`, DBName, RefundTableName),
+	fmt.Sprintf(`UPDATE %s.%s SET status = ?, refund_id = ?, refunded_at = ? WHERE id = ? LIMIT 1`,
+		DBName, RefundTableName),
+	fmt.Sprintf(`SELECT id, order_id, item_id, sku_id, count, amount, out_refund_no, refund_id, reason, status 
+		FROM %s.%s WHERE order_id = ? ORDER BY id`, DBName, RefundTableName),
+}
+
+// Refund is a full or partial refund of an order item.
+type Refund struct {
+	ID          uint32      `json:"id"`
+	OrderID     uint32      `json:"order_id"`
+	ItemID      uint32      `json:"item_id"`
+	SkuID       uint32      `json:"sku_id"`
+	Count       uint32      `json:"count"`
+	Amount      money.Money `json:"amount"`
+	OutRefundNo string      `json:"out_refund_no"`
+	RefundID    string      `json:"refund_id,omitempty"`
+	Reason      string      `json:"reason,omitempty"`
+	Status      uint8       `json:"status"`
+}
+
+// CreateRefundTable create refund table.
+func CreateRefundTable(db *sql.DB) error {
+	_, err := db.Exec(refundSQLString[mysqlRefundCreateTable])
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TxInsertRefund add a pending refund of the item, its out refund no is
+// derived from the item and the number of its refunds.
+func TxInsertRefund(tx *sql.Tx, refund *Refund) error {
+	var n uint32
+	if err := tx.QueryRow(refundSQLString[mysqlRefundCountByItemID], refund.ItemID).Scan(&n); err != nil {
+		return err
+	}
+	refund.OutRefundNo = fmt.Sprintf("WXR%012d%03d", refund.ItemID, n+1)
+	refund.Status = RefundPending
+
+	result, err := tx.Exec(refundSQLString[mysqlRefundInsert], refund.OrderID, refund.ItemID, refund.SkuID,
+		refund.Count, refund.Amount, refund.OutRefundNo, refund.Reason)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errInvalidMysql
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	refund.ID = uint32(id)
+
+	return nil
+}
+
+// TxLockPendingRefundByItemID returns the pending refund of the item, or
+// sql.ErrNoRows if there is none.
+func TxLockPendingRefundByItemID(tx *sql.Tx, itemID uint32) (*Refund, error) {
+	return scanRefund(tx.QueryRow(refundSQLString[mysqlRefundPendingByItemID], itemID, RefundPending))
+}
+
+// TxLockRefundByOutRefundNo returns the refund and locks it until the transaction ends.
+func TxLockRefundByOutRefundNo(tx *sql.Tx, outRefundNo string) (*Refund, error) {
+	return scanRefund(tx.QueryRow(refundSQLString[mysqlRefundLockByOutRefundNo], outRefundNo))
+}
+
+// TxRefundDone marks the refund as success or closed.
+func TxRefundDone(tx *sql.Tx, id uint32, status uint8, refundID string, refundedAt time.Time) error {
+	result, err := tx.Exec(refundSQLString[mysqlRefundDone], status, refundID, refundedAt, id)
+	if err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return errInvalidMysql
+	}
+
+	return nil
+}
+
+// TxInfoRefundByOrderID returns the refunds of the order.
+func TxInfoRefundByOrderID(tx *sql.Tx, orderID uint32) ([]*Refund, error) {
+	rows, err := tx.Query(refundSQLString[mysqlRefundInfoByOrderID], orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*Refund
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, refund)
+	}
+
+	return result, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRefund(row scanner) (*Refund, error) {
+	var r Refund
+	if err := row.Scan(&r.ID, &r.OrderID, &r.ItemID, &r.SkuID, &r.Count, &r.Amount, &r.OutRefundNo,
+		&r.RefundID, &r.Reason, &r.Status); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
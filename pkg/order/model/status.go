@@ -0,0 +1,97 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Status is the lifecycle state of an order.
+type Status uint8
+
+const (
+	StatusPendingPayment Status = iota
+	StatusPaid
+	StatusShipped
+	StatusDelivered
+	StatusCompleted
+	StatusCancelled
+	StatusRefunding
+	StatusRefunded
+	// StatusRefundFailed is an order whose refund was closed by WeChat Pay,
+	// the user may request it again.
+	StatusRefundFailed
+)
+
+// StatusCreated is the from status of the first history row of an order, no
+// order is ever in it. The status columns of history are unsigned to hold it.
+const StatusCreated Status = 255
+
+var (
+	// ErrInvalidTransition returned when the order can not move to the target status.
+	ErrInvalidTransition = errors.New("invalid order status transition")
+
+	statusNames = map[Status]string{
+		StatusPendingPayment: "pending_payment",
+		StatusPaid:           "paid",
+		StatusShipped:        "shipped",
+		StatusDelivered:      "delivered",
+		StatusCompleted:      "completed",
+		StatusCancelled:      "cancelled",
+		StatusRefunding:      "refunding",
+		StatusRefunded:       "refunded",
+		StatusRefundFailed:   "refund_failed",
+	}
+
+	// openStatuses are the statuses of an order that is not finished yet.
+	openStatuses = []Status{StatusPendingPayment, StatusPaid, StatusShipped, StatusDelivered, StatusRefunding,
+		StatusRefundFailed}
+
+	// transitions lists every status an order is allowed to move to from a status.
+	transitions = map[Status][]Status{
+		StatusPendingPayment: {StatusPaid, StatusCancelled},
+		StatusPaid:           {StatusShipped, StatusRefunding},
+		StatusShipped:        {StatusDelivered, StatusRefunding},
+		StatusDelivered:      {StatusCompleted, StatusRefunding},
+		StatusCompleted:      {StatusRefunding},
+		StatusRefunding:      {StatusRefunded, StatusRefundFailed},
+		StatusRefundFailed:   {StatusRefunding},
+	}
+)
+
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	if s == StatusCreated {
+		return "created"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(s))
+}
+
+// Valid reports whether s is a known status.
+func (s Status) Valid() bool {
+	_, ok := statusNames[s]
+	return ok
+}
+
+// Manual reports whether an operator may move an order to s by hand. The
+// refund statuses are only reached by the refund flow, which refunds the
+// payment and restocks the goods.
+func (s Status) Manual() bool {
+	switch s {
+	case StatusRefunding, StatusRefunded, StatusRefundFailed:
+		return false
+	default:
+		return s.Valid()
+	}
+}
+
+// CanTransition reports whether an order in status from may move to status to.
+func CanTransition(from, to Status) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,436 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	cart "github.com/dovics/wx-demo/pkg/cart/model"
+	user "github.com/dovics/wx-demo/pkg/user/model"
+	"github.com/dovics/wx-demo/util/money"
+)
+
+var (
+	// ErrInsufficientStock returned by Inventory when a sku has not enough stock.
+	ErrInsufficientStock = errors.New("insufficient sku stock")
+	// ErrOrderNotPayable returned when the order is not pending payment.
+	ErrOrderNotPayable = errors.New("the order is not pending payment")
+	// ErrPaymentNotFound returned when no payment has the out trade no.
+	ErrPaymentNotFound = errors.New("no payment of the out trade no")
+	// ErrAmountMismatch returned when the paid amount is not the amount of the payment.
+	ErrAmountMismatch = errors.New("the paid amount does not match the payment")
+	// ErrOrderNotPaid returned when a refund is requested for an unpaid order.
+	ErrOrderNotPaid = errors.New("the order is not paid")
+	// ErrItemNotExists returned when the item to refund is not in the order.
+	ErrItemNotExists = errors.New("the item is not in the order")
+	// ErrInvalidRefundCount returned when the count to refund is more than the rest of the item.
+	ErrInvalidRefundCount = errors.New("invalid refund count")
+)
+
+// CheckoutError is a checkout refused because of one of the selected goods.
+type CheckoutError struct {
+	SpuID uint32
+	SkuID uint32
+	Err   error
+}
+
+func (e *CheckoutError) Error() string {
+	return fmt.Sprintf("checkout spu %d sku %d: %v", e.SpuID, e.SkuID, e.Err)
+}
+
+func (e *CheckoutError) Unwrap() error {
+	return e.Err
+}
+
+// Inventory is the stock of the goods schema. The goods model depends on this
+// package, so the controller passes it in.
+type Inventory interface {
+	// TxReserveSku returns ErrInsufficientStock if the sku has less than count.
+	TxReserveSku(tx *sql.Tx, skuID uint32, count uint32) error
+	// TxReleaseSku puts count back to the sku stock, it does nothing for a
+	// deleted sku.
+	TxReleaseSku(tx *sql.Tx, skuID uint32, count uint32) error
+	TxAddSpuSales(tx *sql.Tx, spuID uint32, count uint32) error
+}
+
+// RefundRequest is a refund of one item, or of every item if ItemID is 0. A
+// Count of 0 refunds the rest of the item.
+type RefundRequest struct {
+	OrderID uint32
+	ItemID  uint32
+	Count   uint32
+	Reason  string
+}
+
+// Store is the persistence of orders used by the controller.
+type Store interface {
+	// Checkout turns the selected cart rows of the user into an order.
+	Checkout(userID uint32, remark string) (uint32, error)
+	InfoOrderByUserID(userID uint32) ([]*Order, error)
+	InfoOrderByID(userID uint32, orderID uint32) (*Order, error)
+	// Transition moves the order as TxTransition, a cancelled order gives its
+	// stock back.
+	Transition(orderID uint32, to Status, actor Actor) error
+	GetOpenID(userID uint32) (string, error)
+	// SavePrepay saves the prepay of the order if it is still pending payment.
+	SavePrepay(orderID uint32, prepayID string, amount money.Money) error
+	// Paid marks the payment and its order as paid, it is idempotent.
+	Paid(outTradeNo, transactionID string, total int64, paidAt time.Time) error
+	// RequestRefund creates the refunds of the request, a pending refund of an
+	// item is returned again instead. It returns the paid payment of the order.
+	RequestRefund(userID uint32, req *RefundRequest) (*Payment, []*Refund, error)
+	// RefundDone finishes a pending refund as RefundSuccess or RefundClosed. A
+	// closed refund moves a refunding order to refund failed.
+	RefundDone(outRefundNo, refundID string, status uint8, refundedAt time.Time) error
+}
+
+type mysqlStore struct {
+	db        *sql.DB
+	inventory Inventory
+}
+
+// NewMySQLStore returns the Store backed by the orders schema.
+func NewMySQLStore(db *sql.DB, inventory Inventory) Store {
+	return &mysqlStore{db: db, inventory: inventory}
+}
+
+func (s *mysqlStore) Checkout(userID uint32, remark string) (uint32, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	checkout, err := TxInfoCheckoutGoods(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(checkout) == 0 {
+		return 0, ErrEmptyCheckout
+	}
+
+	var (
+		totalPrice money.Money
+		totalCount uint32
+	)
+	for _, g := range checkout {
+		if !g.Active {
+			return 0, &CheckoutError{SpuID: g.SpuID, SkuID: g.SkuID, Err: ErrDelisted}
+		}
+
+		totalPrice = totalPrice.Add(g.Price.Mul(int64(g.Count)))
+		totalCount += g.Count
+	}
+
+	orderID, err := TxInsertOrder(tx, userID, totalPrice, totalCount, remark)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, g := range checkout {
+		err := s.inventory.TxReserveSku(tx, g.SkuID, g.Count)
+		if err == ErrInsufficientStock {
+			return 0, &CheckoutError{SpuID: g.SpuID, SkuID: g.SkuID, Err: err}
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if err := TxInsertItem(tx, orderID, &g.Item); err != nil {
+			return 0, err
+		}
+
+		if err := cart.TxDeleteCartByID(tx, userID, g.CartID); err != nil {
+			return 0, err
+		}
+	}
+
+	return orderID, tx.Commit()
+}
+
+func (s *mysqlStore) InfoOrderByUserID(userID uint32) ([]*Order, error) {
+	return InfoOrderByUserID(s.db, userID)
+}
+
+func (s *mysqlStore) InfoOrderByID(userID uint32, orderID uint32) (*Order, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	order, err := TxInfoOrderByID(tx, userID, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, tx.Commit()
+}
+
+func (s *mysqlStore) Transition(orderID uint32, to Status, actor Actor) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := TxTransition(tx, orderID, to, actor); err != nil {
+		return err
+	}
+
+	if to == StatusCancelled {
+		items, err := TxInfoItemByOrderID(tx, orderID)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			if err := s.inventory.TxReleaseSku(tx, item.SkuID, item.Count); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *mysqlStore) GetOpenID(userID uint32) (string, error) {
+	return user.GetOpenID(s.db, userID)
+}
+
+func (s *mysqlStore) SavePrepay(orderID uint32, prepayID string, amount money.Money) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, status, err := TxLockOrderStatus(tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	if status != StatusPendingPayment {
+		return ErrOrderNotPayable
+	}
+
+	if err := TxUpsertPayment(tx, orderID, prepayID, amount); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *mysqlStore) Paid(outTradeNo, transactionID string, total int64, paidAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	payment, err := TxLockPaymentByOutTradeNo(tx, outTradeNo)
+	if err == sql.ErrNoRows {
+		return ErrPaymentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if payment.Status == PaymentPaid {
+		return nil
+	}
+
+	if payment.Amount.Amount != total {
+		return fmt.Errorf("%w: payment %s amount %s, paid %d", ErrAmountMismatch, outTradeNo,
+			payment.Amount, total)
+	}
+
+	if err := TxPaymentPaid(tx, payment.ID, transactionID, paidAt); err != nil {
+		return err
+	}
+
+	err = TxTransition(tx, payment.OrderID, StatusPaid, Actor{Kind: ActorSystem})
+	if errors.Is(err, ErrInvalidTransition) {
+		// the order was cancelled while paying, keep the payment for a manual refund.
+		log.Printf("order %d paid by %s but can not be marked as paid: %v", payment.OrderID, transactionID, err)
+		return tx.Commit()
+	} else if err != nil {
+		return err
+	}
+
+	items, err := TxInfoItemByOrderID(tx, payment.OrderID)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := s.inventory.TxAddSpuSales(tx, item.SpuID, item.Count); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *mysqlStore) RequestRefund(userID uint32, req *RefundRequest) (*Payment, []*Refund, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	order, err := TxInfoOrderByID(tx, userID, req.OrderID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	payment, err := TxLockPaymentByOrderID(tx, order.ID)
+	if err == sql.ErrNoRows || (err == nil && payment.Status != PaymentPaid) {
+		return nil, nil, ErrOrderNotPaid
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var refunds []*Refund
+	for _, item := range order.Items {
+		if req.ItemID != 0 && item.ID != req.ItemID {
+			continue
+		}
+
+		refund, err := TxLockPendingRefundByItemID(tx, item.ID)
+		if err == nil {
+			refunds = append(refunds, refund)
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return nil, nil, err
+		}
+
+		count, err := refundCount(item, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		if count == 0 {
+			continue
+		}
+
+		refund = newRefund(order.ID, item, count, req.Reason)
+		if err := TxInsertRefund(tx, refund); err != nil {
+			return nil, nil, err
+		}
+		refunds = append(refunds, refund)
+	}
+
+	if len(refunds) == 0 {
+		return nil, nil, ErrItemNotExists
+	}
+
+	covered, err := TxIsRefundCovered(tx, order.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// only a refund of the whole order moves it to refunding.
+	if covered && order.Status != StatusRefunding {
+		if err := TxTransition(tx, order.ID, StatusRefunding, Actor{Kind: ActorUser, ID: userID}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return payment, refunds, tx.Commit()
+}
+
+func (s *mysqlStore) RefundDone(outRefundNo, refundID string, status uint8, refundedAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	refund, err := TxLockRefundByOutRefundNo(tx, outRefundNo)
+	if err != nil {
+		return err
+	}
+
+	if refund.Status != RefundPending {
+		return nil
+	}
+
+	if err := TxRefundDone(tx, refund.ID, status, refundID, refundedAt); err != nil {
+		return err
+	}
+
+	if status != RefundSuccess {
+		// the order can not be fully refunded anymore until it is requested again.
+		_, orderStatus, err := TxLockOrderStatus(tx, refund.OrderID)
+		if err != nil {
+			return err
+		}
+
+		if orderStatus == StatusRefunding {
+			err := TxTransition(tx, refund.OrderID, StatusRefundFailed, Actor{Kind: ActorSystem})
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Commit()
+	}
+
+	if err := TxItemRefunded(tx, refund.ItemID, refund.Count); err != nil {
+		return err
+	}
+
+	if err := s.inventory.TxReleaseSku(tx, refund.SkuID, refund.Count); err != nil {
+		return err
+	}
+
+	refunded, err := TxIsFullyRefunded(tx, refund.OrderID)
+	if err != nil {
+		return err
+	}
+
+	if refunded {
+		err := TxTransition(tx, refund.OrderID, StatusRefunded, Actor{Kind: ActorSystem})
+		if errors.Is(err, ErrInvalidTransition) {
+			log.Printf("order %d is refunded but can not be marked as refunded: %v", refund.OrderID, err)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// refundCount returns the count of the item to refund by the request, 0 if
+// every item is refunded and the item has nothing left.
+func refundCount(item *Item, req *RefundRequest) (uint32, error) {
+	remaining := item.Count - item.RefundedCount
+	count := req.Count
+	if count == 0 {
+		count = remaining
+	}
+	if count == 0 && req.ItemID == 0 {
+		return 0, nil
+	}
+	if count == 0 || count > remaining {
+		return 0, ErrInvalidRefundCount
+	}
+
+	return count, nil
+}
+
+func newRefund(orderID uint32, item *Item, count uint32, reason string) *Refund {
+	return &Refund{
+		OrderID: orderID,
+		ItemID:  item.ID,
+		SkuID:   item.SkuID,
+		Count:   count,
+		Amount:  item.Price.Mul(int64(count)),
+		Reason:  reason,
+	}
+}
@@ -1,64 +1,51 @@
 package controller
 
 import (
-	jwt "github.com/appleboy/gin-jwt/v2"
-	"github.com/dovics/wx-demo/pkg/user/model"
-	"github.com/dovics/wx-demo/util/user"
+	"database/sql"
+
+	"github.com/dovics/wx-demo/util/config"
+	"github.com/dovics/wx-demo/util/jwt"
 
-	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-//CheckActive middleware that checks the active
-func (c *Controller) CheckActive() func(ctx *gin.Context) {
-	return func(ctx *gin.Context) {
-		a, err := user.GetID(ctx)
-		if err != nil {
-			_ = ctx.AbortWithError(http.StatusBadRequest, err)
-			return
-		}
-
-		active, err := model.IsActive(c.db, a)
-		if err != nil {
-			_ = ctx.AbortWithError(http.StatusConflict, err)
-			return
-		}
-
-		if !active {
-			_ = ctx.AbortWithError(http.StatusLocked, errActive)
-			ctx.JSON(http.StatusLocked, gin.H{"status": http.StatusLocked})
-			return
-		}
+func (c *Controller) newJWTMiddleware() (*jwt.Middleware, error) {
+	keys, err := jwt.LoadKeySet("jwt.user")
+	if err != nil {
+		return nil, err
 	}
-}
 
-func (c *Controller) newJWTMiddleware() (*jwt.GinJWTMiddleware, error) {
-	return jwt.New(&jwt.GinJWTMiddleware{
-		Realm:       "test-pet",
-		Key:         []byte("moli-tech-cats-member"),
-		Timeout:     140 * time.Hour,
-		MaxRefresh:  140 * time.Hour,
+	return jwt.New(&jwt.Middleware{
+		Realm:   "test-pet",
+		Keys:    keys,
+		Timeout: config.GetDuration("jwt.user.timeout"),
+		// tokens are issued by LoginHandler and RefreshHandler of the
+		// controller, paired with refresh tokens.
 		IdentityKey: "userID",
-		// use data as userID here.
-		PayloadFunc: func(data interface{}) jwt.MapClaims {
-			return jwt.MapClaims{
-				"userID": data,
-			}
-		},
 		// just get the ID
 		IdentityHandler: func(ctx *gin.Context) interface{} {
 			claims := jwt.ExtractClaims(ctx)
 			return claims["userID"]
 		},
-		Authenticator: func(ctx *gin.Context) (interface{}, error) {
-			return c.Login(ctx)
-		},
-		// no need to check user valid every time.
+		// no need to check user valid every time, deactivating a user
+		// revokes its tokens.
 		Authorizator: func(data interface{}, ctx *gin.Context) bool {
 			return true
 		},
+		IsRevoked: func(claims jwt.MapClaims) (bool, error) {
+			id, ok := claims["userID"].(float64)
+			if !ok {
+				return true, nil
+			}
+
+			revoked, err := c.revocations.IsRevoked(uint32(id), claims.ID(), claims.IssuedAt())
+			if err == sql.ErrNoRows {
+				return true, nil
+			}
+			return revoked, err
+		},
 		Unauthorized: func(ctx *gin.Context, code int, message string) {
 			ctx.JSON(code, gin.H{
 				"code":    code,
@@ -72,7 +59,6 @@ func (c *Controller) newJWTMiddleware() (*jwt.GinJWTMiddleware, error) {
 		// - "header:<name>"
 		// - "query:<name>"
 		// - "cookie:<name>"
-		// - "param:<name>"
 		TokenLookup: "header: Authorization, query: token, cookie: JWT",
 		// TokenLookup: "query:token",
 		// TokenLookup: "cookie:token",
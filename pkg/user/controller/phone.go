@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/dovics/wx-demo/pkg/user/model"
+	"github.com/dovics/wx-demo/util/config"
+	"github.com/dovics/wx-demo/util/user"
+	"github.com/dovics/wx-demo/util/wxdata"
+	"github.com/gin-gonic/gin"
+)
+
+var errNoPhone = errors.New("the data has no phone number")
+
+// bindPhone binds the phone number of getPhoneNumber to the user. The data is
+// sealed with the session key of the last login, so the client logs in again
+// when it gets a 400.
+func (c *Controller) bindPhone(ctx *gin.Context) {
+	var req struct {
+		EncryptedData string `json:"encrypted_data" binding:"required"`
+		IV            string `json:"iv"             binding:"required"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	id, err := user.GetID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	sessionKey, err := c.store.GetSessionKey(id)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	var phone wxdata.PhoneNumber
+	err = wxdata.Decrypt(sessionKey, req.EncryptedData, req.IV, config.GetString("wx.appid"), &phone)
+	switch {
+	case err == wxdata.ErrWatermark:
+		ctx.Error(err)
+		ctx.JSON(http.StatusForbidden, gin.H{"status": http.StatusForbidden})
+		return
+	case err != nil:
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	case phone.PhoneNumber == "":
+		ctx.Error(errNoPhone)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	err = c.store.BindPhone(id, phone.PhoneNumber, config.GetDuration("wx.phone_rebind_interval"))
+	switch err {
+	case nil:
+	case model.ErrPhoneTaken:
+		ctx.Error(err)
+		ctx.JSON(http.StatusConflict, gin.H{"status": http.StatusConflict})
+		return
+	case model.ErrPhoneRebindTooSoon:
+		ctx.Error(err)
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"status": http.StatusTooManyRequests})
+		return
+	default:
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK, "phone": phone.PhoneNumber})
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dovics/wx-demo/pkg/user/model"
+	"github.com/dovics/wx-demo/util/config"
+	"github.com/dovics/wx-demo/util/user"
+	"github.com/dovics/wx-demo/util/wxdata"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errNoProfile = errors.New("the request has neither raw data nor encrypted data")
+	errOpenID    = errors.New("the data belongs to another user")
+)
+
+// modifyProfile stores the profile of getUserProfile, verified by the
+// signature of its rawData or decrypted from its encryptedData. Only the
+// encryptedData has the unionid, it wins when both are posted. As bindPhone,
+// a 400 means the client should log in again to renew the session key.
+func (c *Controller) modifyProfile(ctx *gin.Context) {
+	var req struct {
+		RawData       string `json:"raw_data"`
+		Signature     string `json:"signature"`
+		EncryptedData string `json:"encrypted_data"`
+		IV            string `json:"iv"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if req.RawData == "" && req.EncryptedData == "" {
+		ctx.Error(errNoProfile)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	id, err := user.GetID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	sessionKey, err := c.store.GetSessionKey(id)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	var profile wxdata.UserInfo
+	if req.RawData != "" {
+		if err := wxdata.CheckSignature(sessionKey, req.RawData, req.Signature); err != nil {
+			ctx.Error(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+			return
+		}
+
+		if err := json.Unmarshal([]byte(req.RawData), &profile); err != nil {
+			ctx.Error(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+			return
+		}
+	}
+
+	if req.EncryptedData != "" {
+		var decrypted wxdata.UserInfo
+		err := wxdata.Decrypt(sessionKey, req.EncryptedData, req.IV, config.GetString("wx.appid"), &decrypted)
+		if err == wxdata.ErrWatermark {
+			ctx.Error(err)
+			ctx.JSON(http.StatusForbidden, gin.H{"status": http.StatusForbidden})
+			return
+		}
+		if err != nil {
+			ctx.Error(err)
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+			return
+		}
+
+		openID, err := c.store.GetOpenID(id)
+		if err != nil {
+			ctx.Error(err)
+			ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+			return
+		}
+
+		if decrypted.OpenID != openID {
+			ctx.Error(errOpenID)
+			ctx.JSON(http.StatusForbidden, gin.H{"status": http.StatusForbidden})
+			return
+		}
+		profile = decrypted
+	}
+
+	info := &model.UserInfo{
+		NickName: profile.NickName,
+		Avatar:   profile.AvatarURL,
+		Gender:   profile.Gender,
+		City:     profile.City,
+		Province: profile.Province,
+		Country:  profile.Country,
+	}
+	if err := c.store.ModifyUserProfile(id, info); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	if profile.UnionID != "" {
+		if err := c.store.UpdateUnionID(id, profile.UnionID); err != nil {
+			ctx.Error(err)
+			ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dovics/wx-demo/pkg/user/model"
+	"github.com/dovics/wx-demo/util/config"
+	"github.com/dovics/wx-demo/util/jwt"
+	"github.com/gin-gonic/gin"
+)
+
+// LoginHandler logs in with the code of wx.login. Reply will be of the form
+// {"token": "ACCESS TOKEN", "refresh_token": "REFRESH TOKEN"}, the refresh
+// token is bound to device_id.
+func (c *Controller) LoginHandler(ctx *gin.Context) {
+	var req struct {
+		Code     string `json:"code"        binding:"required"`
+		DeviceID string `json:"device_id"   binding:"required,max=128"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		c.JWT.Reject(ctx, http.StatusUnauthorized, err)
+		return
+	}
+
+	id, err := c.Login(req.Code)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrWxInvalidCode) || err == errActive:
+		c.JWT.Reject(ctx, http.StatusUnauthorized, err)
+		return
+	case errors.Is(err, ErrWxUnavailable):
+		ctx.Error(err)
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": http.StatusServiceUnavailable})
+		return
+	default:
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	family, err := model.NewFamilyID()
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError})
+		return
+	}
+
+	refresh, hash, err := model.NewRefreshToken()
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError})
+		return
+	}
+
+	t := &model.RefreshToken{
+		Hash:     hash,
+		FamilyID: family,
+		UserID:   id,
+		DeviceID: req.DeviceID,
+		ExpireAt: time.Now().Add(config.GetDuration("jwt.user.refresh_timeout")),
+	}
+	if err := c.store.CreateRefreshToken(t); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	c.respondTokens(ctx, t, refresh)
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and a new
+// refresh token. Every refresh token is used once, using it again revokes
+// every refresh token rotated from the same login.
+func (c *Controller) RefreshHandler(ctx *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"   binding:"required"`
+		DeviceID     string `json:"device_id"       binding:"required"`
+	}
+
+	if err := ctx.ShouldBind(&req); err != nil {
+		c.JWT.Reject(ctx, http.StatusUnauthorized, err)
+		return
+	}
+
+	refresh, hash, err := model.NewRefreshToken()
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError})
+		return
+	}
+
+	next := &model.RefreshToken{
+		Hash:     hash,
+		ExpireAt: time.Now().Add(config.GetDuration("jwt.user.refresh_timeout")),
+	}
+	err = c.store.RotateRefreshToken(model.HashRefreshToken(req.RefreshToken), req.DeviceID, next)
+	if errors.Is(err, model.ErrInvalidRefreshToken) || errors.Is(err, model.ErrRefreshTokenReused) {
+		c.JWT.Reject(ctx, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	c.respondTokens(ctx, next, refresh)
+}
+
+// respondTokens replies an access token of the family of t along with the
+// refresh token t was stored for.
+func (c *Controller) respondTokens(ctx *gin.Context, t *model.RefreshToken, refresh string) {
+	token, expire, err := c.JWT.TokenGenerator(jwt.MapClaims{
+		"userID": t.UserID,
+		"fid":    t.FamilyID,
+	})
+	if err != nil {
+		ctx.Error(err)
+		c.JWT.Reject(ctx, http.StatusUnauthorized, jwt.ErrFailedTokenCreation)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code":           http.StatusOK,
+		"token":          token,
+		"expire":         expire.Format(time.RFC3339),
+		"refresh_token":  refresh,
+		"refresh_expire": t.ExpireAt.Format(time.RFC3339),
+	})
+}
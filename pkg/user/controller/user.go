@@ -2,37 +2,42 @@ package controller
 
 import (
 	"database/sql"
-	"encoding/json"
 	"errors"
-	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
-
-	jwt "github.com/appleboy/gin-jwt/v2"
+	"time"
 
 	"github.com/dovics/wx-demo/pkg/user/model"
 	"github.com/dovics/wx-demo/util/config"
+	"github.com/dovics/wx-demo/util/jwt"
 	"github.com/dovics/wx-demo/util/user"
 	"github.com/gin-gonic/gin"
 )
 
 var (
-	errActive = errors.New("the user is not activated")
+	errActive  = errors.New("the user is not activated")
+	errNoToken = errors.New("the token has no id, log out all devices instead")
 )
 
 // Controller external service interface
 type Controller struct {
-	db     *sql.DB
-	JWT    *jwt.GinJWTMiddleware
-	client *http.Client
+	store       model.Store
+	revocations *model.RevocationCache
+	JWT         *jwt.Middleware
+	client      *http.Client
 }
 
 // New create an external service interface
 func New(db *sql.DB) *Controller {
+	return NewWithStore(model.NewMySQLStore(db))
+}
+
+// NewWithStore create an external service interface on store.
+func NewWithStore(store model.Store) *Controller {
 	c := &Controller{
-		db:     db,
-		client: http.DefaultClient,
+		store:       store,
+		revocations: model.NewRevocationCache(store, config.GetDuration("jwt.user.revocation_cache")),
+		client:      &http.Client{Timeout: config.GetDuration("wx.login.timeout")},
 	}
 	var err error
 	c.JWT, err = c.newJWTMiddleware()
@@ -47,80 +52,70 @@ func (c *Controller) RegisterRouter(r gin.IRouter) {
 	if r == nil {
 		log.Fatal("[InitRouter]: server is nil")
 	}
-	err := model.CreateDatabase(c.db)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = model.CreateTable(c.db)
-	if err != nil {
-		log.Fatal(err)
-	}
 
 	r.GET("/info", c.getUserInfo)
-	r.POST("/modify/active", c.modifyUserActive)
 	r.POST("/modify/info", c.modifyUserInfo)
+	r.POST("/modify/profile", c.modifyProfile)
+	r.POST("/bind/phone", c.bindPhone)
+	r.POST("/logout", c.logout)
+	r.POST("/logout/all", c.logoutAll)
 }
 
-//Login JWT validation
-func (c *Controller) Login(ctx *gin.Context) (uint32, error) {
-	var req struct {
-		Code string `json:"code"      binding:"required"`
-	}
-
-	err := ctx.ShouldBind(&req)
-	if err != nil {
-		return 0, err
+// RegisterManageRouter register the user management routes, r should only be
+// reachable by admin accounts of the back office.
+func (c *Controller) RegisterManageRouter(r gin.IRouter) {
+	if r == nil {
+		log.Fatal("[InitRouter]: server is nil")
 	}
 
-	resp, err := c.client.Get(BuildWxLoginUrl(req.Code))
-	if err != nil {
-		return 0, err
-	}
+	r.POST("/modify/active", c.modifyUserActive)
+}
 
-	buf, err := ioutil.ReadAll(resp.Body)
+// Login returns the user of the wx.login code, the user is created on its
+// first login. It fails with ErrWxInvalidCode or ErrWxUnavailable when WeChat
+// gives no session for the code.
+func (c *Controller) Login(code string) (uint32, error) {
+	wx, err := c.code2Session(code)
 	if err != nil {
 		return 0, err
 	}
 
-	var wx WxResponse
-	if err := json.Unmarshal(buf, &wx); err != nil {
-		return 0, err
-	}
-
-	id, err := model.IsExist(c.db, wx.OpenID)
+	id, err := c.store.IsExist(wx.OpenID)
 	if err != sql.ErrNoRows && err != nil {
 		return 0, err
 	}
 
 	if id == 0 {
-		id, err = model.CreateUser(c.db, wx.OpenID, wx.SessionKey)
+		id, err = c.store.CreateUser(wx.OpenID, wx.SessionKey)
 		if err != nil {
 			log.Println("create user fail: ", err)
 			return 0, err
 		}
 	} else {
-		if err := model.UpdateSessionKey(c.db, id, wx.SessionKey); err != nil {
+		active, err := c.store.IsActive(id)
+		if err != nil {
+			return 0, err
+		}
+
+		if !active {
+			return 0, errActive
+		}
+
+		if err := c.store.UpdateSessionKey(id, wx.SessionKey); err != nil {
 			log.Println("update session key fail: ", err)
 			return 0, err
 		}
 	}
 
-	return id, nil
-}
-
-type WxResponse struct {
-	OpenID     string
-	SessionKey string
-	UnionID    string
-	ErrCode    int
-	ErrMsg     string
-}
+	// the unionid is only given once the mini-program is bound to an open
+	// platform account, it links the user to our official account.
+	if wx.UnionID != "" {
+		if err := c.store.UpdateUnionID(id, wx.UnionID); err != nil {
+			return 0, err
+		}
+	}
 
-func BuildWxLoginUrl(code string) string {
-	appid := config.GetString("wx.appid")
-	secret := config.GetString("wx.secret")
-	return fmt.Sprintf("https://api.weixin.qq.com/sns/jscode2session?appid=%s&secret=%s&js_code=%s&grant_type=authorization_code", appid, secret, code)
+	return id, nil
 }
 
 func (c *Controller) modifyUserActive(ctx *gin.Context) {
@@ -136,8 +131,72 @@ func (c *Controller) modifyUserActive(ctx *gin.Context) {
 		return
 	}
 
-	err = model.ModifyUserActive(c.db, req.CheckID, req.CheckActive)
+	err = c.store.ModifyUserActive(req.CheckID, req.CheckActive)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	// a deactivated user is logged out of every device, and can not log in
+	// again until activated.
+	if !req.CheckActive {
+		if err := c.revokeUser(req.CheckID); err != nil {
+			ctx.Error(err)
+			ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
+
+// logout revokes the token of the request and the refresh tokens of its
+// device. Tokens issued before tokens had ids can only be revoked by
+// logoutAll.
+func (c *Controller) logout(ctx *gin.Context) {
+	id, err := user.GetID(ctx)
+	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	claims := jwt.ExtractClaims(ctx)
+	if claims.ID() == "" {
+		ctx.Error(errNoToken)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if err := c.revocations.RevokeToken(id, claims.ID(), claims.ExpiresAt()); err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return
+	}
+
+	if family, ok := claims["fid"].(string); ok {
+		if err := c.store.RevokeRefreshFamily(family); err != nil {
+			ctx.Error(err)
+			ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
+}
+
+// logoutAll revokes every access and refresh token of the user issued until
+// now.
+func (c *Controller) logoutAll(ctx *gin.Context) {
+	id, err := user.GetID(ctx)
 	if err != nil {
+		ctx.Error(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest})
+		return
+	}
+
+	if err := c.revokeUser(id); err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
@@ -146,14 +205,19 @@ func (c *Controller) modifyUserActive(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"status": http.StatusOK})
 }
 
+func (c *Controller) revokeUser(id uint32) error {
+	if err := c.store.RevokeUserRefreshTokens(id); err != nil {
+		return err
+	}
+
+	return c.revocations.RevokeUser(id, time.Now())
+}
+
 func (c *Controller) modifyUserInfo(ctx *gin.Context) {
 	var req struct {
 		NickName string `json:"nick_name,omitempty"`
 		Avatar   string `json:"avatar,omitempty"`
 		Gender   int    `json:"gender,omitempty"`
-		City     string `json:"city,omitempty"`
-		Province string `json:"province,omitempty"`
-		Country  string `json:"country,omitempty"`
 	}
 
 	if err := ctx.ShouldBind(&req); err != nil {
@@ -169,7 +233,12 @@ func (c *Controller) modifyUserInfo(ctx *gin.Context) {
 		return
 	}
 
-	if err := model.ModifyUserInfo(c.db, id, req.NickName, req.Avatar, req.Gender); err != nil {
+	info := &model.UserInfo{
+		NickName: req.NickName,
+		Avatar:   req.Avatar,
+		Gender:   req.Gender,
+	}
+	if err := c.store.ModifyUserInfo(id, info); err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
 		return
@@ -186,7 +255,7 @@ func (c *Controller) getUserInfo(ctx *gin.Context) {
 		return
 	}
 
-	info, err := model.GetUserInfo(c.db, id)
+	info, err := c.store.GetUserInfo(id)
 	if err != nil {
 		ctx.Error(err)
 		ctx.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
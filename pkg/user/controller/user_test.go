@@ -0,0 +1,564 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	// the default jwt realms
+	_ "github.com/dovics/wx-demo/config"
+	"github.com/dovics/wx-demo/pkg/user/model"
+	"github.com/dovics/wx-demo/util/config"
+	"github.com/dovics/wx-demo/util/wxdata"
+	"github.com/gin-gonic/gin"
+	gojwt "github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	testSessionKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	testIV         = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210"))
+)
+
+// wxTransport answers jscode2session with the openid of the code, except for
+// the codes of the failures of WeChat.
+type wxTransport struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (wx *wxTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	code := req.URL.Query().Get("js_code")
+
+	wx.mu.Lock()
+	if wx.calls == nil {
+		wx.calls = make(map[string]int)
+	}
+	wx.calls[code]++
+	calls := wx.calls[code]
+	wx.mu.Unlock()
+
+	status := http.StatusOK
+	answer := map[string]interface{}{
+		"openid":      "openid-" + code,
+		"session_key": testSessionKey,
+	}
+	switch {
+	case code == "invalid":
+		answer = map[string]interface{}{"errcode": 40029, "errmsg": "invalid code"}
+	case code == "empty":
+		answer = map[string]interface{}{}
+	case code == "down":
+		status = http.StatusBadGateway
+	case code == "busy" && calls == 1:
+		answer = map[string]interface{}{"errcode": -1, "errmsg": "system error"}
+	case code == "union":
+		answer["unionid"] = "union-" + code
+	}
+	body, _ := json.Marshal(answer)
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func newTestRouter(t *testing.T) (*gin.Engine, *model.MemoryStore) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := model.NewMemoryStore()
+	c := NewWithStore(store)
+	c.client = &http.Client{Transport: &wxTransport{}}
+
+	r := gin.New()
+	r.POST("/api/v1/user/login", c.LoginHandler)
+	r.POST("/api/v1/user/refresh_token", c.RefreshHandler)
+	// the back office authenticates its own accounts, see pkg/admin.
+	c.RegisterManageRouter(r.Group("/api/admin/v1/user"))
+	c.RegisterRouter(r.Group("/api/v1/user", c.JWT.MiddlewareFunc()))
+
+	return r, store
+}
+
+func do(t *testing.T, r http.Handler, method, path, token string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func login(t *testing.T, r http.Handler, code string) string {
+	t.Helper()
+
+	token, _ := session(t, r, code, "phone")
+	return token
+}
+
+// session logs in from device and returns the access and refresh token.
+func session(t *testing.T, r http.Handler, code, device string) (string, string) {
+	t.Helper()
+
+	w, resp := do(t, r, http.MethodPost, "/api/v1/user/login", "", gin.H{"code": code, "device_id": device})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login status = %d, body %s", w.Code, w.Body)
+	}
+
+	token, _ := resp["token"].(string)
+	refresh, _ := resp["refresh_token"].(string)
+	if token == "" || refresh == "" {
+		t.Fatalf("login returned no token: %s", w.Body)
+	}
+
+	return token, refresh
+}
+
+func refresh(t *testing.T, r http.Handler, refreshToken, device string) (int, string) {
+	t.Helper()
+
+	w, resp := do(t, r, http.MethodPost, "/api/v1/user/refresh_token", "",
+		gin.H{"refresh_token": refreshToken, "device_id": device})
+	next, _ := resp["refresh_token"].(string)
+	return w.Code, next
+}
+
+func TestLogin(t *testing.T) {
+	r, store := newTestRouter(t)
+
+	login(t, r, "a")
+	login(t, r, "a")
+
+	id, err := store.IsExist("openid-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 1000 {
+		t.Errorf("user id = %d, want 1000", id)
+	}
+
+	if _, err := store.IsExist("openid-b"); err == nil {
+		t.Error("login created an unexpected user")
+	}
+
+	for _, body := range []gin.H{{"device_id": "phone"}, {"code": "a"}} {
+		if w, _ := do(t, r, http.MethodPost, "/api/v1/user/login", "", body); w.Code != http.StatusUnauthorized {
+			t.Errorf("login %v status = %d, want %d", body, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestLoginWxErrors(t *testing.T) {
+	r, store := newTestRouter(t)
+
+	for code, want := range map[string]int{
+		"invalid": http.StatusUnauthorized,
+		"empty":   http.StatusServiceUnavailable,
+		"down":    http.StatusServiceUnavailable,
+	} {
+		w, _ := do(t, r, http.MethodPost, "/api/v1/user/login", "", gin.H{"code": code, "device_id": "phone"})
+		if w.Code != want {
+			t.Errorf("login %s status = %d, want %d", code, w.Code, want)
+		}
+	}
+
+	if _, err := store.IsExist(""); err == nil {
+		t.Error("login created a user without openid")
+	}
+
+	login(t, r, "union")
+	id, err := store.IsExist("openid-union")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unionID, _ := store.GetUnionID(id); unionID != "union-union" {
+		t.Errorf("unionid = %q, want %q", unionID, "union-union")
+	}
+}
+
+func TestCode2SessionRetry(t *testing.T) {
+	c := NewWithStore(model.NewMemoryStore())
+	wx := &wxTransport{}
+	c.client = &http.Client{Transport: wx}
+
+	if _, err := c.Login("busy"); err != nil {
+		t.Errorf("login busy error = %v", err)
+	}
+
+	_, err := c.Login("down")
+	if !errors.Is(err, ErrWxUnavailable) {
+		t.Errorf("login down error = %v, want %v", err, ErrWxUnavailable)
+	}
+
+	_, err = c.Login("invalid")
+	var wxErr *WxError
+	if !errors.Is(err, ErrWxInvalidCode) || !errors.As(err, &wxErr) || wxErr.Code != 40029 {
+		t.Errorf("login invalid error = %v, want %v", err, ErrWxInvalidCode)
+	}
+
+	retries := config.GetInt("wx.login.retries")
+	for code, want := range map[string]int{"busy": 2, "down": retries + 1, "invalid": 1} {
+		if wx.calls[code] != want {
+			t.Errorf("jscode2session %s calls = %d, want %d", code, wx.calls[code], want)
+		}
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	r, _ := newTestRouter(t)
+	_, first := session(t, r, "a", "phone")
+
+	w, resp := do(t, r, http.MethodPost, "/api/v1/user/refresh_token", "",
+		gin.H{"refresh_token": first, "device_id": "phone"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh status = %d, body %s", w.Code, w.Body)
+	}
+
+	token, _ := resp["token"].(string)
+	second, _ := resp["refresh_token"].(string)
+	if token == "" || second == "" || second == first {
+		t.Fatalf("refresh did not rotate the tokens: %s", w.Body)
+	}
+
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/user/info", token, nil); w.Code != http.StatusOK {
+		t.Errorf("info with refreshed token status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if code, _ := refresh(t, r, "unknown", "phone"); code != http.StatusUnauthorized {
+		t.Errorf("refresh of unknown token status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/user/refresh_token", "", gin.H{"refresh_token": second}); w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh without device status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// the rotated token is replayed, the whole family goes.
+	if code, _ := refresh(t, r, first, "phone"); code != http.StatusUnauthorized {
+		t.Errorf("refresh of rotated token status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := refresh(t, r, second, "phone"); code != http.StatusUnauthorized {
+		t.Errorf("refresh in revoked family status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestRefreshTokenDevice(t *testing.T) {
+	r, _ := newTestRouter(t)
+	_, phone := session(t, r, "a", "phone")
+	_, laptop := session(t, r, "a", "laptop")
+
+	if code, _ := refresh(t, r, phone, "laptop"); code != http.StatusUnauthorized {
+		t.Errorf("refresh from another device status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := refresh(t, r, phone, "phone"); code != http.StatusUnauthorized {
+		t.Errorf("refresh of stolen token status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	if code, _ := refresh(t, r, laptop, "laptop"); code != http.StatusOK {
+		t.Errorf("refresh of other family status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	r, _ := newTestRouter(t)
+	token := login(t, r, "a")
+
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/user/info", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("info without token status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// the location is only taken from the profile verified by WeChat
+	body := gin.H{"nick_name": "cat", "avatar": "a.png", "gender": 2, "city": "Xi'an"}
+	for i := 0; i < 2; i++ {
+		if w, _ := do(t, r, http.MethodPost, "/api/v1/user/modify/info", token, body); w.Code != http.StatusOK {
+			t.Fatalf("modify info %d status = %d, body %s", i+1, w.Code, w.Body)
+		}
+	}
+
+	w, resp := do(t, r, http.MethodGet, "/api/v1/user/info", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("info status = %d, body %s", w.Code, w.Body)
+	}
+
+	info, _ := resp["info"].(map[string]interface{})
+	if info["NickName"] != "cat" || info["Avatar"] != "a.png" || info["Gender"] != float64(2) || info["City"] != "" {
+		t.Errorf("info = %v", info)
+	}
+}
+
+func TestModifyUserActive(t *testing.T) {
+	r, store := newTestRouter(t)
+	token := login(t, r, "a")
+	id, _ := store.IsExist("openid-a")
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/user/modify/active", token,
+		gin.H{"check_id": id, "check_active": false}); w.Code != http.StatusNotFound {
+		t.Errorf("modify active by customer status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/admin/v1/user/modify/active", "", gin.H{}); w.Code != http.StatusBadRequest {
+		t.Errorf("modify active without id status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/admin/v1/user/modify/active", "",
+		gin.H{"check_id": 9999, "check_active": false}); w.Code != http.StatusBadGateway {
+		t.Errorf("modify active of unknown user status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+
+	w, _ := do(t, r, http.MethodPost, "/api/admin/v1/user/modify/active", "",
+		gin.H{"check_id": id, "check_active": false})
+	if w.Code != http.StatusOK {
+		t.Fatalf("modify active status = %d, body %s", w.Code, w.Body)
+	}
+
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/user/info", token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("info of locked user status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/user/login", "", gin.H{"code": "a", "device_id": "phone"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("login of locked user status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	do(t, r, http.MethodPost, "/api/admin/v1/user/modify/active", "", gin.H{"check_id": id, "check_active": true})
+	// a token issued in the millisecond of the revocation is revoked too.
+	time.Sleep(2 * time.Millisecond)
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/user/info", login(t, r, "a"), nil); w.Code != http.StatusOK {
+		t.Errorf("info of activated user status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	r, _ := newTestRouter(t)
+	phone, phoneRefresh := session(t, r, "a", "phone")
+	laptop, laptopRefresh := session(t, r, "a", "laptop")
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/user/logout", phone, nil); w.Code != http.StatusOK {
+		t.Fatalf("logout status = %d, body %s", w.Code, w.Body)
+	}
+
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/user/info", phone, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("info with revoked token status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if code, _ := refresh(t, r, phoneRefresh, "phone"); code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/user/info", laptop, nil); w.Code != http.StatusOK {
+		t.Errorf("info with other token status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if code, _ := refresh(t, r, laptopRefresh, "laptop"); code != http.StatusOK {
+		t.Errorf("refresh of other device status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	r, _ := newTestRouter(t)
+	phone := login(t, r, "a")
+	laptop, laptopRefresh := session(t, r, "a", "laptop")
+	other := login(t, r, "b")
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/user/logout/all", phone, nil); w.Code != http.StatusOK {
+		t.Fatalf("logout all status = %d, body %s", w.Code, w.Body)
+	}
+
+	for _, token := range []string{phone, laptop} {
+		if w, _ := do(t, r, http.MethodGet, "/api/v1/user/info", token, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("info after logout all status = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	if code, _ := refresh(t, r, laptopRefresh, "laptop"); code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout all status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/user/info", other, nil); w.Code != http.StatusOK {
+		t.Errorf("info of other user status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/user/info", login(t, r, "a"), nil); w.Code != http.StatusOK {
+		t.Errorf("info after login again status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+// legacyToken signs a token of the user the way tokens were issued before
+// they had an iat and a jti.
+func legacyToken(t *testing.T, id uint32) string {
+	t.Helper()
+
+	token := gojwt.NewWithClaims(gojwt.SigningMethodHS256, gojwt.MapClaims{
+		"userID":   id,
+		"orig_iat": time.Now().Unix(),
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString([]byte(config.GetString("jwt.user.key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func TestLegacyToken(t *testing.T) {
+	r, store := newTestRouter(t)
+	login(t, r, "a")
+	id, _ := store.IsExist("openid-a")
+	legacy := legacyToken(t, id)
+
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/user/info", legacy, nil); w.Code != http.StatusOK {
+		t.Fatalf("info with legacy token status = %d, body %s", w.Code, w.Body)
+	}
+
+	w, _ := do(t, r, http.MethodPost, "/api/v1/user/logout", legacy, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("logout with legacy token status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/user/logout/all", legacy, nil); w.Code != http.StatusOK {
+		t.Fatalf("logout all status = %d, body %s", w.Code, w.Body)
+	}
+	if w, _ := do(t, r, http.MethodGet, "/api/v1/user/info", legacy, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("info with legacy token after logout all status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+// phoneData seals the getPhoneNumber data of phone for appid.
+func phoneData(t *testing.T, phone, appid string) gin.H {
+	t.Helper()
+
+	plain := fmt.Sprintf(`{"phoneNumber":%q,"purePhoneNumber":%q,"countryCode":"86","watermark":{"appid":%q,"timestamp":1600000000}}`,
+		phone, phone, appid)
+	data, err := wxdata.Encrypt(testSessionKey, testIV, []byte(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return gin.H{"encrypted_data": data, "iv": testIV}
+}
+
+func TestBindPhone(t *testing.T) {
+	r, store := newTestRouter(t)
+	a, b := login(t, r, "a"), login(t, r, "b")
+	appid := config.GetString("wx.appid")
+
+	if w, _ := do(t, r, http.MethodPost, "/api/v1/user/bind/phone", a, phoneData(t, "13800138000", appid)); w.Code != http.StatusOK {
+		t.Fatalf("bind status = %d, body %s", w.Code, w.Body)
+	}
+
+	info, err := store.GetUserInfo(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Phone != "13800138000" {
+		t.Errorf("phone = %q, want %q", info.Phone, "13800138000")
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		body  gin.H
+		want  int
+	}{
+		{"same number", a, phoneData(t, "13800138000", appid), http.StatusOK},
+		{"taken number", b, phoneData(t, "13800138000", appid), http.StatusConflict},
+		{"rebind too soon", a, phoneData(t, "13900139000", appid), http.StatusTooManyRequests},
+		{"other appid", b, phoneData(t, "13900139000", "wx-other"), http.StatusForbidden},
+		{"bad iv", b, gin.H{"encrypted_data": phoneData(t, "13900139000", appid)["encrypted_data"], "iv": "AAAA"}, http.StatusBadRequest},
+		{"no data", b, gin.H{"iv": testIV}, http.StatusBadRequest},
+		{"free number", b, phoneData(t, "13900139000", appid), http.StatusOK},
+	} {
+		if w, _ := do(t, r, http.MethodPost, "/api/v1/user/bind/phone", tc.token, tc.body); w.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+
+	if err := store.BindPhone(1000, "13900139000", 0); err != model.ErrPhoneTaken {
+		t.Errorf("bind taken number error = %v, want %v", err, model.ErrPhoneTaken)
+	}
+	if err := store.BindPhone(1000, "13700137000", 0); err != nil {
+		t.Errorf("rebind after interval error = %v", err)
+	}
+}
+
+func TestModifyProfile(t *testing.T) {
+	r, store := newTestRouter(t)
+	token := login(t, r, "a")
+	appid := config.GetString("wx.appid")
+
+	raw := `{"nickName":"cat","avatarUrl":"a.png","gender":2,"city":"Xi'an","province":"Shaanxi","country":"China","language":"zh_CN"}`
+	sum := sha1.Sum([]byte(raw + testSessionKey))
+	signature := hex.EncodeToString(sum[:])
+
+	encrypt := func(openID, appid string) string {
+		plain := fmt.Sprintf(`{"openId":%q,"unionId":"union-a","nickName":"dog","avatarUrl":"b.png","gender":1,"city":"Beijing","province":"Beijing","country":"China","watermark":{"appid":%q,"timestamp":1600000000}}`,
+			openID, appid)
+		data, err := wxdata.Encrypt(testSessionKey, testIV, []byte(plain))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	for _, tc := range []struct {
+		name string
+		body gin.H
+		want int
+	}{
+		{"nothing", gin.H{}, http.StatusBadRequest},
+		{"bad signature", gin.H{"raw_data": raw, "signature": "0000"}, http.StatusBadRequest},
+		{"other user", gin.H{"encrypted_data": encrypt("openid-b", appid), "iv": testIV}, http.StatusForbidden},
+		{"other appid", gin.H{"encrypted_data": encrypt("openid-a", "wx-other"), "iv": testIV}, http.StatusForbidden},
+		{"raw data", gin.H{"raw_data": raw, "signature": signature}, http.StatusOK},
+	} {
+		if w, _ := do(t, r, http.MethodPost, "/api/v1/user/modify/profile", token, tc.body); w.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+
+	info, err := store.GetUserInfo(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := model.UserInfo{NickName: "cat", Avatar: "a.png", Gender: 2, City: "Xi'an", Province: "Shaanxi", Country: "China"}
+	if *info != want {
+		t.Errorf("info after raw data = %+v, want %+v", *info, want)
+	}
+
+	w, _ := do(t, r, http.MethodPost, "/api/v1/user/modify/profile", token,
+		gin.H{"encrypted_data": encrypt("openid-a", appid), "iv": testIV})
+	if w.Code != http.StatusOK {
+		t.Fatalf("encrypted data status = %d, body %s", w.Code, w.Body)
+	}
+
+	if info, _ := store.GetUserInfo(1000); info.NickName != "dog" || info.City != "Beijing" {
+		t.Errorf("info after encrypted data = %+v", *info)
+	}
+	if unionID, _ := store.GetUnionID(1000); unionID != "union-a" {
+		t.Errorf("unionid = %q, want %q", unionID, "union-a")
+	}
+}
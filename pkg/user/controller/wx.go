@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dovics/wx-demo/util/config"
+)
+
+// errcode of jscode2session
+const (
+	wxErrBusy        = -1
+	wxErrInvalidCode = 40029
+	wxErrUsedCode    = 40163
+	wxErrBlocked     = 40226
+)
+
+var (
+	// ErrWxInvalidCode returned when WeChat refuses the code of wx.login, it is
+	// invalid, already used, or of a blocked user.
+	ErrWxInvalidCode = errors.New("wx: invalid login code")
+
+	// ErrWxUnavailable returned when jscode2session did not answer a session,
+	// the login may succeed later.
+	ErrWxUnavailable = errors.New("wx: jscode2session is unavailable")
+
+	// wxRetryBackoff is the wait before the first retry, doubled after each.
+	wxRetryBackoff = 100 * time.Millisecond
+)
+
+// WxError is a jscode2session answer with a non-zero errcode. It is
+// ErrWxInvalidCode or ErrWxUnavailable for errors.Is.
+type WxError struct {
+	Code int
+	Msg  string
+}
+
+func (e *WxError) Error() string {
+	return fmt.Sprintf("wx: jscode2session errcode %d: %s", e.Code, e.Msg)
+}
+
+func (e *WxError) Is(target error) bool {
+	switch e.Code {
+	case wxErrInvalidCode, wxErrUsedCode, wxErrBlocked:
+		return target == ErrWxInvalidCode
+	default:
+		return target == ErrWxUnavailable
+	}
+}
+
+type WxResponse struct {
+	OpenID     string `json:"openid"`
+	SessionKey string `json:"session_key"`
+	UnionID    string `json:"unionid"`
+	ErrCode    int    `json:"errcode"`
+	ErrMsg     string `json:"errmsg"`
+}
+
+// BuildWxLoginUrl returns the jscode2session url of code under wx.base_url.
+func BuildWxLoginUrl(code string) string {
+	query := url.Values{
+		"appid":      {config.GetString("wx.appid")},
+		"secret":     {config.GetString("wx.secret")},
+		"js_code":    {code},
+		"grant_type": {"authorization_code"},
+	}
+	return strings.TrimSuffix(config.GetString("wx.base_url"), "/") + "/sns/jscode2session?" + query.Encode()
+}
+
+// code2Session exchanges the code of wx.login for the session of the user. It
+// retries up to wx.login.retries times when WeChat is busy or unreachable, a
+// refused code is never retried.
+func (c *Controller) code2Session(code string) (*WxResponse, error) {
+	backoff := wxRetryBackoff
+	for attempt := 0; ; attempt++ {
+		wx, retry, err := c.requestSession(code)
+		if err == nil || !retry || attempt >= config.GetInt("wx.login.retries") {
+			return wx, err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// requestSession calls jscode2session once, retry reports whether the error
+// is worth another attempt.
+func (c *Controller) requestSession(code string) (wx *WxResponse, retry bool, err error) {
+	resp, err := c.client.Get(BuildWxLoginUrl(code))
+	if err != nil {
+		return nil, true, fmt.Errorf("%w: %v", ErrWxUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// drain the body so the connection is reused
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, resp.StatusCode >= http.StatusInternalServerError,
+			fmt.Errorf("%w: http status %d", ErrWxUnavailable, resp.StatusCode)
+	}
+
+	wx = &WxResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(wx); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrWxUnavailable, err)
+	}
+
+	if wx.ErrCode != 0 {
+		return nil, wx.ErrCode == wxErrBusy, &WxError{Code: wx.ErrCode, Msg: wx.ErrMsg}
+	}
+
+	if wx.OpenID == "" || wx.SessionKey == "" {
+		return nil, false, fmt.Errorf("%w: no openid or session key", ErrWxUnavailable)
+	}
+
+	return wx, false, nil
+}
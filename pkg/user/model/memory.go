@@ -0,0 +1,324 @@
+package model
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+type memoryUser struct {
+	openid       string
+	sessionKey   string
+	unionID      string
+	info         UserInfo
+	phoneBoundAt time.Time
+	active       bool
+	revoked      Revocations
+}
+
+type memoryRefreshToken struct {
+	RefreshToken
+	used    bool
+	revoked bool
+}
+
+// MemoryStore is a Store kept in process memory, for tests.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	nextID        uint32
+	users         map[uint32]*memoryUser
+	refreshTokens map[string]*memoryRefreshToken
+}
+
+// NewMemoryStore returns an empty MemoryStore. Ids start at 1000 like the
+// user table.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nextID:        1000,
+		users:         make(map[uint32]*memoryUser),
+		refreshTokens: make(map[string]*memoryRefreshToken),
+	}
+}
+
+func (s *MemoryStore) CreateUser(openid, sessionKey string) (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.openid == openid {
+			return 0, errInvalidMysql
+		}
+	}
+
+	id := s.nextID
+	s.nextID++
+	s.users[id] = &memoryUser{
+		openid:     openid,
+		sessionKey: sessionKey,
+		info:       UserInfo{NickName: " ", Avatar: " "},
+		active:     true,
+		revoked:    Revocations{IDs: make(map[string]bool)},
+	}
+
+	return id, nil
+}
+
+func (s *MemoryStore) IsExist(openid string) (uint32, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for id, u := range s.users {
+		if u.openid == openid {
+			return id, nil
+		}
+	}
+
+	return 0, sql.ErrNoRows
+}
+
+func (s *MemoryStore) UpdateSessionKey(id uint32, sessionKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[id]; ok {
+		u.sessionKey = sessionKey
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) ModifyUserActive(id uint32, active bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return errInvalidMysql
+	}
+	u.active = active
+
+	return nil
+}
+
+func (s *MemoryStore) IsActive(id uint32) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return false, sql.ErrNoRows
+	}
+
+	return u.active, nil
+}
+
+func (s *MemoryStore) GetOpenID(id uint32) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+
+	return u.openid, nil
+}
+
+func (s *MemoryStore) UpdateUnionID(id uint32, unionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[id]; ok {
+		u.unionID = unionID
+	}
+
+	return nil
+}
+
+// GetUnionID returns the unionid stored for the user, for tests.
+func (s *MemoryStore) GetUnionID(id uint32) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+
+	return u.unionID, nil
+}
+
+func (s *MemoryStore) ModifyUserInfo(id uint32, info *UserInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[id]; ok {
+		u.info.NickName = info.NickName
+		u.info.Avatar = info.Avatar
+		u.info.Gender = info.Gender
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) ModifyUserProfile(id uint32, info *UserInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[id]; ok {
+		phone := u.info.Phone
+		u.info = *info
+		u.info.Phone = phone
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetUserInfo(id uint32) (*UserInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	info := u.info
+	return &info, nil
+}
+
+func (s *MemoryStore) GetSessionKey(id uint32) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+
+	return u.sessionKey, nil
+}
+
+func (s *MemoryStore) BindPhone(id uint32, phone string, rebindInterval time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if u.info.Phone == phone {
+		return nil
+	}
+
+	for _, other := range s.users {
+		if other.info.Phone == phone {
+			return ErrPhoneTaken
+		}
+	}
+
+	now := time.Now()
+	if u.info.Phone != "" && now.Before(u.phoneBoundAt.Add(rebindInterval)) {
+		return ErrPhoneRebindTooSoon
+	}
+
+	u.info.Phone, u.phoneBoundAt = phone, now
+	return nil
+}
+
+func (s *MemoryStore) RevokeToken(userID uint32, jti string, expireAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[userID]; ok {
+		u.revoked.IDs[jti] = true
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) RevokeUser(userID uint32, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[userID]; ok {
+		u.revoked.Before = before
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Revocations(userID uint32) (*Revocations, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	r := &Revocations{Before: u.revoked.Before, IDs: make(map[string]bool)}
+	for id := range u.revoked.IDs {
+		r.IDs[id] = true
+	}
+
+	return r, nil
+}
+
+func (s *MemoryStore) CreateRefreshToken(t *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.refreshTokens[t.Hash]; ok {
+		return errInvalidMysql
+	}
+	s.refreshTokens[t.Hash] = &memoryRefreshToken{RefreshToken: *t}
+
+	return nil
+}
+
+func (s *MemoryStore) RotateRefreshToken(hash, deviceID string, next *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.refreshTokens[hash]
+	if !ok || current.revoked || !current.ExpireAt.After(time.Now()) {
+		return ErrInvalidRefreshToken
+	}
+
+	if current.used || current.DeviceID != deviceID {
+		s.revokeRefreshTokens(func(t *memoryRefreshToken) bool { return t.FamilyID == current.FamilyID })
+		return ErrRefreshTokenReused
+	}
+
+	current.used = true
+	next.FamilyID, next.UserID, next.DeviceID = current.FamilyID, current.UserID, current.DeviceID
+	s.refreshTokens[next.Hash] = &memoryRefreshToken{RefreshToken: *next}
+
+	return nil
+}
+
+func (s *MemoryStore) RevokeRefreshFamily(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeRefreshTokens(func(t *memoryRefreshToken) bool { return t.FamilyID == familyID })
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserRefreshTokens(userID uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokeRefreshTokens(func(t *memoryRefreshToken) bool { return t.UserID == userID })
+	return nil
+}
+
+func (s *MemoryStore) revokeRefreshTokens(match func(t *memoryRefreshToken) bool) {
+	for _, t := range s.refreshTokens {
+		if match(t) {
+			t.revoked = true
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+
+	admin "github.com/dovics/wx-demo/pkg/admin/model"
+	"github.com/dovics/wx-demo/util/migrate"
+)
+
+// roleAccountPrefix names the admin accounts of the users whose role moved to
+// the back office, followed by the user id.
+const roleAccountPrefix = "user-"
+
+// Migrations is the versioned schema of the user module.
+var Migrations = migrate.Module{
+	Name: "user",
+	Migrations: []migrate.Migration{
+		{
+			Version:     1,
+			Description: "create user table",
+			Up: migrate.Exec(
+				fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s`, DBName),
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+					id		    	BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+					openid     		VARCHAR(100) UNIQUE NOT NULL,
+					session_key 	VARCHAR(100) NOT NULL,
+					nick_name 		VARCHAR(100) NOT NULL DEFAULT " ",
+					avatar			VARCHAR(512) NOT NULL DEFAULT " ",
+					gender			TINYINT NOT NULL DEFAULT 0 COMMENT '0 unknown 1 man 2 woman',
+					active   		BOOLEAN DEFAULT TRUE,
+					created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (id)
+				) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, TableName),
+			),
+			Down: migrate.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, TableName)),
+		},
+		{
+			Version:     2,
+			Description: "add user role",
+			Up: func(db *sql.DB) error {
+				return migrate.AddColumn(db, DBName, TableName, "role", `VARCHAR(16) NOT NULL DEFAULT "customer" AFTER gender`)
+			},
+			Down: migrate.Exec(fmt.Sprintf(`ALTER TABLE %s.%s DROP COLUMN role`, DBName, TableName)),
+		},
+		{
+			// Roles belong to the admin accounts of the back office now,
+			// customers never manage the shop. Every operator and admin
+			// becomes an inactive account named user-<id> without a
+			// password, an admin sets its password and activates it. The
+			// admin table is created here when the admin module has not
+			// run yet.
+			Version:     3,
+			Description: "move user roles to admin accounts",
+			Up: func(db *sql.DB) error {
+				exists, err := migrate.HasColumn(db, DBName, TableName, "role")
+				if err != nil || !exists {
+					return err
+				}
+
+				if err := admin.CreateDatabase(db); err != nil {
+					return err
+				}
+				if err := admin.CreateTable(db); err != nil {
+					return err
+				}
+
+				if _, err := db.Exec(fmt.Sprintf(`INSERT INTO %s.%s (username, password, role, active) 
+					SELECT CONCAT(?, id), "", role, FALSE FROM %s.%s WHERE role IN (?, ?)`,
+					admin.DBName, admin.TableName, DBName, TableName),
+					roleAccountPrefix, admin.RoleOperator, admin.RoleAdmin); err != nil {
+					return err
+				}
+
+				return migrate.Exec(fmt.Sprintf(`ALTER TABLE %s.%s DROP COLUMN role`, DBName, TableName))(db)
+			},
+			Down: func(db *sql.DB) error {
+				if err := migrate.AddColumn(db, DBName, TableName, "role", `VARCHAR(16) NOT NULL DEFAULT "customer" AFTER gender`); err != nil {
+					return err
+				}
+
+				_, err := db.Exec(fmt.Sprintf(`UPDATE %s.%s u JOIN %s.%s a ON a.username = CONCAT(?, u.id) 
+					SET u.role = a.role`, DBName, TableName, admin.DBName, admin.TableName), roleAccountPrefix)
+				return err
+			},
+		},
+		{
+			Version:     4,
+			Description: "add token revocation",
+			Up: func(db *sql.DB) error {
+				if err := migrate.AddColumn(db, DBName, TableName, "revoked_before",
+					`DATETIME(3) NULL COMMENT 'tokens issued until then are revoked' AFTER active`); err != nil {
+					return err
+				}
+
+				return migrate.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+					jti				VARCHAR(64) NOT NULL,
+					user_id			BIGINT UNSIGNED NOT NULL,
+					expire_at		DATETIME NOT NULL,
+					PRIMARY KEY (jti),
+					INDEX user_index (user_id, expire_at),
+					INDEX expire_index (expire_at)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, RevokedTokenTableName))(db)
+			},
+			Down: migrate.Exec(
+				fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, RevokedTokenTableName),
+				fmt.Sprintf(`ALTER TABLE %s.%s DROP COLUMN revoked_before`, DBName, TableName),
+			),
+		},
+		{
+			Version:     5,
+			Description: "create refresh token table",
+			Up: migrate.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+				token_hash		CHAR(64) NOT NULL,
+				family_id		CHAR(32) NOT NULL,
+				user_id			BIGINT UNSIGNED NOT NULL,
+				device_id		VARCHAR(128) NOT NULL,
+				expire_at		DATETIME NOT NULL,
+				used_at			DATETIME NULL COMMENT 'rotated, using it again revokes the family',
+				revoked			BOOLEAN NOT NULL DEFAULT FALSE,
+				created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (token_hash),
+				INDEX family_index (family_id),
+				INDEX user_index (user_id),
+				INDEX expire_index (expire_at)
+			) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin`, DBName, RefreshTokenTableName)),
+			Down: migrate.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s.%s`, DBName, RefreshTokenTableName)),
+		},
+		{
+			Version:     6,
+			Description: "add verified phone number",
+			Up: func(db *sql.DB) error {
+				for _, column := range []struct{ name, definition string }{
+					{"phone", `VARCHAR(32) NULL COMMENT 'verified by WeChat' AFTER gender`},
+					{"phone_bound_at", "DATETIME NULL AFTER phone"},
+				} {
+					if err := migrate.AddColumn(db, DBName, TableName, column.name, column.definition); err != nil {
+						return err
+					}
+				}
+
+				return migrate.AddIndex(db, DBName, TableName, "phone_index", "UNIQUE INDEX phone_index (phone)")
+			},
+			Down: migrate.Exec(
+				fmt.Sprintf(`ALTER TABLE %s.%s DROP INDEX phone_index`, DBName, TableName),
+				fmt.Sprintf(`ALTER TABLE %s.%s DROP COLUMN phone_bound_at, DROP COLUMN phone`, DBName, TableName),
+			),
+		},
+		{
+			Version:     7,
+			Description: "add user location and unionid",
+			Up: func(db *sql.DB) error {
+				for _, column := range []struct{ name, definition string }{
+					{"city", `VARCHAR(100) NOT NULL DEFAULT "" AFTER gender`},
+					{"province", `VARCHAR(100) NOT NULL DEFAULT "" AFTER city`},
+					{"country", `VARCHAR(100) NOT NULL DEFAULT "" AFTER province`},
+					{"unionid", "VARCHAR(100) NULL AFTER phone_bound_at"},
+				} {
+					if err := migrate.AddColumn(db, DBName, TableName, column.name, column.definition); err != nil {
+						return err
+					}
+				}
+
+				return migrate.AddIndex(db, DBName, TableName, "unionid_index", "INDEX unionid_index (unionid)")
+			},
+			Down: migrate.Exec(
+				fmt.Sprintf(`ALTER TABLE %s.%s DROP INDEX unionid_index`, DBName, TableName),
+				fmt.Sprintf(`ALTER TABLE %s.%s DROP COLUMN unionid, DROP COLUMN country, DROP COLUMN province, DROP COLUMN city`, DBName, TableName),
+			),
+		},
+	},
+}
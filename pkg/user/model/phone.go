@@ -0,0 +1,93 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	mysqlUserGetSessionKey = iota
+	mysqlUserGetPhoneForUpdate
+	mysqlUserGetPhoneOwner
+	mysqlUserBindPhone
+)
+
+// mysqlDuplicateEntry is the error number of a unique key violation.
+const mysqlDuplicateEntry = 1062
+
+var (
+	// ErrPhoneTaken returned when the phone number is bound to another user.
+	ErrPhoneTaken = errors.New("the phone number is bound to another user")
+
+	// ErrPhoneRebindTooSoon returned when the user changes its phone number
+	// again before the rebind interval passed.
+	ErrPhoneRebindTooSoon = errors.New("the phone number was changed recently")
+
+	phoneSQLString = []string{
+		fmt.Sprintf(`SELECT session_key FROM %s.%s WHERE id = ?`, DBName, TableName),
+		fmt.Sprintf(`SELECT phone, phone_bound_at FROM %s.%s WHERE id = ? FOR UPDATE`, DBName, TableName),
+		fmt.Sprintf(`SELECT id FROM %s.%s WHERE phone = ? FOR UPDATE`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET phone = ?, phone_bound_at = ? WHERE id = ? LIMIT 1`, DBName, TableName),
+	}
+)
+
+// GetSessionKey returns the session key of the last login of the user.
+func GetSessionKey(db *sql.DB, id uint32) (string, error) {
+	var sessionKey string
+
+	err := db.QueryRow(phoneSQLString[mysqlUserGetSessionKey], id).Scan(&sessionKey)
+	return sessionKey, err
+}
+
+// BindPhone binds the verified phone number to the user. A phone number
+// belongs to one user only, and a user bound to a phone number can change it
+// once per rebindInterval. Binding the number already bound is a no-op.
+func BindPhone(db *sql.DB, id uint32, phone string, rebindInterval time.Duration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		current sql.NullString
+		boundAt sql.NullTime
+	)
+	err = tx.QueryRow(phoneSQLString[mysqlUserGetPhoneForUpdate], id).Scan(&current, &boundAt)
+	if err != nil {
+		return err
+	}
+
+	if current.Valid && current.String == phone {
+		return nil
+	}
+
+	var owner uint32
+	err = tx.QueryRow(phoneSQLString[mysqlUserGetPhoneOwner], phone).Scan(&owner)
+	if err == nil {
+		return ErrPhoneTaken
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	now := time.Now()
+	if current.Valid && boundAt.Valid && now.Before(boundAt.Time.Add(rebindInterval)) {
+		return ErrPhoneRebindTooSoon
+	}
+
+	if _, err := tx.Exec(phoneSQLString[mysqlUserBindPhone], phone, now, id); err != nil {
+		// the unique index catches a concurrent bind of the same number.
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+			return ErrPhoneTaken
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,177 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const RefreshTokenTableName = "refresh_token"
+
+const (
+	mysqlRefreshCreateTable = iota
+	mysqlRefreshInsert
+	mysqlRefreshGetForUpdate
+	mysqlRefreshMarkUsed
+	mysqlRefreshRevokeFamily
+	mysqlRefreshRevokeUser
+	mysqlRefreshPurge
+)
+
+var (
+	// ErrInvalidRefreshToken returned for an unknown, expired or revoked
+	// refresh token.
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid")
+
+	// ErrRefreshTokenReused returned when a refresh token is used again after
+	// it was rotated, or from another device. Its whole family is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token is reused, its family is revoked")
+
+	refreshSQLString = []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+			token_hash		CHAR(64) NOT NULL,
+			family_id		CHAR(32) NOT NULL,
+			user_id			BIGINT UNSIGNED NOT NULL,
+			device_id		VARCHAR(128) NOT NULL,
+			expire_at		DATETIME NOT NULL,
+			used_at			DATETIME NULL COMMENT 'rotated, using it again revokes the family',
+			revoked			BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (token_hash),
+			INDEX family_index (family_id),
+			INDEX user_index (user_id),
+			INDEX expire_index (expire_at)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, RefreshTokenTableName),
+		fmt.Sprintf(`INSERT INTO %s.%s (token_hash, family_id, user_id, device_id, expire_at) VALUES (?,?,?,?,?)`, DBName, RefreshTokenTableName),
+		fmt.Sprintf(`SELECT family_id, user_id, device_id, expire_at, used_at IS NOT NULL, revoked FROM %s.%s WHERE token_hash = ? FOR UPDATE`, DBName, RefreshTokenTableName),
+		fmt.Sprintf(`UPDATE %s.%s SET used_at = ? WHERE token_hash = ? LIMIT 1`, DBName, RefreshTokenTableName),
+		fmt.Sprintf(`UPDATE %s.%s SET revoked = TRUE WHERE family_id = ?`, DBName, RefreshTokenTableName),
+		fmt.Sprintf(`UPDATE %s.%s SET revoked = TRUE WHERE user_id = ? AND revoked = FALSE`, DBName, RefreshTokenTableName),
+		fmt.Sprintf(`DELETE FROM %s.%s WHERE expire_at < ? LIMIT 1000`, DBName, RefreshTokenTableName),
+	}
+)
+
+// RefreshToken is a refresh token as stored: only the hash of the opaque
+// token the client holds is kept. Every token rotated from a login shares the
+// FamilyID of its first token.
+type RefreshToken struct {
+	Hash     string
+	FamilyID string
+	UserID   uint32
+	DeviceID string
+	ExpireAt time.Time
+}
+
+// NewRefreshToken returns a random opaque refresh token and its hash.
+func NewRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken returns the hash a refresh token is stored by.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewFamilyID returns the id of a new refresh token family.
+func NewFamilyID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// CreateRefreshTokenTable create refresh token table.
+func CreateRefreshTokenTable(db *sql.DB) error {
+	_, err := db.Exec(refreshSQLString[mysqlRefreshCreateTable])
+	return err
+}
+
+// CreateRefreshToken stores t. The rows of expired tokens are purged along the
+// way.
+func CreateRefreshToken(db *sql.DB, t *RefreshToken) error {
+	_, err := db.Exec(refreshSQLString[mysqlRefreshInsert], t.Hash, t.FamilyID, t.UserID, t.DeviceID, t.ExpireAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(refreshSQLString[mysqlRefreshPurge], time.Now())
+	return err
+}
+
+// RotateRefreshToken exchanges the refresh token of hash used from deviceID
+// for next, which joins its family. A token already rotated, or used from
+// another device, revokes its family and returns ErrRefreshTokenReused.
+func RotateRefreshToken(db *sql.DB, hash, deviceID string, next *RefreshToken) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		current       RefreshToken
+		used, revoked bool
+	)
+	err = tx.QueryRow(refreshSQLString[mysqlRefreshGetForUpdate], hash).Scan(
+		&current.FamilyID, &current.UserID, &current.DeviceID, &current.ExpireAt, &used, &revoked)
+	if err == sql.ErrNoRows {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+
+	if revoked || !current.ExpireAt.After(time.Now()) {
+		return ErrInvalidRefreshToken
+	}
+
+	if used || current.DeviceID != deviceID {
+		if _, err := tx.Exec(refreshSQLString[mysqlRefreshRevokeFamily], current.FamilyID); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		return ErrRefreshTokenReused
+	}
+
+	if _, err := tx.Exec(refreshSQLString[mysqlRefreshMarkUsed], time.Now(), hash); err != nil {
+		return err
+	}
+
+	next.FamilyID, next.UserID, next.DeviceID = current.FamilyID, current.UserID, current.DeviceID
+	_, err = tx.Exec(refreshSQLString[mysqlRefreshInsert], next.Hash, next.FamilyID, next.UserID, next.DeviceID, next.ExpireAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeRefreshFamily revokes every refresh token of the family.
+func RevokeRefreshFamily(db *sql.DB, familyID string) error {
+	_, err := db.Exec(refreshSQLString[mysqlRefreshRevokeFamily], familyID)
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of the user.
+func RevokeUserRefreshTokens(db *sql.DB, userID uint32) error {
+	_, err := db.Exec(refreshSQLString[mysqlRefreshRevokeUser], userID)
+	return err
+}
@@ -0,0 +1,213 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const RevokedTokenTableName = "revoked_token"
+
+const (
+	mysqlRevocationCreateTable = iota
+	mysqlRevocationInsert
+	mysqlRevocationPurge
+	mysqlRevocationRevokeUser
+	mysqlRevocationGetBefore
+	mysqlRevocationGetTokens
+)
+
+var revocationSQLString = []string{
+	fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+		jti				VARCHAR(64) NOT NULL,
+		user_id			BIGINT UNSIGNED NOT NULL,
+		expire_at		DATETIME NOT NULL,
+		PRIMARY KEY (jti),
+		INDEX user_index (user_id, expire_at),
+		INDEX expire_index (expire_at)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, RevokedTokenTableName),
+	fmt.Sprintf(`INSERT IGNORE INTO %s.%s (jti, user_id, expire_at) VALUES (?,?,?)`, DBName, RevokedTokenTableName),
+	fmt.Sprintf(`DELETE FROM %s.%s WHERE expire_at < ? LIMIT 1000`, DBName, RevokedTokenTableName),
+	fmt.Sprintf(`UPDATE %s.%s SET revoked_before = ? WHERE id = ? LIMIT 1`, DBName, TableName),
+	fmt.Sprintf(`SELECT revoked_before FROM %s.%s WHERE id = ?`, DBName, TableName),
+	fmt.Sprintf(`SELECT jti FROM %s.%s WHERE user_id = ? AND expire_at >= ?`, DBName, RevokedTokenTableName),
+}
+
+// Revocations are the revoked tokens of a user: every token issued at or
+// before Before, and the tokens of IDs until they expire.
+type Revocations struct {
+	Before time.Time
+	IDs    map[string]bool
+}
+
+// Revoked reports whether the token jti issued at issuedAt is revoked. A token
+// issued before tokens had an iat and a jti has a zero issuedAt, it predates
+// every revocation so it is only revoked once the user is.
+func (r *Revocations) Revoked(jti string, issuedAt time.Time) bool {
+	if issuedAt.IsZero() {
+		return !r.Before.IsZero()
+	}
+	return !issuedAt.After(r.Before) || (jti != "" && r.IDs[jti])
+}
+
+// CreateRevokedTokenTable create revoked token table.
+func CreateRevokedTokenTable(db *sql.DB) error {
+	_, err := db.Exec(revocationSQLString[mysqlRevocationCreateTable])
+	return err
+}
+
+// RevokeToken revokes the token jti of the user until it expires. The rows of
+// expired tokens are purged along the way.
+func RevokeToken(db *sql.DB, userID uint32, jti string, expireAt time.Time) error {
+	if _, err := db.Exec(revocationSQLString[mysqlRevocationInsert], jti, userID, expireAt); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(revocationSQLString[mysqlRevocationPurge], time.Now())
+	return err
+}
+
+// RevokeUser revokes every token of the user issued at or before before. No
+// row is affected when revoked_before already holds before, e.g. two logouts
+// in the same millisecond, which is not an error.
+func RevokeUser(db *sql.DB, userID uint32, before time.Time) error {
+	_, err := db.Exec(revocationSQLString[mysqlRevocationRevokeUser], before, userID)
+	return err
+}
+
+// GetRevocations returns the revocations of the user.
+func GetRevocations(db *sql.DB, userID uint32) (*Revocations, error) {
+	var before sql.NullTime
+	if err := db.QueryRow(revocationSQLString[mysqlRevocationGetBefore], userID).Scan(&before); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(revocationSQLString[mysqlRevocationGetTokens], userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := &Revocations{Before: before.Time, IDs: make(map[string]bool)}
+	for rows.Next() {
+		var jti string
+		if err := rows.Scan(&jti); err != nil {
+			return nil, err
+		}
+		r.IDs[jti] = true
+	}
+
+	return r, rows.Err()
+}
+
+// RevocationCache keeps the revocations of users in process memory for ttl,
+// so checking a token does not hit the store on every request. Revocations
+// made through the cache apply at once, the ones made by other instances
+// within ttl.
+type RevocationCache struct {
+	store Store
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[uint32]*revocationEntry
+	// revision counts the revocations made through the cache, a load that
+	// raced with one is not kept.
+	revision uint64
+}
+
+type revocationEntry struct {
+	revocations *Revocations
+	loadedAt    time.Time
+}
+
+// maxRevocationEntries bounds the cache, stale entries are dropped past it.
+const maxRevocationEntries = 10000
+
+// NewRevocationCache returns a cache of the revocations of store.
+func NewRevocationCache(store Store, ttl time.Duration) *RevocationCache {
+	return &RevocationCache{
+		store:   store,
+		ttl:     ttl,
+		entries: make(map[uint32]*revocationEntry),
+	}
+}
+
+// IsRevoked reports whether the token jti of the user issued at issuedAt is
+// revoked.
+func (c *RevocationCache) IsRevoked(userID uint32, jti string, issuedAt time.Time) (bool, error) {
+	r, err := c.get(userID)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return r.Revoked(jti, issuedAt), nil
+}
+
+// RevokeToken revokes the token jti of the user until expireAt.
+func (c *RevocationCache) RevokeToken(userID uint32, jti string, expireAt time.Time) error {
+	if err := c.store.RevokeToken(userID, jti, expireAt); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revision++
+	if e, ok := c.entries[userID]; ok {
+		e.revocations.IDs[jti] = true
+	}
+
+	return nil
+}
+
+// RevokeUser revokes every token of the user issued at or before before.
+func (c *RevocationCache) RevokeUser(userID uint32, before time.Time) error {
+	if err := c.store.RevokeUser(userID, before); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revision++
+	if e, ok := c.entries[userID]; ok && before.After(e.revocations.Before) {
+		e.revocations.Before = before
+	}
+
+	return nil
+}
+
+func (c *RevocationCache) get(userID uint32) (*Revocations, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	if e, ok := c.entries[userID]; ok && now.Sub(e.loadedAt) < c.ttl {
+		c.mu.Unlock()
+		return e.revocations, nil
+	}
+	revision := c.revision
+	c.mu.Unlock()
+
+	r, err := c.store.Revocations(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if revision != c.revision {
+		return r, nil
+	}
+
+	if len(c.entries) >= maxRevocationEntries {
+		for id, e := range c.entries {
+			if now.Sub(e.loadedAt) >= c.ttl {
+				delete(c.entries, id)
+			}
+		}
+	}
+	c.entries[userID] = &revocationEntry{revocations: r, loadedAt: now}
+
+	return r, nil
+}
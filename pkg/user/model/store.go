@@ -0,0 +1,114 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Store is the persistence of users used by the controller.
+type Store interface {
+	CreateUser(openid, sessionKey string) (uint32, error)
+	IsExist(openid string) (uint32, error)
+	UpdateSessionKey(id uint32, sessionKey string) error
+	ModifyUserActive(id uint32, active bool) error
+	IsActive(id uint32) (bool, error)
+	GetOpenID(id uint32) (string, error)
+	UpdateUnionID(id uint32, unionID string) error
+	ModifyUserInfo(id uint32, info *UserInfo) error
+	ModifyUserProfile(id uint32, info *UserInfo) error
+	GetUserInfo(id uint32) (*UserInfo, error)
+	GetSessionKey(id uint32) (string, error)
+	BindPhone(id uint32, phone string, rebindInterval time.Duration) error
+	RevokeToken(userID uint32, jti string, expireAt time.Time) error
+	RevokeUser(userID uint32, before time.Time) error
+	Revocations(userID uint32) (*Revocations, error)
+	CreateRefreshToken(t *RefreshToken) error
+	RotateRefreshToken(hash, deviceID string, next *RefreshToken) error
+	RevokeRefreshFamily(familyID string) error
+	RevokeUserRefreshTokens(userID uint32) error
+}
+
+type mysqlStore struct {
+	db *sql.DB
+}
+
+// NewMySQLStore returns the Store backed by the user schema.
+func NewMySQLStore(db *sql.DB) Store {
+	return &mysqlStore{db: db}
+}
+
+func (s *mysqlStore) CreateUser(openid, sessionKey string) (uint32, error) {
+	return CreateUser(s.db, openid, sessionKey)
+}
+
+func (s *mysqlStore) IsExist(openid string) (uint32, error) {
+	return IsExist(s.db, openid)
+}
+
+func (s *mysqlStore) UpdateSessionKey(id uint32, sessionKey string) error {
+	return UpdateSessionKey(s.db, id, sessionKey)
+}
+
+func (s *mysqlStore) ModifyUserActive(id uint32, active bool) error {
+	return ModifyUserActive(s.db, id, active)
+}
+
+func (s *mysqlStore) IsActive(id uint32) (bool, error) {
+	return IsActive(s.db, id)
+}
+
+func (s *mysqlStore) GetOpenID(id uint32) (string, error) {
+	return GetOpenID(s.db, id)
+}
+
+func (s *mysqlStore) UpdateUnionID(id uint32, unionID string) error {
+	return UpdateUnionID(s.db, id, unionID)
+}
+
+func (s *mysqlStore) ModifyUserInfo(id uint32, info *UserInfo) error {
+	return ModifyUserInfo(s.db, id, info)
+}
+
+func (s *mysqlStore) ModifyUserProfile(id uint32, info *UserInfo) error {
+	return ModifyUserProfile(s.db, id, info)
+}
+
+func (s *mysqlStore) GetUserInfo(id uint32) (*UserInfo, error) {
+	return GetUserInfo(s.db, id)
+}
+
+func (s *mysqlStore) GetSessionKey(id uint32) (string, error) {
+	return GetSessionKey(s.db, id)
+}
+
+func (s *mysqlStore) BindPhone(id uint32, phone string, rebindInterval time.Duration) error {
+	return BindPhone(s.db, id, phone, rebindInterval)
+}
+
+func (s *mysqlStore) RevokeToken(userID uint32, jti string, expireAt time.Time) error {
+	return RevokeToken(s.db, userID, jti, expireAt)
+}
+
+func (s *mysqlStore) RevokeUser(userID uint32, before time.Time) error {
+	return RevokeUser(s.db, userID, before)
+}
+
+func (s *mysqlStore) Revocations(userID uint32) (*Revocations, error) {
+	return GetRevocations(s.db, userID)
+}
+
+func (s *mysqlStore) CreateRefreshToken(t *RefreshToken) error {
+	return CreateRefreshToken(s.db, t)
+}
+
+func (s *mysqlStore) RotateRefreshToken(hash, deviceID string, next *RefreshToken) error {
+	return RotateRefreshToken(s.db, hash, deviceID, next)
+}
+
+func (s *mysqlStore) RevokeRefreshFamily(familyID string) error {
+	return RevokeRefreshFamily(s.db, familyID)
+}
+
+func (s *mysqlStore) RevokeUserRefreshTokens(userID uint32) error {
+	return RevokeUserRefreshTokens(s.db, userID)
+}
@@ -21,6 +21,9 @@ const (
 	mysqlUserGetInfo
 	mysqlUserModifyActive
 	mysqlUserGetIsActive
+	mysqlUserGetOpenID
+	mysqlUserUpdateUnionID
+	mysqlUserModifyProfile
 )
 
 var (
@@ -35,17 +38,29 @@ var (
 			nick_name 		VARCHAR(100) NOT NULL DEFAULT " ",
 			avatar			VARCHAR(512) NOT NULL DEFAULT " ",
 			gender			TINYINT NOT NULL DEFAULT 0 COMMENT '0 unknown 1 man 2 woman',
+			city			VARCHAR(100) NOT NULL DEFAULT "",
+			province		VARCHAR(100) NOT NULL DEFAULT "",
+			country			VARCHAR(100) NOT NULL DEFAULT "",
+			phone			VARCHAR(32) NULL COMMENT 'verified by WeChat',
+			unionid			VARCHAR(100) NULL,
+			phone_bound_at	DATETIME NULL,
 			active   		BOOLEAN DEFAULT TRUE,
+			revoked_before	DATETIME(3) NULL COMMENT 'tokens issued until then are revoked',
 			created_at  	DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (id)
+			PRIMARY KEY (id),
+			UNIQUE INDEX phone_index (phone),
+			INDEX unionid_index (unionid)
 		) ENGINE=InnoDB AUTO_INCREMENT=1000 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, TableName),
 		fmt.Sprintf(`INSERT INTO %s.%s (openid, session_key)  VALUES (?,?)`, DBName, TableName),
 		fmt.Sprintf(`SELECT id FROM %s.%s WHERE openid = ? LOCK IN SHARE MODE`, DBName, TableName),
 		fmt.Sprintf(`UPDATE %s.%s SET session_key = ? WHERE id = ? LIMIT 1`, DBName, TableName),
 		fmt.Sprintf(`UPDATE %s.%s SET nick_name = ?, avatar = ?, gender = ? WHERE id = ? LIMIT 1`, DBName, TableName),
-		fmt.Sprintf(`SELECT nick_name, avatar, gender FROM %s.%s WHERE id = ? LOCK IN SHARE MODE`, DBName, TableName),
+		fmt.Sprintf(`SELECT nick_name, avatar, gender, city, province, country, COALESCE(phone, "") FROM %s.%s WHERE id = ? LOCK IN SHARE MODE`, DBName, TableName),
 		fmt.Sprintf(`UPDATE %s.%s SET active = ? WHERE id = ? LIMIT 1`, DBName, TableName),
 		fmt.Sprintf(`SELECT active FROM %s.%s WHERE id = ? LOCK IN SHARE MODE`, DBName, TableName),
+		fmt.Sprintf(`SELECT openid FROM %s.%s WHERE id = ?`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET unionid = ? WHERE id = ? LIMIT 1`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET nick_name = ?, avatar = ?, gender = ?, city = ?, province = ?, country = ? WHERE id = ? LIMIT 1`, DBName, TableName),
 	}
 )
 
@@ -69,7 +84,7 @@ func CreateTable(db *sql.DB) error {
 	return nil
 }
 
-//CreateUser create a user
+// CreateUser create a user
 func CreateUser(db *sql.DB, openid, sessionKey string) (uint32, error) {
 	result, err := db.Exec(userSQLString[mysqlUserInsert], openid, sessionKey)
 	if err != nil {
@@ -108,6 +123,17 @@ func UpdateSessionKey(db *sql.DB, id uint32, sessionKey string) error {
 	return nil
 }
 
+// UpdateUnionID update the WeChat unionid of the user, it is the same for all
+// the apps of the open platform account.
+func UpdateUnionID(db *sql.DB, id uint32, unionID string) error {
+	_, err := db.Exec(userSQLString[mysqlUserUpdateUnionID], unionID, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // ModifyUserActive the user updates active
 func ModifyUserActive(db *sql.DB, id uint32, active bool) error {
 	result, err := db.Exec(userSQLString[mysqlUserModifyActive], active, id)
@@ -130,41 +156,53 @@ func IsActive(db *sql.DB, id uint32) (bool, error) {
 	return isActive, err
 }
 
-// ModifyUserInfo the user updates info
-func ModifyUserInfo(db *sql.DB, id uint32, nickName string, avatar string, gender int) error {
-	result, err := db.Exec(userSQLString[mysqlUserModifyInfo], nickName, avatar, gender, id)
+// GetOpenID returns the WeChat openid of the user.
+func GetOpenID(db *sql.DB, id uint32) (string, error) {
+	var openID string
+
+	err := db.QueryRow(userSQLString[mysqlUserGetOpenID], id).Scan(&openID)
+	return openID, err
+}
+
+// ModifyUserInfo the user updates nick name, avatar and gender. The location
+// is stored only by ModifyUserProfile and the phone number is bound apart.
+func ModifyUserInfo(db *sql.DB, id uint32, info *UserInfo) error {
+	_, err := db.Exec(userSQLString[mysqlUserModifyInfo], info.NickName, info.Avatar, info.Gender, id)
 	if err != nil {
 		return err
 	}
 
-	if rows, _ := result.RowsAffected(); rows == 0 {
-		return errInvalidMysql
+	return nil
+}
+
+// ModifyUserProfile stores the profile verified by WeChat, with the location.
+func ModifyUserProfile(db *sql.DB, id uint32, info *UserInfo) error {
+	_, err := db.Exec(userSQLString[mysqlUserModifyProfile],
+		info.NickName, info.Avatar, info.Gender, info.City, info.Province, info.Country, id)
+	if err != nil {
+		return err
 	}
 
 	return nil
-
 }
 
 type UserInfo struct {
 	NickName string
 	Avatar   string
 	Gender   int
+	City     string
+	Province string
+	Country  string
+	Phone    string
 }
 
 func GetUserInfo(db *sql.DB, id uint32) (*UserInfo, error) {
-	var (
-		nickName string
-		avatar   string
-		gender   int
-	)
-	err := db.QueryRow(userSQLString[mysqlUserGetInfo], id).Scan(&nickName, &avatar, &gender)
+	var info UserInfo
+	err := db.QueryRow(userSQLString[mysqlUserGetInfo], id).Scan(
+		&info.NickName, &info.Avatar, &info.Gender, &info.City, &info.Province, &info.Country, &info.Phone)
 	if err != nil {
 		return nil, err
 	}
 
-	return &UserInfo{
-		NickName: nickName,
-		Avatar:   avatar,
-		Gender:   gender,
-	}, nil
+	return &info, nil
 }
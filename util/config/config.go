@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/cast"
 	"github.com/spf13/viper"
@@ -66,3 +67,7 @@ func GetUint(path string, defaultValue ...interface{}) uint {
 func GetBool(path string, defaultValue ...interface{}) bool {
 	return cast.ToBool(Get(path, defaultValue...))
 }
+
+func GetDuration(path string, defaultValue ...interface{}) time.Duration {
+	return cast.ToDuration(Get(path, defaultValue...))
+}
@@ -0,0 +1,410 @@
+// Package jwt authenticates requests with JSON web tokens signed by the keys of
+// a KeySet. It follows the handlers of gin-jwt, and adds key ids so the keys of
+// a realm can be rotated without logging everyone out.
+package jwt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	gojwt "github.com/golang-jwt/jwt/v4"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MapClaims are the claims of a token.
+type MapClaims map[string]interface{}
+
+// ID returns the jti of the token, empty for tokens issued before tokens had
+// ids.
+func (c MapClaims) ID() string {
+	id, _ := c["jti"].(string)
+	return id
+}
+
+// IssuedAt returns the iat of the token, it keeps milliseconds so a token
+// issued right after a revocation is told apart from the revoked ones. It is
+// the zero time for tokens issued before tokens had an iat.
+func (c MapClaims) IssuedAt() time.Time {
+	iat, ok := c["iat"].(float64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(iat*1000)*int64(time.Millisecond))
+}
+
+// ExpiresAt returns the exp of the token.
+func (c MapClaims) ExpiresAt() time.Time {
+	exp, _ := c["exp"].(float64)
+	return time.Unix(int64(exp), 0)
+}
+
+const payloadKey = "JWT_PAYLOAD"
+
+var (
+	// ErrMissingAuthenticatorFunc returned by LoginHandler when the
+	// Authenticator is not set.
+	ErrMissingAuthenticatorFunc = errors.New("Middleware.Authenticator func is undefined")
+
+	// ErrMissingKeys returned when the KeySet is not set.
+	ErrMissingKeys = errors.New("Middleware.Keys is undefined")
+
+	// ErrFailedAuthentication returned by an Authenticator for wrong credentials.
+	ErrFailedAuthentication = errors.New("incorrect Username or Password")
+
+	// ErrFailedTokenCreation returned when signing a token failed.
+	ErrFailedTokenCreation = errors.New("failed to create JWT Token")
+
+	// ErrExpiredToken returned when a token is expired, or too old to refresh.
+	ErrExpiredToken = errors.New("token is expired")
+
+	// ErrEmptyToken returned when the request carries no token.
+	ErrEmptyToken = errors.New("token is empty")
+
+	// ErrInvalidAuthHeader returned when the header is not "<TokenHeadName> <token>".
+	ErrInvalidAuthHeader = errors.New("auth header is invalid")
+
+	// ErrForbidden returned when the Authorizator refuses the identity.
+	ErrForbidden = errors.New("you don't have permission to access this resource")
+
+	// ErrRevokedToken returned when IsRevoked reports the token revoked.
+	ErrRevokedToken = errors.New("token is revoked")
+)
+
+// Middleware issues and checks the tokens of a realm.
+type Middleware struct {
+	// Realm name to display to the user.
+	Realm string
+
+	// Keys signing and verifying the tokens.
+	Keys *KeySet
+
+	// Timeout is how long a token is valid.
+	Timeout time.Duration
+
+	// MaxRefresh is how long after it was first issued a token can be
+	// refreshed. Zero disables refreshing.
+	MaxRefresh time.Duration
+
+	// IdentityKey is the key of the identity in the gin context.
+	IdentityKey string
+
+	// Authenticator checks the credentials of the login request and returns
+	// the data PayloadFunc turns into claims. Only LoginHandler needs it.
+	Authenticator func(ctx *gin.Context) (interface{}, error)
+
+	// Authorizator checks the identity of an authenticated request. Optional,
+	// everyone is authorized by default.
+	Authorizator func(data interface{}, ctx *gin.Context) bool
+
+	// PayloadFunc returns the claims of the token issued for the data of the
+	// Authenticator.
+	PayloadFunc func(data interface{}) MapClaims
+
+	// IsRevoked reports whether a token was revoked before it expired. It is
+	// asked for every request and refresh, so it should not hit the database
+	// every time. Optional, no token is revoked by default.
+	IsRevoked func(claims MapClaims) (bool, error)
+
+	// IdentityHandler returns the identity from the claims of the request.
+	// Optional, defaults to the IdentityKey claim.
+	IdentityHandler func(ctx *gin.Context) interface{}
+
+	// Unauthorized writes the response of a refused request.
+	Unauthorized func(ctx *gin.Context, code int, message string)
+
+	// TokenLookup is a comma separated list of "<source>:<name>" where the
+	// token is looked for, source is one of header, query or cookie.
+	TokenLookup string
+
+	// TokenHeadName is the scheme in front of the token in a header.
+	TokenHeadName string
+
+	// TimeFunc provides the current time.
+	TimeFunc func() time.Time
+}
+
+// New checks m and fills the optional fields with defaults.
+func New(m *Middleware) (*Middleware, error) {
+	if m.Keys == nil {
+		return nil, ErrMissingKeys
+	}
+
+	if m.Timeout == 0 {
+		m.Timeout = time.Hour
+	}
+
+	if m.IdentityKey == "" {
+		m.IdentityKey = "identity"
+	}
+
+	if m.Authorizator == nil {
+		m.Authorizator = func(data interface{}, ctx *gin.Context) bool {
+			return true
+		}
+	}
+
+	if m.IdentityHandler == nil {
+		m.IdentityHandler = func(ctx *gin.Context) interface{} {
+			return ExtractClaims(ctx)[m.IdentityKey]
+		}
+	}
+
+	if m.IsRevoked == nil {
+		m.IsRevoked = func(claims MapClaims) (bool, error) {
+			return false, nil
+		}
+	}
+
+	if m.Unauthorized == nil {
+		m.Unauthorized = func(ctx *gin.Context, code int, message string) {
+			ctx.JSON(code, gin.H{"code": code, "message": message})
+		}
+	}
+
+	if m.TokenLookup == "" {
+		m.TokenLookup = "header:Authorization"
+	}
+
+	if m.TokenHeadName == "" {
+		m.TokenHeadName = "Bearer"
+	}
+
+	if m.TimeFunc == nil {
+		m.TimeFunc = time.Now
+	}
+
+	return m, nil
+}
+
+// MiddlewareFunc refuses requests without a valid token, and sets the claims
+// and the identity of the token in the gin context.
+func (m *Middleware) MiddlewareFunc() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims, err := m.parse(ctx, false)
+		if err != nil {
+			m.Reject(ctx, http.StatusUnauthorized, err)
+			return
+		}
+
+		if !m.checkRevoked(ctx, claims) {
+			return
+		}
+
+		ctx.Set(payloadKey, claims)
+		identity := m.IdentityHandler(ctx)
+		if identity != nil {
+			ctx.Set(m.IdentityKey, identity)
+		}
+
+		if !m.Authorizator(identity, ctx) {
+			m.Reject(ctx, http.StatusForbidden, ErrForbidden)
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// LoginHandler issues a token for the credentials checked by the
+// Authenticator. Reply will be of the form {"token": "TOKEN"}.
+func (m *Middleware) LoginHandler(ctx *gin.Context) {
+	if m.Authenticator == nil {
+		m.Reject(ctx, http.StatusInternalServerError, ErrMissingAuthenticatorFunc)
+		return
+	}
+
+	data, err := m.Authenticator(ctx)
+	if err != nil {
+		m.Reject(ctx, http.StatusUnauthorized, err)
+		return
+	}
+
+	claims := MapClaims{}
+	if m.PayloadFunc != nil {
+		for k, v := range m.PayloadFunc(data) {
+			claims[k] = v
+		}
+	}
+	claims["orig_iat"] = m.TimeFunc().Unix()
+
+	m.respondToken(ctx, claims)
+}
+
+// RefreshHandler issues a new token for a token, expired or not, first issued
+// less than MaxRefresh ago. Reply will be of the form {"token": "TOKEN"}.
+func (m *Middleware) RefreshHandler(ctx *gin.Context) {
+	claims, err := m.parse(ctx, true)
+	if err != nil {
+		m.Reject(ctx, http.StatusUnauthorized, err)
+		return
+	}
+
+	if !m.checkRevoked(ctx, claims) {
+		return
+	}
+
+	origIat, ok := claims["orig_iat"].(float64)
+	if !ok || int64(origIat) < m.TimeFunc().Add(-m.MaxRefresh).Unix() {
+		m.Reject(ctx, http.StatusUnauthorized, ErrExpiredToken)
+		return
+	}
+
+	m.respondToken(ctx, claims)
+}
+
+// ExtractClaims returns the claims of the token of the request.
+func ExtractClaims(ctx *gin.Context) MapClaims {
+	claims, ok := ctx.Get(payloadKey)
+	if !ok {
+		return MapClaims{}
+	}
+
+	return claims.(MapClaims)
+}
+
+// checkRevoked aborts the request if the token is revoked.
+func (m *Middleware) checkRevoked(ctx *gin.Context, claims MapClaims) bool {
+	revoked, err := m.IsRevoked(claims)
+	if err != nil {
+		ctx.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway})
+		return false
+	}
+
+	if revoked {
+		m.Reject(ctx, http.StatusUnauthorized, ErrRevokedToken)
+		return false
+	}
+
+	return true
+}
+
+// TokenGenerator signs claims as a new token valid for Timeout, for handlers
+// issuing tokens on their own. Every token gets its own jti so it can be
+// revoked alone.
+func (m *Middleware) TokenGenerator(claims MapClaims) (string, time.Time, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := m.TimeFunc()
+	expire := now.Add(m.Timeout)
+	signed := MapClaims{}
+	for k, v := range claims {
+		signed[k] = v
+	}
+	signed["jti"] = id
+	signed["iat"] = float64(now.UnixNano()/int64(time.Millisecond)) / 1000
+	signed["exp"] = expire.Unix()
+
+	token, err := m.Keys.sign(signed)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expire, nil
+}
+
+func (m *Middleware) respondToken(ctx *gin.Context, claims MapClaims) {
+	token, expire, err := m.TokenGenerator(claims)
+	if err != nil {
+		ctx.Error(err)
+		m.Reject(ctx, http.StatusUnauthorized, ErrFailedTokenCreation)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"code":   http.StatusOK,
+		"token":  token,
+		"expire": expire.Format(time.RFC3339),
+	})
+}
+
+// parse returns the claims of the token of the request. An expired token is
+// only accepted if allowExpired.
+func (m *Middleware) parse(ctx *gin.Context, allowExpired bool) (MapClaims, error) {
+	raw, err := m.lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := gojwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(raw, m.Keys.keyFunc)
+	if err != nil {
+		var validation *gojwt.ValidationError
+		if errors.As(err, &validation) && validation.Inner != nil {
+			return nil, validation.Inner
+		}
+		return nil, err
+	}
+
+	claims := MapClaims(token.Claims.(gojwt.MapClaims))
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, ErrExpiredToken
+	}
+
+	if !allowExpired && int64(exp) < m.TimeFunc().Unix() {
+		return nil, ErrExpiredToken
+	}
+
+	return claims, nil
+}
+
+func (m *Middleware) lookup(ctx *gin.Context) (string, error) {
+	err := ErrEmptyToken
+	for _, method := range strings.Split(m.TokenLookup, ",") {
+		parts := strings.SplitN(strings.TrimSpace(method), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		var token string
+		switch source, name := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]); source {
+		case "header":
+			header := ctx.GetHeader(name)
+			if header == "" {
+				continue
+			}
+
+			fields := strings.SplitN(header, " ", 2)
+			if len(fields) != 2 || fields[0] != m.TokenHeadName {
+				err = ErrInvalidAuthHeader
+				continue
+			}
+			token = fields[1]
+		case "query":
+			token = ctx.Query(name)
+		case "cookie":
+			token, _ = ctx.Cookie(name)
+		}
+
+		if token != "" {
+			return token, nil
+		}
+	}
+
+	return "", err
+}
+
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// Reject aborts the request with the Unauthorized response of the realm.
+func (m *Middleware) Reject(ctx *gin.Context, code int, err error) {
+	ctx.Header("WWW-Authenticate", "JWT realm="+m.Realm)
+	ctx.Abort()
+	m.Unauthorized(ctx, code, err.Error())
+}
@@ -0,0 +1,247 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dovics/wx-demo/util/config"
+	"github.com/gin-gonic/gin"
+)
+
+func newTestMiddleware(t *testing.T, realm config.StrMap) *Middleware {
+	t.Helper()
+
+	config.Add("jwt_test", realm)
+	keys, err := LoadKeySet("jwt_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(&Middleware{
+		Realm:       "test",
+		Keys:        keys,
+		Timeout:     time.Hour,
+		MaxRefresh:  2 * time.Hour,
+		IdentityKey: "id",
+		Authenticator: func(ctx *gin.Context) (interface{}, error) {
+			return 1000, nil
+		},
+		PayloadFunc: func(data interface{}) MapClaims {
+			return MapClaims{"id": data}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func newTestRouter(m *Middleware) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.POST("/login", m.LoginHandler)
+	r.POST("/refresh_token", m.RefreshHandler)
+	r.GET("/id", m.MiddlewareFunc(), func(ctx *gin.Context) {
+		id, _ := ctx.Get("id")
+		ctx.JSON(http.StatusOK, gin.H{"id": id})
+	})
+
+	return r
+}
+
+func do(t *testing.T, r http.Handler, method, path, token string) (int, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var resp map[string]interface{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func login(t *testing.T, r http.Handler) string {
+	t.Helper()
+
+	code, resp := do(t, r, http.MethodPost, "/login", "")
+	token, _ := resp["token"].(string)
+	if code != http.StatusOK || token == "" {
+		t.Fatalf("login status = %d, resp %v", code, resp)
+	}
+
+	return token
+}
+
+func writePEM(t *testing.T, typ string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestRotateHMAC(t *testing.T) {
+	old := newTestRouter(newTestMiddleware(t, config.StrMap{
+		"algorithm": "HS256",
+		"kid":       "2021",
+		"key":       "old secret",
+	}))
+	oldToken := login(t, old)
+
+	rotated := newTestRouter(newTestMiddleware(t, config.StrMap{
+		"algorithm":   "HS256",
+		"kid":         "2022",
+		"key":         "new secret",
+		"verify_keys": "2021:old secret",
+	}))
+
+	code, resp := do(t, rotated, http.MethodGet, "/id", oldToken)
+	if code != http.StatusOK || resp["id"] != 1000.0 {
+		t.Fatalf("token of the retired key status = %d, resp %v", code, resp)
+	}
+
+	code, resp = do(t, rotated, http.MethodPost, "/refresh_token", oldToken)
+	if code != http.StatusOK {
+		t.Fatalf("refresh status = %d, resp %v", code, resp)
+	}
+
+	// the refreshed token is signed by the new key only.
+	if code, _ := do(t, old, http.MethodGet, "/id", resp["token"].(string)); code != http.StatusUnauthorized {
+		t.Errorf("new token on the old key status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	dropped := newTestRouter(newTestMiddleware(t, config.StrMap{
+		"algorithm": "HS256",
+		"kid":       "2022",
+		"key":       "new secret",
+	}))
+	if code, _ := do(t, dropped, http.MethodGet, "/id", oldToken); code != http.StatusUnauthorized {
+		t.Errorf("token of a dropped key status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestPublicKeyAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for alg, path := range map[string]string{
+		"RS256": writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+		"ES256": writePEM(t, "EC PRIVATE KEY", ecDER),
+	} {
+		r := newTestRouter(newTestMiddleware(t, config.StrMap{"algorithm": alg, "kid": "1", "key": path}))
+		if code, resp := do(t, r, http.MethodGet, "/id", login(t, r)); code != http.StatusOK || resp["id"] != 1000.0 {
+			t.Errorf("%s status = %d, resp %v", alg, code, resp)
+		}
+	}
+}
+
+func TestRotateAlgorithm(t *testing.T) {
+	old := newTestRouter(newTestMiddleware(t, config.StrMap{
+		"algorithm": "HS256",
+		"kid":       "2021",
+		"key":       "old:secret",
+	}))
+	oldToken := login(t, old)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	rotated := newTestRouter(newTestMiddleware(t, config.StrMap{
+		"algorithm":   "RS256",
+		"kid":         "2022",
+		"key":         path,
+		"verify_keys": "2021:HS256:old:secret",
+	}))
+	if code, resp := do(t, rotated, http.MethodGet, "/id", oldToken); code != http.StatusOK || resp["id"] != 1000.0 {
+		t.Errorf("HS256 token on an RS256 realm status = %d, resp %v", code, resp)
+	}
+	if code, _ := do(t, rotated, http.MethodGet, "/id", login(t, rotated)); code != http.StatusOK {
+		t.Errorf("RS256 token status = %d, want %d", code, http.StatusOK)
+	}
+
+	// without alg the entry is kid:key with the algorithm of the realm, the
+	// colon belongs to the secret.
+	legacy := newTestRouter(newTestMiddleware(t, config.StrMap{
+		"algorithm":   "HS256",
+		"kid":         "2022",
+		"key":         "new secret",
+		"verify_keys": "2021:old:secret",
+	}))
+	if code, _ := do(t, legacy, http.MethodGet, "/id", oldToken); code != http.StatusOK {
+		t.Errorf("kid:key with a colon status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestRejectToken(t *testing.T) {
+	m := newTestMiddleware(t, config.StrMap{"algorithm": "HS256", "kid": "1", "key": "secret"})
+	r := newTestRouter(m)
+	token := login(t, r)
+
+	if code, _ := do(t, r, http.MethodGet, "/id", ""); code != http.StatusUnauthorized {
+		t.Errorf("without token status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	if code, _ := do(t, r, http.MethodGet, "/id", token+"x"); code != http.StatusUnauthorized {
+		t.Errorf("tampered token status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	kid1, err := m.Keys.sign(MapClaims{"id": 1000, "exp": time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Keys.signing.id = "2"
+	if code, _ := do(t, r, http.MethodGet, "/id", kid1); code != http.StatusOK {
+		t.Errorf("token of kid 1 status = %d, want %d", code, http.StatusOK)
+	}
+	if code, _ := do(t, r, http.MethodGet, "/id", login(t, r)); code != http.StatusUnauthorized {
+		t.Errorf("token of unknown kid status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	m.TimeFunc = func() time.Time { return time.Now().Add(90 * time.Minute) }
+	if code, _ := do(t, r, http.MethodGet, "/id", token); code != http.StatusUnauthorized {
+		t.Errorf("expired token status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := do(t, r, http.MethodPost, "/refresh_token", token); code != http.StatusOK {
+		t.Errorf("refresh of expired token status = %d, want %d", code, http.StatusOK)
+	}
+
+	m.TimeFunc = func() time.Time { return time.Now().Add(3 * time.Hour) }
+	if code, _ := do(t, r, http.MethodPost, "/refresh_token", token); code != http.StatusUnauthorized {
+		t.Errorf("refresh after max refresh status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,222 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	gojwt "github.com/golang-jwt/jwt/v4"
+
+	"github.com/dovics/wx-demo/util/config"
+)
+
+var (
+	// ErrMissingSecretKey returned when the signing key of a realm is empty.
+	ErrMissingSecretKey = errors.New("secret key is required")
+
+	// ErrInvalidSigningAlgorithm returned for an unknown algorithm, or a
+	// token signed with another algorithm than its key.
+	ErrInvalidSigningAlgorithm = errors.New("invalid signing algorithm")
+
+	// ErrUnknownKey returned when the kid of a token is not a known key.
+	ErrUnknownKey = errors.New("unknown signing key")
+)
+
+// key is one signing or verification key of a realm.
+type key struct {
+	id     string
+	method gojwt.SigningMethod
+	sign   interface{}
+	verify interface{}
+}
+
+// KeySet holds the key signing new tokens and every key still accepted for
+// verification, looked up by the kid header of a token.
+//
+// A key is rotated by configuring a new kid and key, and moving the old one to
+// the verification keys until the tokens it signed can no longer be
+// refreshed.
+type KeySet struct {
+	signing *key
+	keys    map[string]*key
+}
+
+// LoadKeySet reads the keys of the realm under prefix from util/config:
+//
+//	<prefix>.algorithm    HS256/384/512, RS256/384/512, PS256/384/512 or ES256/384/512
+//	<prefix>.kid          id of the signing key
+//	<prefix>.key          HMAC secret, or the PEM private key file for RSA and ECDSA
+//	<prefix>.verify_keys  retired keys still accepted, as comma separated
+//	                      kid:alg:key or kid:key entries, the key being the
+//	                      HMAC secret or the public key file. Without alg the
+//	                      key uses <prefix>.algorithm, so a realm can move to
+//	                      another algorithm and still accept the old tokens.
+func LoadKeySet(prefix string) (*KeySet, error) {
+	method := gojwt.GetSigningMethod(config.GetString(prefix + ".algorithm"))
+	if method == nil {
+		return nil, ErrInvalidSigningAlgorithm
+	}
+
+	signing, err := loadSigningKey(method, config.GetString(prefix+".kid"), config.GetString(prefix+".key"))
+	if err != nil {
+		return nil, fmt.Errorf("%s.key: %w", prefix, err)
+	}
+
+	s := &KeySet{
+		signing: signing,
+		keys:    map[string]*key{signing.id: signing},
+	}
+
+	for _, pair := range strings.Split(config.GetString(prefix+".verify_keys"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, keyMethod, material, err := parseVerifyKey(method, pair)
+		if err != nil {
+			return nil, fmt.Errorf("%s.verify_keys: %w", prefix, err)
+		}
+
+		verify, err := loadVerifyKey(keyMethod, material)
+		if err != nil {
+			return nil, fmt.Errorf("%s.verify_keys %s: %w", prefix, id, err)
+		}
+
+		if _, ok := s.keys[id]; ok {
+			return nil, fmt.Errorf("%s.verify_keys: duplicate kid %s", prefix, id)
+		}
+		s.keys[id] = &key{id: id, method: keyMethod, verify: verify}
+	}
+
+	return s, nil
+}
+
+// parseVerifyKey splits a kid:alg:key or kid:key entry. The second field is an
+// algorithm only if it names one, so kid:key entries whose secret contains a
+// colon keep working.
+func parseVerifyKey(method gojwt.SigningMethod, entry string) (string, gojwt.SigningMethod, string, error) {
+	fields := strings.SplitN(entry, ":", 3)
+	if len(fields) < 2 || fields[0] == "" {
+		return "", nil, "", fmt.Errorf("%q is not kid:alg:key or kid:key", entry)
+	}
+
+	if len(fields) == 3 {
+		if m := gojwt.GetSigningMethod(fields[1]); m != nil {
+			return fields[0], m, fields[2], nil
+		}
+	}
+
+	return fields[0], method, strings.Join(fields[1:], ":"), nil
+}
+
+func loadSigningKey(method gojwt.SigningMethod, id, material string) (*key, error) {
+	if material == "" {
+		return nil, ErrMissingSecretKey
+	}
+
+	k := &key{id: id, method: method}
+	switch method.(type) {
+	case *gojwt.SigningMethodHMAC:
+		k.sign, k.verify = []byte(material), []byte(material)
+		return k, nil
+	case *gojwt.SigningMethodRSA, *gojwt.SigningMethodRSAPSS:
+		pem, err := ioutil.ReadFile(material)
+		if err != nil {
+			return nil, err
+		}
+
+		private, err := gojwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+
+		k.sign, k.verify = private, &private.PublicKey
+		return k, nil
+	case *gojwt.SigningMethodECDSA:
+		pem, err := ioutil.ReadFile(material)
+		if err != nil {
+			return nil, err
+		}
+
+		private, err := gojwt.ParseECPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+
+		k.sign, k.verify = private, &private.PublicKey
+		return k, nil
+	default:
+		return nil, ErrInvalidSigningAlgorithm
+	}
+}
+
+// loadVerifyKey accepts a public key file, or a private key file of which
+// only the public part is kept.
+func loadVerifyKey(method gojwt.SigningMethod, material string) (interface{}, error) {
+	if material == "" {
+		return nil, ErrMissingSecretKey
+	}
+
+	if _, ok := method.(*gojwt.SigningMethodHMAC); ok {
+		return []byte(material), nil
+	}
+
+	pem, err := ioutil.ReadFile(material)
+	if err != nil {
+		return nil, err
+	}
+
+	var public crypto.PublicKey
+	switch method.(type) {
+	case *gojwt.SigningMethodRSA, *gojwt.SigningMethodRSAPSS:
+		if public, err = gojwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+			var private *rsa.PrivateKey
+			if private, err = gojwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+				public = &private.PublicKey
+			}
+		}
+	case *gojwt.SigningMethodECDSA:
+		if public, err = gojwt.ParseECPublicKeyFromPEM(pem); err != nil {
+			var private *ecdsa.PrivateKey
+			if private, err = gojwt.ParseECPrivateKeyFromPEM(pem); err == nil {
+				public = &private.PublicKey
+			}
+		}
+	default:
+		return nil, ErrInvalidSigningAlgorithm
+	}
+
+	return public, err
+}
+
+// sign signs claims with the signing key and names it in the kid header.
+func (s *KeySet) sign(claims MapClaims) (string, error) {
+	token := gojwt.NewWithClaims(s.signing.method, gojwt.MapClaims(claims))
+	if s.signing.id != "" {
+		token.Header["kid"] = s.signing.id
+	}
+
+	return token.SignedString(s.signing.sign)
+}
+
+// keyFunc finds the verification key of the token. Tokens without a kid were
+// signed before keys had ids and are checked with the signing key.
+func (s *KeySet) keyFunc(token *gojwt.Token) (interface{}, error) {
+	k := s.signing
+	if id, ok := token.Header["kid"].(string); ok && id != "" {
+		if k, ok = s.keys[id]; !ok {
+			return nil, ErrUnknownKey
+		}
+	}
+
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, ErrInvalidSigningAlgorithm
+	}
+
+	return k.verify, nil
+}
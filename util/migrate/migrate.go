@@ -0,0 +1,354 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	DBName    = "migration"
+	TableName = "schema_migrations"
+
+	lockName    = "wx-demo.migrate"
+	lockTimeout = 60
+)
+
+const (
+	mysqlMigrationCreateDatabase = iota
+	mysqlMigrationCreateTable
+	mysqlMigrationVersion
+	mysqlMigrationDirty
+	mysqlMigrationInsert
+	mysqlMigrationClean
+	mysqlMigrationMarkDirty
+	mysqlMigrationDelete
+	mysqlMigrationGetLock
+	mysqlMigrationReleaseLock
+	mysqlMigrationHasColumn
+	mysqlMigrationHasIndex
+	mysqlMigrationAddColumn
+	mysqlMigrationAddIndex
+	mysqlMigrationDeleteAbove
+	mysqlMigrationCleanAll
+	mysqlMigrationForce
+)
+
+var (
+	// ErrLocked is returned when another instance holds the migration lock.
+	ErrLocked = errors.New("migrate: lock is held by another instance")
+
+	// ErrDirty is returned when a migration of a module failed halfway. The
+	// schema has to be repaired by hand and the version recorded by Force
+	// before running migrations again.
+	ErrDirty = errors.New("migrate: module is dirty")
+
+	migrationSQLString = []string{
+		fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s ;`, DBName),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.%s (
+			module			VARCHAR(64) NOT NULL,
+			version			INT UNSIGNED NOT NULL,
+			description		VARCHAR(255) NOT NULL DEFAULT "",
+			dirty			BOOLEAN NOT NULL DEFAULT TRUE,
+			applied_at		DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (module, version)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;`, DBName, TableName),
+		fmt.Sprintf(`SELECT COALESCE(MAX(version), 0) FROM %s.%s WHERE module = ?`, DBName, TableName),
+		fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s WHERE module = ? AND dirty = TRUE`, DBName, TableName),
+		fmt.Sprintf(`INSERT INTO %s.%s (module, version, description) VALUES (?, ?, ?)`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET dirty = FALSE WHERE module = ? AND version = ?`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET dirty = TRUE WHERE module = ? AND version = ?`, DBName, TableName),
+		fmt.Sprintf(`DELETE FROM %s.%s WHERE module = ? AND version = ?`, DBName, TableName),
+		`SELECT GET_LOCK(?, ?)`,
+		`SELECT RELEASE_LOCK(?)`,
+		`SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = ? AND table_name = ? AND column_name = ?`,
+		`SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = ? AND table_name = ? AND index_name = ?`,
+		`ALTER TABLE %s.%s ADD COLUMN %s %s`,
+		`ALTER TABLE %s.%s ADD %s`,
+		fmt.Sprintf(`DELETE FROM %s.%s WHERE module = ? AND version > ?`, DBName, TableName),
+		fmt.Sprintf(`UPDATE %s.%s SET dirty = FALSE WHERE module = ?`, DBName, TableName),
+		fmt.Sprintf(`INSERT INTO %s.%s (module, version, description, dirty) VALUES (?, ?, ?, FALSE)
+			ON DUPLICATE KEY UPDATE dirty = FALSE`, DBName, TableName),
+	}
+)
+
+// Migration is one versioned change of the schema of a module. Most MySQL DDL
+// commits implicitly, so Up and Down run on the connection pool instead of a
+// transaction.
+type Migration struct {
+	Version     uint32
+	Description string
+	Up          func(db *sql.DB) error
+	Down        func(db *sql.DB) error
+}
+
+// Module is the ordered migrations of one package, e.g. user or goods.
+type Module struct {
+	Name       string
+	Migrations []Migration
+}
+
+// Status is the migration state of a module.
+type Status struct {
+	Module  string `json:"module"`
+	Version uint32 `json:"version"`
+	Latest  uint32 `json:"latest"`
+	Dirty   bool   `json:"dirty"`
+}
+
+// Up applies every pending migration of modules in order while holding the
+// migration lock.
+func Up(db *sql.DB, modules ...Module) error {
+	return withLock(db, func() error {
+		for _, m := range modules {
+			if err := up(db, m); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Down reverts the last steps migrations of module while holding the
+// migration lock.
+func Down(db *sql.DB, module Module, steps int) error {
+	return withLock(db, func() error {
+		return down(db, module, steps)
+	})
+}
+
+// Force records version as the applied version of module and clears its dirty
+// flag, once a failed migration was finished or reverted by hand. Versions
+// above it are forgotten, 0 forgets the module.
+func Force(db *sql.DB, module Module, version uint32) error {
+	var m *Migration
+	for i := range module.Migrations {
+		if module.Migrations[i].Version == version {
+			m = &module.Migrations[i]
+		}
+	}
+
+	if m == nil && version != 0 {
+		return fmt.Errorf("migrate: %s has no version %d", module.Name, version)
+	}
+
+	return withLock(db, func() error {
+		if _, err := db.Exec(migrationSQLString[mysqlMigrationDeleteAbove], module.Name, version); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec(migrationSQLString[mysqlMigrationCleanAll], module.Name); err != nil {
+			return err
+		}
+
+		if m == nil {
+			return nil
+		}
+
+		_, err := db.Exec(migrationSQLString[mysqlMigrationForce], module.Name, m.Version, m.Description)
+		return err
+	})
+}
+
+// Info returns the migration state of modules.
+func Info(db *sql.DB, modules ...Module) ([]Status, error) {
+	if err := createTable(db); err != nil {
+		return nil, err
+	}
+
+	status := make([]Status, 0, len(modules))
+	for _, m := range modules {
+		version, dirty, err := moduleVersion(db, m.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		s := Status{Module: m.Name, Version: version, Dirty: dirty}
+		for _, migration := range m.Migrations {
+			if migration.Version > s.Latest {
+				s.Latest = migration.Version
+			}
+		}
+		status = append(status, s)
+	}
+
+	return status, nil
+}
+
+// HasColumn reports whether table has column.
+func HasColumn(db *sql.DB, schema, table, column string) (bool, error) {
+	var n int
+	err := db.QueryRow(migrationSQLString[mysqlMigrationHasColumn], schema, table, column).Scan(&n)
+	return n > 0, err
+}
+
+// AddColumn adds column to table unless it already exists, so migrations can
+// adopt tables created before they were versioned.
+func AddColumn(db *sql.DB, schema, table, column, definition string) error {
+	exists, err := HasColumn(db, schema, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf(migrationSQLString[mysqlMigrationAddColumn], schema, table, column, definition))
+	return err
+}
+
+// AddIndex adds the index named index with definition, e.g.
+// "INDEX price_index (price)", unless it already exists.
+func AddIndex(db *sql.DB, schema, table, index, definition string) error {
+	var n int
+	if err := db.QueryRow(migrationSQLString[mysqlMigrationHasIndex], schema, table, index).Scan(&n); err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return nil
+	}
+
+	_, err := db.Exec(fmt.Sprintf(migrationSQLString[mysqlMigrationAddIndex], schema, table, definition))
+	return err
+}
+
+// Exec returns a migration step running stmts in order.
+func Exec(stmts ...string) func(db *sql.DB) error {
+	return func(db *sql.DB) error {
+		for _, stmt := range stmts {
+			if _, err := db.Exec(stmt); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func createTable(db *sql.DB) error {
+	if _, err := db.Exec(migrationSQLString[mysqlMigrationCreateDatabase]); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(migrationSQLString[mysqlMigrationCreateTable])
+	return err
+}
+
+// withLock runs fn while holding a MySQL named lock. The lock belongs to the
+// session, so it is taken on a dedicated connection.
+func withLock(db *sql.DB, fn func() error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var ok sql.NullInt64
+	if err := conn.QueryRowContext(ctx, migrationSQLString[mysqlMigrationGetLock], lockName, lockTimeout).Scan(&ok); err != nil {
+		return err
+	}
+
+	if !ok.Valid || ok.Int64 != 1 {
+		return ErrLocked
+	}
+	defer conn.ExecContext(ctx, migrationSQLString[mysqlMigrationReleaseLock], lockName)
+
+	if err := createTable(db); err != nil {
+		return err
+	}
+
+	return fn()
+}
+
+func moduleVersion(db *sql.DB, module string) (uint32, bool, error) {
+	var version uint32
+	if err := db.QueryRow(migrationSQLString[mysqlMigrationVersion], module).Scan(&version); err != nil {
+		return 0, false, err
+	}
+
+	var n int
+	if err := db.QueryRow(migrationSQLString[mysqlMigrationDirty], module).Scan(&n); err != nil {
+		return 0, false, err
+	}
+
+	return version, n > 0, nil
+}
+
+func sorted(module Module) []Migration {
+	migrations := make([]Migration, len(module.Migrations))
+	copy(migrations, module.Migrations)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations
+}
+
+func up(db *sql.DB, module Module) error {
+	version, dirty, err := moduleVersion(db, module.Name)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("%w: %s", ErrDirty, module.Name)
+	}
+
+	for _, m := range sorted(module) {
+		if m.Version <= version {
+			continue
+		}
+
+		if _, err := db.Exec(migrationSQLString[mysqlMigrationInsert], module.Name, m.Version, m.Description); err != nil {
+			return err
+		}
+
+		if err := m.Up(db); err != nil {
+			return fmt.Errorf("migrate %s %d up: %w", module.Name, m.Version, err)
+		}
+
+		if _, err := db.Exec(migrationSQLString[mysqlMigrationClean], module.Name, m.Version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func down(db *sql.DB, module Module, steps int) error {
+	version, dirty, err := moduleVersion(db, module.Name)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("%w: %s", ErrDirty, module.Name)
+	}
+
+	migrations := sorted(module)
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if m.Version > version {
+			continue
+		}
+
+		if _, err := db.Exec(migrationSQLString[mysqlMigrationMarkDirty], module.Name, m.Version); err != nil {
+			return err
+		}
+
+		if m.Down != nil {
+			if err := m.Down(db); err != nil {
+				return fmt.Errorf("migrate %s %d down: %w", module.Name, m.Version, err)
+			}
+		}
+
+		if _, err := db.Exec(migrationSQLString[mysqlMigrationDelete], module.Name, m.Version); err != nil {
+			return err
+		}
+		steps--
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of an amount without one, and of every
+// amount in the database and the API, which hold the amount only. WeChat Pay
+// of mini-programs only takes CNY.
+const DefaultCurrency = "CNY"
+
+// minorDigits is the number of decimal digits of the minor unit.
+const minorDigits = 2
+
+var (
+	errInvalidAmount = errors.New("money: invalid amount")
+	// ErrCurrency is returned when an amount in another currency than
+	// DefaultCurrency is stored or encoded, the columns and payloads have no
+	// room for it.
+	ErrCurrency = errors.New("money: unsupported currency")
+)
+
+// Money is an amount in the minor unit of its currency, e.g. fen for CNY. It is
+// stored in the database as a BIGINT of minor units and encoded in JSON as a
+// decimal number of major units, e.g. 12.34, so API payloads keep their shape.
+// Both only hold amounts in DefaultCurrency.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// New returns an amount of minor units in the default currency.
+func New(amount int64) Money {
+	return Money{Amount: amount, Currency: DefaultCurrency}
+}
+
+// Parse reads a decimal amount of major units, e.g. "12.34", without going
+// through float64.
+func Parse(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	parts := strings.SplitN(s, ".", 2)
+	if parts[0] == "" || (len(parts) == 2 && (parts[1] == "" || len(parts[1]) > minorDigits)) {
+		return Money{}, fmt.Errorf("%w: %q", errInvalidAmount, s)
+	}
+
+	fraction := ""
+	if len(parts) == 2 {
+		fraction = parts[1]
+	}
+	fraction += strings.Repeat("0", minorDigits-len(fraction))
+
+	amount, err := strconv.ParseUint(parts[0]+fraction, 10, 63)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", errInvalidAmount, s)
+	}
+
+	m := New(int64(amount))
+	if negative {
+		m.Amount = -m.Amount
+	}
+	return m, nil
+}
+
+func (m Money) currency() string {
+	if m.Currency == "" {
+		return DefaultCurrency
+	}
+	return m.Currency
+}
+
+func (m Money) mustMatch(o Money) {
+	if m.currency() != o.currency() {
+		panic(fmt.Sprintf("money: currency mismatch %s and %s", m.currency(), o.currency()))
+	}
+}
+
+// Add returns m + o, it panics if the currencies differ.
+func (m Money) Add(o Money) Money {
+	m.mustMatch(o)
+	return Money{Amount: m.Amount + o.Amount, Currency: m.currency()}
+}
+
+// Sub returns m - o, it panics if the currencies differ.
+func (m Money) Sub(o Money) Money {
+	m.mustMatch(o)
+	return Money{Amount: m.Amount - o.Amount, Currency: m.currency()}
+}
+
+// Mul returns m * n, e.g. the subtotal of n items of unit price m.
+func (m Money) Mul(n int64) Money {
+	return Money{Amount: m.Amount * n, Currency: m.currency()}
+}
+
+// Cmp returns -1, 0 or 1 if m is less than, equal to or greater than o. It
+// panics if the currencies differ.
+func (m Money) Cmp(o Money) int {
+	m.mustMatch(o)
+	switch {
+	case m.Amount < o.Amount:
+		return -1
+	case m.Amount > o.Amount:
+		return 1
+	}
+	return 0
+}
+
+// IsNegative reports whether m is below zero.
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// Decimal formats m in major units, e.g. "12.34".
+func (m Money) Decimal() string {
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	s := fmt.Sprintf("%0*d", minorDigits+1, amount)
+	return sign + s[:len(s)-minorDigits] + "." + s[len(s)-minorDigits:]
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.currency()
+}
+
+// MarshalJSON encodes m as a decimal number of major units, it returns
+// ErrCurrency if m is not in the default currency.
+func (m Money) MarshalJSON() ([]byte, error) {
+	if m.currency() != DefaultCurrency {
+		return nil, fmt.Errorf("%w: %s", ErrCurrency, m.currency())
+	}
+	return []byte(m.Decimal()), nil
+}
+
+// UnmarshalJSON accepts a decimal number or string of major units in the
+// default currency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" {
+		return nil
+	}
+
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	*m = v
+	return nil
+}
+
+// Value stores m as its minor units, it returns ErrCurrency if m is not in the
+// default currency.
+func (m Money) Value() (driver.Value, error) {
+	if m.currency() != DefaultCurrency {
+		return nil, fmt.Errorf("%w: %s", ErrCurrency, m.currency())
+	}
+	return m.Amount, nil
+}
+
+// Scan reads minor units in the default currency.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		*m = New(v)
+	case []byte:
+		amount, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return err
+		}
+		*m = New(amount)
+	case nil:
+		*m = New(0)
+	default:
+		return fmt.Errorf("money: can not scan %T", src)
+	}
+
+	return nil
+}
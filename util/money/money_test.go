@@ -0,0 +1,212 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"12", 1200},
+		{"12.3", 1230},
+		{"12.34", 1234},
+		{"0.01", 1},
+		{" 7.50 ", 750},
+		{"-0.5", -50},
+		{"-12.34", -1234},
+		{"92233720368547758.07", 9223372036854775807},
+	} {
+		m, err := Parse(c.in)
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", c.in, err)
+			continue
+		}
+		if m.Amount != c.want || m.Currency != DefaultCurrency {
+			t.Errorf("Parse(%q) = %v, want %d %s", c.in, m, c.want, DefaultCurrency)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"-",
+		".5",
+		"1.",
+		"1.234",
+		"1.2.3",
+		"+1",
+		"1e3",
+		"abc",
+		"92233720368547758.08",
+	} {
+		if _, err := Parse(in); !errors.Is(err, errInvalidAmount) {
+			t.Errorf("Parse(%q) error = %v, want %v", in, err, errInvalidAmount)
+		}
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	for _, c := range []struct {
+		amount int64
+		want   string
+	}{
+		{0, "0.00"},
+		{1, "0.01"},
+		{10, "0.10"},
+		{1234, "12.34"},
+		{-5, "-0.05"},
+		{-1234, "-12.34"},
+	} {
+		if got := New(c.amount).Decimal(); got != c.want {
+			t.Errorf("New(%d).Decimal() = %q, want %q", c.amount, got, c.want)
+		}
+	}
+
+	if got := New(1234).String(); got != "12.34 CNY" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := (Money{Amount: 1234, Currency: "USD"}).String(); got != "12.34 USD" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Price Money `json:"price"`
+	}{New(1250)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"price":12.50}` {
+		t.Errorf("Marshal = %s", data)
+	}
+
+	for _, c := range []struct {
+		in   string
+		want int64
+	}{
+		{`{"price":12.5}`, 1250},
+		{`{"price":"12.34"}`, 1234},
+		{`{"price":null}`, 99},
+		{`{}`, 99},
+	} {
+		v := struct {
+			Price Money `json:"price"`
+		}{New(99)}
+		if err := json.Unmarshal([]byte(c.in), &v); err != nil {
+			t.Errorf("Unmarshal(%s) error = %v", c.in, err)
+			continue
+		}
+		if v.Price.Amount != c.want || v.Price.Currency != DefaultCurrency {
+			t.Errorf("Unmarshal(%s) = %v, want %d %s", c.in, v.Price, c.want, DefaultCurrency)
+		}
+	}
+
+	if _, err := json.Marshal(Money{Amount: 100, Currency: "USD"}); !errors.Is(err, ErrCurrency) {
+		t.Errorf("Marshal of USD error = %v, want %v", err, ErrCurrency)
+	}
+
+	for _, in := range []string{`{"price":12.345}`, `{"price":"x"}`, `{"price":true}`} {
+		var v struct {
+			Price Money `json:"price"`
+		}
+		if err := json.Unmarshal([]byte(in), &v); err == nil {
+			t.Errorf("Unmarshal(%s) succeeded", in)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	a, b := New(1050), New(250)
+
+	if got := a.Add(b).Amount; got != 1300 {
+		t.Errorf("Add = %d", got)
+	}
+	if got := a.Sub(b).Amount; got != 800 {
+		t.Errorf("Sub = %d", got)
+	}
+	if got := b.Sub(a); got.Amount != -800 || !got.IsNegative() {
+		t.Errorf("Sub = %d", got.Amount)
+	}
+	if got := a.Mul(3).Amount; got != 3150 {
+		t.Errorf("Mul = %d", got)
+	}
+
+	for _, c := range []struct {
+		a, b Money
+		want int
+	}{
+		{a, b, 1},
+		{b, a, -1},
+		{a, New(1050), 0},
+	} {
+		if got := c.a.Cmp(c.b); got != c.want {
+			t.Errorf("%v.Cmp(%v) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+
+	// the zero value is in the default currency.
+	if got := (Money{Amount: 5}).Add(a); got != New(1055) {
+		t.Errorf("Add to zero currency = %v", got)
+	}
+
+	usd := Money{Amount: 100, Currency: "USD"}
+	for name, f := range map[string]func(){
+		"Add": func() { a.Add(usd) },
+		"Sub": func() { a.Sub(usd) },
+		"Cmp": func() { a.Cmp(usd) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s of CNY and USD did not panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+
+	if got := usd.Mul(2); got.Currency != "USD" {
+		t.Errorf("Mul currency = %q, want USD", got.Currency)
+	}
+}
+
+func TestSQL(t *testing.T) {
+	v, err := New(1234).Value()
+	if err != nil || v != int64(1234) {
+		t.Errorf("Value() = %v, %v", v, err)
+	}
+
+	for _, c := range []struct {
+		src  interface{}
+		want int64
+	}{
+		{int64(1234), 1234},
+		{[]byte("-56"), -56},
+		{nil, 0},
+	} {
+		m := New(99)
+		if err := m.Scan(c.src); err != nil {
+			t.Errorf("Scan(%#v) error = %v", c.src, err)
+			continue
+		}
+		if m.Amount != c.want || m.Currency != DefaultCurrency {
+			t.Errorf("Scan(%#v) = %v, want %d %s", c.src, m, c.want, DefaultCurrency)
+		}
+	}
+
+	if _, err := (Money{Amount: 100, Currency: "USD"}).Value(); !errors.Is(err, ErrCurrency) {
+		t.Errorf("Value of USD error = %v, want %v", err, ErrCurrency)
+	}
+
+	for _, src := range []interface{}{[]byte("12.34"), 12.34, "1234"} {
+		var m Money
+		if err := m.Scan(src); err == nil {
+			t.Errorf("Scan(%#v) succeeded", src)
+		}
+	}
+}
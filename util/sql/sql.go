@@ -1,6 +1,9 @@
 package sql
 
-import "database/sql"
+import (
+	"database/sql"
+	"fmt"
+)
 
 const (
 	mysqlDropDatabase = iota
@@ -23,3 +26,69 @@ func DropDatabaseFirst(db *sql.DB) error {
 	_, err := db.Exec(DropSQLStrings[mysqlDropDatabase])
 	return err
 }
+
+const (
+	mysqlColumnType = iota
+	mysqlAddColumn
+	mysqlFillCents
+	mysqlSwapColumn
+	mysqlFillMajor
+)
+
+var convertSQLStrings = []string{
+	`SELECT DATA_TYPE FROM information_schema.columns WHERE table_schema = ? AND table_name = ? AND column_name = ?`,
+	`ALTER TABLE %s.%s ADD COLUMN %s %s`,
+	`UPDATE %s.%s SET %s = ROUND(%s * 100)`,
+	`ALTER TABLE %s.%s DROP COLUMN %s, CHANGE COLUMN %s %s %s`,
+	`UPDATE %s.%s SET %s = %s / 100`,
+}
+
+// ConvertDoubleToCents converts a DOUBLE column of major units to a column of
+// minor units with definition, e.g. "BIGINT NOT NULL DEFAULT 0". The values
+// are copied to a temporary column and swapped in one ALTER, so it can be run
+// again after a failure and does nothing once the column is converted.
+func ConvertDoubleToCents(db *sql.DB, schema, table, column, definition string) error {
+	return convertColumn(db, schema, table, column, "double", column+"_cents", mysqlFillCents, definition)
+}
+
+// ConvertCentsToDouble reverts ConvertDoubleToCents, the BIGINT column of
+// minor units becomes a column of major units with definition, e.g.
+// "DOUBLE NOT NULL DEFAULT 0".
+func ConvertCentsToDouble(db *sql.DB, schema, table, column, definition string) error {
+	return convertColumn(db, schema, table, column, "bigint", column+"_major", mysqlFillMajor, definition)
+}
+
+// convertColumn replaces column of dataType by tmp filled by the fill
+// statement and renamed to column, it does nothing if column is of another
+// type. CHANGE COLUMN renames on MySQL 5.7, RENAME COLUMN needs 8.0.
+func convertColumn(db *sql.DB, schema, table, column, dataType, tmp string, fill int, definition string) error {
+	var current string
+	err := db.QueryRow(convertSQLStrings[mysqlColumnType], schema, table, column).Scan(&current)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if current != dataType {
+		return nil
+	}
+
+	var tmpType string
+	err = db.QueryRow(convertSQLStrings[mysqlColumnType], schema, table, tmp).Scan(&tmpType)
+	if err == sql.ErrNoRows {
+		if _, err := db.Exec(fmt.Sprintf(convertSQLStrings[mysqlAddColumn], schema, table, tmp, definition)); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(fmt.Sprintf(convertSQLStrings[fill], schema, table, tmp, column)); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf(convertSQLStrings[mysqlSwapColumn], schema, table, column, tmp, column, definition))
+	return err
+}
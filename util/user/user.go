@@ -27,3 +27,19 @@ func GetID(ctx *gin.Context) (uint32, error) {
 
 	return uint32(v), nil
 }
+
+// GetAdminID returns the id of the admin account set by the admin JWT
+// middleware.
+func GetAdminID(ctx *gin.Context) (uint32, error) {
+	id, ok := ctx.Get("adminID")
+	if !ok {
+		return 0, errUserIDNotExists
+	}
+
+	v, ok := id.(float64)
+	if !ok {
+		return 0, errUserIDNotValid(id)
+	}
+
+	return uint32(v), nil
+}
@@ -0,0 +1,163 @@
+// Package wxdata verifies and decrypts the open data the mini-program gets
+// from WeChat, as the rawData and signature or the encryptedData and iv of
+// getPhoneNumber and getUserProfile. The data is signed with, or sealed with
+// AES-128-CBC by, the session key of the last jscode2session.
+package wxdata
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	// ErrInvalidData returned when the data can not be decrypted, most of the
+	// time because the session key changed since the client got the data.
+	ErrInvalidData = errors.New("wxdata: invalid encrypted data")
+
+	// ErrWatermark returned when the data was issued for another appid.
+	ErrWatermark = errors.New("wxdata: watermark appid mismatch")
+
+	// ErrSignature returned when the signature of the raw data is not the one
+	// of the session key.
+	ErrSignature = errors.New("wxdata: invalid signature")
+)
+
+// Watermark is attached by WeChat to every decrypted data.
+type Watermark struct {
+	AppID     string `json:"appid"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// PhoneNumber is the data of getPhoneNumber.
+type PhoneNumber struct {
+	// PhoneNumber has the area code for numbers outside mainland China.
+	PhoneNumber     string    `json:"phoneNumber"`
+	PurePhoneNumber string    `json:"purePhoneNumber"`
+	CountryCode     string    `json:"countryCode"`
+	Watermark       Watermark `json:"watermark"`
+}
+
+// UserInfo is the data of getUserProfile. The rawData has no OpenID, UnionID
+// and Watermark, only the encryptedData has them.
+type UserInfo struct {
+	OpenID    string `json:"openId"`
+	UnionID   string `json:"unionId"`
+	NickName  string `json:"nickName"`
+	AvatarURL string `json:"avatarUrl"`
+	// Gender is 0 unknown 1 man 2 woman.
+	Gender    int       `json:"gender"`
+	City      string    `json:"city"`
+	Province  string    `json:"province"`
+	Country   string    `json:"country"`
+	Language  string    `json:"language"`
+	Watermark Watermark `json:"watermark"`
+}
+
+// CheckSignature checks signature is the hex SHA1 of rawData followed by the
+// session key.
+func CheckSignature(sessionKey, rawData, signature string) error {
+	sum := sha1.Sum([]byte(rawData + sessionKey))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(signature)) != 1 {
+		return ErrSignature
+	}
+
+	return nil
+}
+
+// Decrypt opens encryptedData with the session key and iv, all base64 as
+// WeChat gives them, and unmarshals it into v after checking its watermark is
+// appid.
+func Decrypt(sessionKey, encryptedData, iv, appid string, v interface{}) error {
+	plain, err := decrypt(sessionKey, encryptedData, iv)
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		Watermark Watermark `json:"watermark"`
+	}
+	if err := json.Unmarshal(plain, &data); err != nil {
+		return ErrInvalidData
+	}
+
+	if data.Watermark.AppID != appid {
+		return ErrWatermark
+	}
+
+	return json.Unmarshal(plain, v)
+}
+
+func decrypt(sessionKey, encryptedData, iv string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(sessionKey)
+	if err != nil || len(key) != 16 {
+		return nil, ErrInvalidData
+	}
+
+	vector, err := base64.StdEncoding.DecodeString(iv)
+	if err != nil || len(vector) != aes.BlockSize {
+		return nil, ErrInvalidData
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encryptedData)
+	if err != nil || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, ErrInvalidData
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, vector).CryptBlocks(plain, ciphertext)
+
+	return unpad(plain)
+}
+
+// Encrypt seals plain with the session key and iv as WeChat does, for tests
+// and mocks of the mini-program.
+func Encrypt(sessionKey, iv string, plain []byte) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(sessionKey)
+	if err != nil || len(key) != 16 {
+		return "", ErrInvalidData
+	}
+
+	vector, err := base64.StdEncoding.DecodeString(iv)
+	if err != nil || len(vector) != aes.BlockSize {
+		return "", ErrInvalidData
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, vector).CryptBlocks(padded, padded)
+
+	return base64.StdEncoding.EncodeToString(padded), nil
+}
+
+// unpad strips the PKCS#7 padding of plain.
+func unpad(plain []byte) ([]byte, error) {
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, ErrInvalidData
+	}
+
+	for _, b := range plain[len(plain)-padding:] {
+		if int(b) != padding {
+			return nil, ErrInvalidData
+		}
+	}
+
+	return plain[:len(plain)-padding], nil
+}
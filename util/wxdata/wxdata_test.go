@@ -0,0 +1,59 @@
+package wxdata
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+var (
+	testSessionKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	testIV         = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210"))
+)
+
+func TestDecrypt(t *testing.T) {
+	data, err := Encrypt(testSessionKey, testIV, []byte(`{"phoneNumber":"13800138000","purePhoneNumber":"13800138000","countryCode":"86","watermark":{"appid":"wx-test","timestamp":1600000000}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var phone PhoneNumber
+	if err := Decrypt(testSessionKey, data, testIV, "wx-test", &phone); err != nil {
+		t.Fatal(err)
+	}
+	if phone.PurePhoneNumber != "13800138000" || phone.CountryCode != "86" || phone.Watermark.Timestamp != 1600000000 {
+		t.Errorf("decrypted %+v", phone)
+	}
+
+	if err := Decrypt(testSessionKey, data, testIV, "wx-other", &phone); err != ErrWatermark {
+		t.Errorf("other appid error = %v, want %v", err, ErrWatermark)
+	}
+
+	otherKey := base64.StdEncoding.EncodeToString([]byte("abcdef0123456789"))
+	for name, args := range map[string][3]string{
+		"session key": {otherKey, data, testIV},
+		"short key":   {"c2Vzc2lvbg==", data, testIV},
+		"iv":          {testSessionKey, data, "not base64"},
+		"data":        {testSessionKey, data[:len(data)-4], testIV},
+		"empty":       {testSessionKey, "", testIV},
+	} {
+		if err := Decrypt(args[0], args[1], args[2], "wx-test", &phone); err != ErrInvalidData {
+			t.Errorf("%s: error = %v, want %v", name, err, ErrInvalidData)
+		}
+	}
+}
+
+func TestCheckSignature(t *testing.T) {
+	raw := `{"nickName":"cat","gender":2}`
+	sum := sha1.Sum([]byte(raw + testSessionKey))
+	signature := hex.EncodeToString(sum[:])
+
+	if err := CheckSignature(testSessionKey, raw, signature); err != nil {
+		t.Errorf("signature error = %v", err)
+	}
+
+	if err := CheckSignature(testSessionKey, raw+" ", signature); err != ErrSignature {
+		t.Errorf("changed data error = %v, want %v", err, ErrSignature)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/dovics/wx-demo/util/wxpay"
+	"github.com/dovics/wx-demo/util/wxpay/mock"
+)
+
+// Starts a local WeChat Pay stand-in. It generates the merchant and platform
+// keys into dir and prints the env to point the app at it.
+func main() {
+	var (
+		host  = flag.String("host", ":9574", "listen address")
+		dir   = flag.String("dir", ".", "directory to write the generated keys")
+		appID = flag.String("appid", "wxmockappid", "mini-program appid")
+		mchID = flag.String("mchid", "1900000001", "merchant id")
+	)
+	flag.Parse()
+
+	merchantKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	platformKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	apiv3Key, err := wxpay.NonceStr()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	privatePath := filepath.Join(*dir, "wxpay_merchant_key.pem")
+	writePEM(privatePath, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(merchantKey))
+
+	platformDER, err := x509.MarshalPKIXPublicKey(&platformKey.PublicKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	platformPath := filepath.Join(*dir, "wxpay_platform_key.pem")
+	writePEM(platformPath, "PUBLIC KEY", platformDER)
+
+	fmt.Println(strings.Join([]string{
+		"WX_APPID=" + *appID,
+		"WX_PAY_BASE_URL=http://localhost" + *host,
+		"WX_PAY_MCHID=" + *mchID,
+		"WX_PAY_SERIAL_NO=MOCK",
+		"WX_PAY_APIV3_KEY=" + apiv3Key,
+		"WX_PAY_PRIVATE_KEY=" + privatePath,
+		"WX_PAY_PLATFORM_KEY=" + platformPath,
+	}, "\n"))
+
+	server := mock.New(*appID, *mchID, apiv3Key, &merchantKey.PublicKey, platformKey)
+	http.Handle("/", server)
+	http.HandleFunc("/mock/pay", func(w http.ResponseWriter, r *http.Request) {
+		if err := server.Pay(r.URL.Query().Get("out_trade_no")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	})
+
+	http.HandleFunc("/mock/refund", func(w http.ResponseWriter, r *http.Request) {
+		if err := server.CompleteRefund(r.URL.Query().Get("out_refund_no")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	})
+
+	log.Println("Starting mock WeChat Pay in ", *host)
+	log.Fatal(http.ListenAndServe(*host, nil))
+}
+
+func writePEM(path, kind string, der []byte) {
+	buf := pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		log.Fatal(err)
+	}
+}
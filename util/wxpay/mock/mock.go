@@ -0,0 +1,335 @@
+package mock
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dovics/wx-demo/util/wxpay"
+)
+
+var errTransactionNotExists = errors.New("mock: transaction is not exists")
+
+type transaction struct {
+	prepayID  string
+	notifyURL string
+	refunded  int64
+	wxpay.Transaction
+}
+
+type refund struct {
+	notifyURL string
+	amount    int64
+	wxpay.Refund
+}
+
+// Server is a local stand-in of the WeChat Pay v3 api. It verifies the merchant
+// signature of every request, signs its responses with the platform key, and
+// posts encrypted notifications when a transaction is paid.
+type Server struct {
+	AppID    string
+	MchID    string
+	APIv3Key string
+
+	merchantKey *rsa.PublicKey
+	platformKey *rsa.PrivateKey
+	client      *http.Client
+
+	mu           sync.Mutex
+	transactions map[string]*transaction
+	refunds      map[string]*refund
+}
+
+// New create a mock pay server.
+func New(appID, mchID, apiv3Key string, merchantKey *rsa.PublicKey, platformKey *rsa.PrivateKey) *Server {
+	return &Server{
+		AppID:        appID,
+		MchID:        mchID,
+		APIv3Key:     apiv3Key,
+		merchantKey:  merchantKey,
+		platformKey:  platformKey,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		transactions: make(map[string]*transaction),
+		refunds:      make(map[string]*refund),
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, "PARAM_ERROR", err.Error())
+		return
+	}
+
+	if err := s.verify(r, body); err != nil {
+		s.error(w, http.StatusUnauthorized, "SIGN_ERROR", err.Error())
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v3/pay/transactions/jsapi":
+		s.prepay(w, body)
+	case r.Method == http.MethodPost && r.URL.Path == "/v3/refund/domestic/refunds":
+		s.refund(w, body)
+	default:
+		s.error(w, http.StatusNotFound, "NOT_FOUND", r.URL.Path)
+	}
+}
+
+func (s *Server) prepay(w http.ResponseWriter, body []byte) {
+	var req wxpay.PrepayRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.error(w, http.StatusBadRequest, "PARAM_ERROR", err.Error())
+		return
+	}
+
+	if req.AppID != s.AppID || req.MchID != s.MchID {
+		s.error(w, http.StatusBadRequest, "APPID_MCHID_NOT_MATCH", "appid and mchid not match")
+		return
+	}
+
+	if req.OutTradeNo == "" || req.Amount.Total <= 0 || req.Payer.OpenID == "" {
+		s.error(w, http.StatusBadRequest, "PARAM_ERROR", "invalid prepay request")
+		return
+	}
+
+	s.mu.Lock()
+	t, ok := s.transactions[req.OutTradeNo]
+	if !ok {
+		nonce, _ := wxpay.NonceStr()
+		t = &transaction{prepayID: "wx" + nonce, notifyURL: req.NotifyURL}
+		t.AppID = req.AppID
+		t.MchID = req.MchID
+		t.OutTradeNo = req.OutTradeNo
+		t.TradeState = "NOTPAY"
+		t.Amount.Total = req.Amount.Total
+		t.Amount.Currency = req.Amount.Currency
+		s.transactions[req.OutTradeNo] = t
+	}
+	prepayID := t.prepayID
+	s.mu.Unlock()
+
+	s.json(w, http.StatusOK, map[string]string{"prepay_id": prepayID})
+}
+
+// Pay marks the transaction as paid and posts the notification to its notify url.
+func (s *Server) Pay(outTradeNo string) error {
+	s.mu.Lock()
+	t, ok := s.transactions[outTradeNo]
+	if !ok {
+		s.mu.Unlock()
+		return errTransactionNotExists
+	}
+	t.TradeState = wxpay.TradeStateSuccess
+	t.TransactionID = strconv.FormatInt(time.Now().UnixNano(), 10)
+	t.SuccessTime = time.Now().Format(time.RFC3339)
+	t.Amount.PayerTotal = t.Amount.Total
+	tx := t.Transaction
+	notifyURL := t.notifyURL
+	s.mu.Unlock()
+
+	return s.Notify(notifyURL, "TRANSACTION.SUCCESS", "transaction", tx)
+}
+
+func (s *Server) refund(w http.ResponseWriter, body []byte) {
+	var req wxpay.RefundRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.error(w, http.StatusBadRequest, "PARAM_ERROR", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.refunds[req.OutRefundNo]; ok {
+		if r.amount != req.Amount.Refund {
+			s.error(w, http.StatusBadRequest, "INVALID_REQUEST", "out_refund_no is used by another amount")
+			return
+		}
+		s.json(w, http.StatusOK, r.Refund)
+		return
+	}
+
+	t, ok := s.transactions[req.OutTradeNo]
+	if !ok || t.TradeState != wxpay.TradeStateSuccess {
+		s.error(w, http.StatusNotFound, "RESOURCE_NOT_EXISTS", "transaction is not paid")
+		return
+	}
+
+	if req.Amount.Total != t.Amount.Total || req.Amount.Refund <= 0 ||
+		t.refunded+req.Amount.Refund > t.Amount.Total {
+		s.error(w, http.StatusForbidden, "NOT_ENOUGH", "invalid refund amount")
+		return
+	}
+	t.refunded += req.Amount.Refund
+
+	nonce, _ := wxpay.NonceStr()
+	r := &refund{notifyURL: req.NotifyURL, amount: req.Amount.Refund}
+	r.RefundID = "50" + nonce
+	r.OutRefundNo = req.OutRefundNo
+	r.OutTradeNo = t.OutTradeNo
+	r.TransactionID = t.TransactionID
+	r.Status = wxpay.RefundStatusProcessing
+	s.refunds[req.OutRefundNo] = r
+
+	s.json(w, http.StatusOK, r.Refund)
+}
+
+// CompleteRefund marks the refund as success and posts the notification to its notify url.
+func (s *Server) CompleteRefund(outRefundNo string) error {
+	return s.finishRefund(outRefundNo, wxpay.RefundStatusSuccess)
+}
+
+// CloseRefund ends the refund with status CLOSED or ABNORMAL and posts the
+// notification to its notify url. The amount can be refunded again.
+func (s *Server) CloseRefund(outRefundNo, status string) error {
+	return s.finishRefund(outRefundNo, status)
+}
+
+func (s *Server) finishRefund(outRefundNo, status string) error {
+	s.mu.Lock()
+	r, ok := s.refunds[outRefundNo]
+	if !ok {
+		s.mu.Unlock()
+		return errTransactionNotExists
+	}
+	r.Status = status
+
+	t := s.transactions[r.OutTradeNo]
+	if status != wxpay.RefundStatusSuccess {
+		t.refunded -= r.amount
+	}
+
+	var result wxpay.RefundResult
+	result.MchID = s.MchID
+	result.OutTradeNo = r.OutTradeNo
+	result.TransactionID = r.TransactionID
+	result.OutRefundNo = r.OutRefundNo
+	result.RefundID = r.RefundID
+	result.RefundStatus = r.Status
+	result.SuccessTime = time.Now().Format(time.RFC3339)
+	result.Amount.Total = t.Amount.Total
+	result.Amount.Refund = r.amount
+	notifyURL := r.notifyURL
+	s.mu.Unlock()
+
+	return s.Notify(notifyURL, "REFUND."+status, "refund", result)
+}
+
+// Notify posts a signed notification with resource encrypted by the apiv3 key.
+func (s *Server) Notify(url, eventType, associatedData string, resource interface{}) error {
+	plain, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := wxpay.Encrypt([]byte(s.APIv3Key), plain, associatedData)
+	if err != nil {
+		return err
+	}
+
+	id, _ := wxpay.NonceStr()
+	body, err := json.Marshal(wxpay.Notification{
+		ID:           id,
+		CreateTime:   time.Now().Format(time.RFC3339),
+		EventType:    eventType,
+		ResourceType: "encrypt-resource",
+		Resource:     *encrypted,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := s.sign(req.Header, body); err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("mock: notify %s returns status %d", url, resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (s *Server) verify(r *http.Request, body []byte) error {
+	auth := r.Header.Get("Authorization")
+	const schema = "WECHATPAY2-SHA256-RSA2048 "
+	if !strings.HasPrefix(auth, schema) {
+		return errors.New("mock: invalid authorization schema")
+	}
+
+	fields := make(map[string]string)
+	for _, kv := range strings.Split(strings.TrimPrefix(auth, schema), ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields[strings.TrimSpace(parts[0])] = strings.Trim(parts[1], `"`)
+	}
+
+	if fields["mchid"] != s.MchID {
+		return errors.New("mock: invalid mchid")
+	}
+
+	return wxpay.Verify(s.merchantKey, fields["signature"], r.Method, r.URL.RequestURI(),
+		fields["timestamp"], fields["nonce_str"], string(body))
+}
+
+func (s *Server) sign(header http.Header, body []byte) error {
+	nonce, err := wxpay.NonceStr()
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature, err := wxpay.Sign(s.platformKey, timestamp, nonce, string(body))
+	if err != nil {
+		return err
+	}
+
+	header.Set("Wechatpay-Timestamp", timestamp)
+	header.Set("Wechatpay-Nonce", nonce)
+	header.Set("Wechatpay-Signature", signature)
+	header.Set("Wechatpay-Serial", "MOCK")
+	return nil
+}
+
+func (s *Server) json(w http.ResponseWriter, code int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Println("[mock wxpay] marshal response fail: ", err)
+		code = http.StatusInternalServerError
+		body = []byte(`{"code":"SYSTEM_ERROR"}`)
+	}
+
+	if err := s.sign(w.Header(), body); err != nil {
+		log.Println("[mock wxpay] sign response fail: ", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
+
+func (s *Server) error(w http.ResponseWriter, code int, errCode, message string) {
+	s.json(w, code, map[string]string{"code": errCode, "message": message})
+}
@@ -0,0 +1,58 @@
+package mock
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dovics/wx-demo/util/wxpay"
+)
+
+func TestServeHTTP(t *testing.T) {
+	merchantKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	platformKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New("wx-test", "1900000001", "0123456789abcdef0123456789abcdef", &merchantKey.PublicKey, platformKey)
+
+	for name, auth := range map[string]string{
+		"no authorization": "",
+		"other schema":     "Bearer token",
+		"other mchid":      `WECHATPAY2-SHA256-RSA2048 mchid="1900000002",nonce_str="N",signature="S",timestamp="1"`,
+		"bad signature":    `WECHATPAY2-SHA256-RSA2048 mchid="1900000001",nonce_str="N",signature="S",timestamp="1"`,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/v3/pay/transactions/jsapi", strings.NewReader(`{}`))
+		r.Header.Set("Authorization", auth)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		var e wxpay.Error
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusUnauthorized || e.Code != "SIGN_ERROR" {
+			t.Errorf("%s: status %d code %s, want 401 SIGN_ERROR", name, w.Code, e.Code)
+		}
+
+		// even errors are signed by the platform key
+		if err := wxpay.Verify(&platformKey.PublicKey, w.Header().Get("Wechatpay-Signature"),
+			w.Header().Get("Wechatpay-Timestamp"), w.Header().Get("Wechatpay-Nonce"), w.Body.String()); err != nil {
+			t.Errorf("%s: response signature: %v", name, err)
+		}
+	}
+
+	if err := s.Pay("WXO000000001000"); err != errTransactionNotExists {
+		t.Errorf("pay unknown transaction error = %v, want %v", err, errTransactionNotExists)
+	}
+	if err := s.CompleteRefund("WXR000000001000001"); err != errTransactionNotExists {
+		t.Errorf("complete unknown refund error = %v, want %v", err, errTransactionNotExists)
+	}
+}
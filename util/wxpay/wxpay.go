@@ -0,0 +1,558 @@
+package wxpay
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the base url of the WeChat Pay v3 api.
+const DefaultBaseURL = "https://api.mch.weixin.qq.com"
+
+const (
+	authSchema = "WECHATPAY2-SHA256-RSA2048"
+
+	jsapiPrepayPath = "/v3/pay/transactions/jsapi"
+	refundPath      = "/v3/refund/domestic/refunds"
+
+	headerTimestamp = "Wechatpay-Timestamp"
+	headerNonce     = "Wechatpay-Nonce"
+	headerSignature = "Wechatpay-Signature"
+	headerSerial    = "Wechatpay-Serial"
+
+	// notifications older than this are rejected to prevent replay.
+	notifyMaxAge = 5 * time.Minute
+)
+
+var (
+	ErrInvalidSignature = errors.New("wxpay: invalid signature")
+	ErrInvalidPEM       = errors.New("wxpay: invalid pem")
+	ErrExpired          = errors.New("wxpay: notification expired")
+	ErrMerchantMismatch = errors.New("wxpay: appid or mchid of another merchant")
+)
+
+// Error is returned when the pay api answers with a non 2xx status.
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("wxpay: status %d, %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Config of a merchant client.
+type Config struct {
+	BaseURL  string
+	AppID    string
+	MchID    string
+	SerialNo string
+	APIv3Key string
+
+	PrivateKey  *rsa.PrivateKey
+	PlatformKey *rsa.PublicKey
+	HTTPClient  *http.Client
+}
+
+// Client calls the WeChat Pay v3 api as a merchant.
+type Client struct {
+	cfg Config
+}
+
+// NewClient create a merchant client.
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.AppID == "" || cfg.MchID == "" || cfg.SerialNo == "" {
+		return nil, errors.New("wxpay: appid, mchid and serial no are required")
+	}
+
+	if len(cfg.APIv3Key) != 32 {
+		return nil, errors.New("wxpay: apiv3 key must be 32 bytes")
+	}
+
+	if cfg.PrivateKey == nil || cfg.PlatformKey == nil {
+		return nil, errors.New("wxpay: private key and platform key are required")
+	}
+
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultBaseURL
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Client{cfg: cfg}, nil
+}
+
+// Amount in the minor unit of the currency.
+type Amount struct {
+	Total    int64  `json:"total"`
+	Currency string `json:"currency,omitempty"`
+}
+
+// Payer of a jsapi transaction.
+type Payer struct {
+	OpenID string `json:"openid"`
+}
+
+// PrepayRequest is the body of a jsapi prepay request. AppID and MchID are
+// filled by the client.
+type PrepayRequest struct {
+	AppID       string `json:"appid"`
+	MchID       string `json:"mchid"`
+	Description string `json:"description"`
+	OutTradeNo  string `json:"out_trade_no"`
+	NotifyURL   string `json:"notify_url"`
+	Amount      Amount `json:"amount"`
+	Payer       Payer  `json:"payer"`
+}
+
+// Prepay create a jsapi transaction and returns the prepay id.
+func (c *Client) Prepay(ctx context.Context, req PrepayRequest) (string, error) {
+	req.AppID = c.cfg.AppID
+	req.MchID = c.cfg.MchID
+
+	var resp struct {
+		PrepayID string `json:"prepay_id"`
+	}
+	if err := c.do(ctx, http.MethodPost, jsapiPrepayPath, req, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.PrepayID, nil
+}
+
+// PaymentParams are the arguments of wx.requestPayment in the mini-program.
+type PaymentParams struct {
+	AppID     string `json:"appId"`
+	TimeStamp string `json:"timeStamp"`
+	NonceStr  string `json:"nonceStr"`
+	Package   string `json:"package"`
+	SignType  string `json:"signType"`
+	PaySign   string `json:"paySign"`
+}
+
+// RequestPayment signs the wx.requestPayment arguments of the prepay id.
+func (c *Client) RequestPayment(prepayID string) (*PaymentParams, error) {
+	nonce, err := NonceStr()
+	if err != nil {
+		return nil, err
+	}
+
+	params := &PaymentParams{
+		AppID:     c.cfg.AppID,
+		TimeStamp: strconv.FormatInt(time.Now().Unix(), 10),
+		NonceStr:  nonce,
+		Package:   "prepay_id=" + prepayID,
+		SignType:  "RSA",
+	}
+
+	params.PaySign, err = Sign(c.cfg.PrivateKey, params.AppID, params.TimeStamp, params.NonceStr, params.Package)
+	if err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+// RefundAmount of a refund request.
+type RefundAmount struct {
+	Refund   int64  `json:"refund"`
+	Total    int64  `json:"total"`
+	Currency string `json:"currency"`
+}
+
+// RefundRequest is the body of a refund request. The pay api deduplicates
+// requests by OutRefundNo, so retrying with the same OutRefundNo is safe.
+type RefundRequest struct {
+	TransactionID string       `json:"transaction_id,omitempty"`
+	OutTradeNo    string       `json:"out_trade_no,omitempty"`
+	OutRefundNo   string       `json:"out_refund_no"`
+	Reason        string       `json:"reason,omitempty"`
+	NotifyURL     string       `json:"notify_url,omitempty"`
+	Amount        RefundAmount `json:"amount"`
+}
+
+const (
+	RefundStatusSuccess    = "SUCCESS"
+	RefundStatusClosed     = "CLOSED"
+	RefundStatusProcessing = "PROCESSING"
+	RefundStatusAbnormal   = "ABNORMAL"
+)
+
+// Refund is the response of a refund request.
+type Refund struct {
+	RefundID      string `json:"refund_id"`
+	OutRefundNo   string `json:"out_refund_no"`
+	TransactionID string `json:"transaction_id"`
+	OutTradeNo    string `json:"out_trade_no"`
+	Status        string `json:"status"`
+}
+
+// RefundResult is the decrypted resource of a refund notification.
+type RefundResult struct {
+	MchID         string `json:"mchid"`
+	OutTradeNo    string `json:"out_trade_no"`
+	TransactionID string `json:"transaction_id"`
+	OutRefundNo   string `json:"out_refund_no"`
+	RefundID      string `json:"refund_id"`
+	RefundStatus  string `json:"refund_status"`
+	SuccessTime   string `json:"success_time"`
+	Amount        struct {
+		Total  int64 `json:"total"`
+		Refund int64 `json:"refund"`
+	} `json:"amount"`
+}
+
+// Refund requests a full or partial refund of a paid transaction.
+func (c *Client) Refund(ctx context.Context, req RefundRequest) (*Refund, error) {
+	var resp Refund
+	if err := c.do(ctx, http.MethodPost, refundPath, req, &resp); err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// Resource is the encrypted payload of a notification.
+type Resource struct {
+	Algorithm      string `json:"algorithm"`
+	Ciphertext     string `json:"ciphertext"`
+	AssociatedData string `json:"associated_data"`
+	OriginalType   string `json:"original_type"`
+	Nonce          string `json:"nonce"`
+}
+
+// Notification is the body posted to the notify url.
+type Notification struct {
+	ID           string   `json:"id"`
+	CreateTime   string   `json:"create_time"`
+	EventType    string   `json:"event_type"`
+	ResourceType string   `json:"resource_type"`
+	Summary      string   `json:"summary"`
+	Resource     Resource `json:"resource"`
+}
+
+// Transaction is the decrypted resource of a payment notification.
+type Transaction struct {
+	AppID         string `json:"appid"`
+	MchID         string `json:"mchid"`
+	OutTradeNo    string `json:"out_trade_no"`
+	TransactionID string `json:"transaction_id"`
+	TradeState    string `json:"trade_state"`
+	SuccessTime   string `json:"success_time"`
+	Amount        struct {
+		Total      int64  `json:"total"`
+		PayerTotal int64  `json:"payer_total"`
+		Currency   string `json:"currency"`
+	} `json:"amount"`
+}
+
+// TradeStateSuccess is the trade state of a paid transaction.
+const TradeStateSuccess = "SUCCESS"
+
+// CheckMerchant returns ErrMerchantMismatch unless appID and mchID, taken from
+// a decrypted notification, are the ones of the client.
+func (c *Client) CheckMerchant(appID, mchID string) error {
+	if appID != c.cfg.AppID || mchID != c.cfg.MchID {
+		return ErrMerchantMismatch
+	}
+
+	return nil
+}
+
+// ParseNotification verifies the signature of a notification request and
+// decrypts its resource into v.
+func (c *Client) ParseNotification(r *http.Request, v interface{}) (*Notification, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.verify(r.Header, body, true); err != nil {
+		return nil, err
+	}
+
+	var n Notification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, err
+	}
+
+	plain, err := c.decrypt(&n.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(plain, v); err != nil {
+		return nil, err
+	}
+
+	return &n, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.cfg.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	auth, err := c.authorization(method, path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &Error{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(buf, e)
+		return e
+	}
+
+	if err := c.verify(resp.Header, buf, false); err != nil {
+		return err
+	}
+
+	if out == nil || len(buf) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(buf, out)
+}
+
+func (c *Client) authorization(method, path string, body []byte) (string, error) {
+	nonce, err := NonceStr()
+	if err != nil {
+		return "", err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature, err := Sign(c.cfg.PrivateKey, method, path, timestamp, nonce, string(body))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`%s mchid="%s",nonce_str="%s",signature="%s",timestamp="%s",serial_no="%s"`,
+		authSchema, c.cfg.MchID, nonce, signature, timestamp, c.cfg.SerialNo), nil
+}
+
+func (c *Client) verify(header http.Header, body []byte, checkAge bool) error {
+	timestamp := header.Get(headerTimestamp)
+	nonce := header.Get(headerNonce)
+	signature := header.Get(headerSignature)
+	if timestamp == "" || nonce == "" || signature == "" {
+		return ErrInvalidSignature
+	}
+
+	if checkAge {
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return ErrInvalidSignature
+		}
+
+		if age := time.Since(time.Unix(sec, 0)); age > notifyMaxAge || age < -notifyMaxAge {
+			return ErrExpired
+		}
+	}
+
+	return Verify(c.cfg.PlatformKey, signature, timestamp, nonce, string(body))
+}
+
+func (c *Client) decrypt(r *Resource) ([]byte, error) {
+	return Decrypt([]byte(c.cfg.APIv3Key), r)
+}
+
+// Sign joins the fields with a trailing "\n" each and signs them with SHA256-RSA.
+func Sign(key *rsa.PrivateKey, fields ...string) (string, error) {
+	digest := sha256.Sum256([]byte(message(fields...)))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks a signature created by Sign.
+func Verify(key *rsa.PublicKey, signature string, fields ...string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	digest := sha256.Sum256([]byte(message(fields...)))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Encrypt seals plain with AEAD_AES_256_GCM as the pay api does for notifications.
+func Encrypt(key []byte, plain []byte, associatedData string) (*Resource, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := NonceStr()
+	if err != nil {
+		return nil, err
+	}
+	nonce = nonce[:gcm.NonceSize()]
+
+	ciphertext := gcm.Seal(nil, []byte(nonce), plain, []byte(associatedData))
+	return &Resource{
+		Algorithm:      "AEAD_AES_256_GCM",
+		Ciphertext:     base64.StdEncoding.EncodeToString(ciphertext),
+		AssociatedData: associatedData,
+		Nonce:          nonce,
+	}, nil
+}
+
+// Decrypt opens a resource sealed with AEAD_AES_256_GCM.
+func Decrypt(key []byte, r *Resource) ([]byte, error) {
+	if r.Algorithm != "AEAD_AES_256_GCM" {
+		return nil, fmt.Errorf("wxpay: unsupported algorithm %s", r.Algorithm)
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(r.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Open(nil, []byte(r.Nonce), ciphertext, []byte(r.AssociatedData))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func message(fields ...string) string {
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(f)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// NonceStr returns a random 32 characters string.
+func NonceStr() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return strings.ToUpper(hex.EncodeToString(buf)), nil
+}
+
+// LoadPrivateKey reads a PKCS#1 or PKCS#8 RSA private key from a pem file.
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidPEM
+	}
+
+	return rsaKey, nil
+}
+
+// LoadPublicKey reads a RSA public key from a pem file containing either a
+// certificate, like the pay platform certificate, or a PKIX public key.
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+
+	var key interface{}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	} else if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrInvalidPEM
+	}
+
+	return rsaKey, nil
+}
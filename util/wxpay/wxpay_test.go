@@ -0,0 +1,313 @@
+package wxpay_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dovics/wx-demo/util/wxpay"
+	"github.com/dovics/wx-demo/util/wxpay/mock"
+)
+
+const (
+	testAppID    = "wx-test"
+	testMchID    = "1900000001"
+	testAPIv3Key = "0123456789abcdef0123456789abcdef"
+)
+
+type testPay struct {
+	client      *wxpay.Client
+	mock        *mock.Server
+	apiURL      string
+	merchantKey *rsa.PrivateKey
+	platformKey *rsa.PrivateKey
+
+	// notifications are the requests posted to the notify url.
+	notifications chan *http.Request
+	notifyURL     string
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// newTestPay starts the mock pay api and a notify url receiving the
+// notifications of the mock.
+func newTestPay(t *testing.T) *testPay {
+	p := &testPay{
+		merchantKey:   generateKey(t),
+		platformKey:   generateKey(t),
+		notifications: make(chan *http.Request, 8),
+	}
+
+	p.mock = mock.New(testAppID, testMchID, testAPIv3Key, &p.merchantKey.PublicKey, p.platformKey)
+	api := httptest.NewServer(p.mock)
+	t.Cleanup(api.Close)
+	p.apiURL = api.URL
+
+	notify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		p.notifications <- r
+	}))
+	t.Cleanup(notify.Close)
+	p.notifyURL = notify.URL
+
+	var err error
+	p.client, err = wxpay.NewClient(wxpay.Config{
+		BaseURL:     api.URL,
+		AppID:       testAppID,
+		MchID:       testMchID,
+		SerialNo:    "MERCHANT",
+		APIv3Key:    testAPIv3Key,
+		PrivateKey:  p.merchantKey,
+		PlatformKey: &p.platformKey.PublicKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func (p *testPay) prepay(t *testing.T, outTradeNo string, total int64) string {
+	prepayID, err := p.client.Prepay(context.Background(), wxpay.PrepayRequest{
+		Description: "test",
+		OutTradeNo:  outTradeNo,
+		NotifyURL:   p.notifyURL,
+		Amount:      wxpay.Amount{Total: total, Currency: "CNY"},
+		Payer:       wxpay.Payer{OpenID: "openid"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prepayID
+}
+
+// pay pays the transaction and returns the notification of the mock.
+func (p *testPay) pay(t *testing.T, outTradeNo string) *http.Request {
+	if err := p.mock.Pay(outTradeNo); err != nil {
+		t.Fatal(err)
+	}
+	return <-p.notifications
+}
+
+func TestPrepay(t *testing.T) {
+	p := newTestPay(t)
+
+	prepayID := p.prepay(t, "WXO000000001000", 1990)
+	if prepayID == "" {
+		t.Fatal("empty prepay id")
+	}
+
+	if again := p.prepay(t, "WXO000000001000", 1990); again != prepayID {
+		t.Errorf("prepay again = %s, want %s", again, prepayID)
+	}
+
+	params, err := p.client.RequestPayment(prepayID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Package != "prepay_id="+prepayID {
+		t.Errorf("package = %s", params.Package)
+	}
+	if err := wxpay.Verify(&p.merchantKey.PublicKey, params.PaySign, params.AppID, params.TimeStamp,
+		params.NonceStr, params.Package); err != nil {
+		t.Errorf("pay sign: %v", err)
+	}
+
+	if _, err := wxpay.NewClient(wxpay.Config{}); err == nil {
+		t.Error("client without config created")
+	}
+}
+
+func TestPrepaySignature(t *testing.T) {
+	p := newTestPay(t)
+
+	// signed by another merchant key
+	client, err := wxpay.NewClient(wxpay.Config{
+		BaseURL:     p.apiURL,
+		AppID:       testAppID,
+		MchID:       testMchID,
+		SerialNo:    "MERCHANT",
+		APIv3Key:    testAPIv3Key,
+		PrivateKey:  generateKey(t),
+		PlatformKey: &p.platformKey.PublicKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := wxpay.PrepayRequest{
+		OutTradeNo: "WXO000000001000",
+		Amount:     wxpay.Amount{Total: 1},
+		Payer:      wxpay.Payer{OpenID: "openid"},
+	}
+	var e *wxpay.Error
+	if _, err := client.Prepay(context.Background(), req); !errors.As(err, &e) ||
+		e.StatusCode != http.StatusUnauthorized {
+		t.Errorf("other merchant key error = %v, want status 401", err)
+	}
+
+	// answered by another platform key
+	client, err = wxpay.NewClient(wxpay.Config{
+		BaseURL:     p.apiURL,
+		AppID:       testAppID,
+		MchID:       testMchID,
+		SerialNo:    "MERCHANT",
+		APIv3Key:    testAPIv3Key,
+		PrivateKey:  p.merchantKey,
+		PlatformKey: &generateKey(t).PublicKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Prepay(context.Background(), req); err != wxpay.ErrInvalidSignature {
+		t.Errorf("other platform key error = %v, want %v", err, wxpay.ErrInvalidSignature)
+	}
+}
+
+func TestParseNotification(t *testing.T) {
+	p := newTestPay(t)
+
+	p.prepay(t, "WXO000000001000", 1990)
+	notification := p.pay(t, "WXO000000001000")
+	body, _ := ioutil.ReadAll(notification.Body)
+
+	parse := func(header http.Header, body []byte) (*wxpay.Transaction, error) {
+		r := httptest.NewRequest(http.MethodPost, "/notify", bytes.NewReader(body))
+		r.Header = header
+		var transaction wxpay.Transaction
+		_, err := p.client.ParseNotification(r, &transaction)
+		return &transaction, err
+	}
+
+	transaction, err := parse(notification.Header, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transaction.OutTradeNo != "WXO000000001000" || transaction.TradeState != wxpay.TradeStateSuccess ||
+		transaction.Amount.Total != 1990 || transaction.TransactionID == "" {
+		t.Errorf("transaction = %+v", transaction)
+	}
+
+	tampered := bytes.Replace(body, []byte(`"TRANSACTION.SUCCESS"`), []byte(`"TRANSACTION.FAILED"`), 1)
+	if _, err := parse(notification.Header, tampered); err != wxpay.ErrInvalidSignature {
+		t.Errorf("tampered body error = %v, want %v", err, wxpay.ErrInvalidSignature)
+	}
+
+	unsigned := notification.Header.Clone()
+	unsigned.Del("Wechatpay-Signature")
+	if _, err := parse(unsigned, body); err != wxpay.ErrInvalidSignature {
+		t.Errorf("unsigned error = %v, want %v", err, wxpay.ErrInvalidSignature)
+	}
+
+	// a notification signed an hour ago is a replay
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	nonce := notification.Header.Get("Wechatpay-Nonce")
+	signature, err := wxpay.Sign(p.platformKey, old, nonce, string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := notification.Header.Clone()
+	expired.Set("Wechatpay-Timestamp", old)
+	expired.Set("Wechatpay-Signature", signature)
+	if _, err := parse(expired, body); err != wxpay.ErrExpired {
+		t.Errorf("old notification error = %v, want %v", err, wxpay.ErrExpired)
+	}
+
+	// a notification of another platform key
+	forged := notification.Header.Clone()
+	signature, err = wxpay.Sign(generateKey(t), forged.Get("Wechatpay-Timestamp"), nonce, string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.Set("Wechatpay-Signature", signature)
+	if _, err := parse(forged, body); err != wxpay.ErrInvalidSignature {
+		t.Errorf("forged notification error = %v, want %v", err, wxpay.ErrInvalidSignature)
+	}
+}
+
+func TestRefundAmount(t *testing.T) {
+	p := newTestPay(t)
+
+	refund := func(outRefundNo string, amount, total int64) (*wxpay.Refund, error) {
+		return p.client.Refund(context.Background(), wxpay.RefundRequest{
+			OutTradeNo:  "WXO000000001000",
+			OutRefundNo: outRefundNo,
+			NotifyURL:   p.notifyURL,
+			Amount:      wxpay.RefundAmount{Refund: amount, Total: total, Currency: "CNY"},
+		})
+	}
+
+	status := func(err error) int {
+		var e *wxpay.Error
+		if !errors.As(err, &e) {
+			return 0
+		}
+		return e.StatusCode
+	}
+
+	p.prepay(t, "WXO000000001000", 1000)
+	if _, err := refund("WXR000000001000001", 400, 1000); status(err) != http.StatusNotFound {
+		t.Errorf("refund before paid error = %v, want status 404", err)
+	}
+
+	p.pay(t, "WXO000000001000")
+
+	r, err := refund("WXR000000001000001", 400, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != wxpay.RefundStatusProcessing {
+		t.Errorf("refund status = %s, want %s", r.Status, wxpay.RefundStatusProcessing)
+	}
+
+	if again, err := refund("WXR000000001000001", 400, 1000); err != nil || again.RefundID != r.RefundID {
+		t.Errorf("retried refund = %+v, %v, want %s", again, err, r.RefundID)
+	}
+
+	for name, c := range map[string]struct {
+		outRefundNo   string
+		amount, total int64
+		status        int
+	}{
+		"other amount of a refund no": {"WXR000000001000001", 500, 1000, http.StatusBadRequest},
+		"more than paid":              {"WXR000000001000002", 700, 1000, http.StatusForbidden},
+		"wrong total":                 {"WXR000000001000002", 100, 2000, http.StatusForbidden},
+	} {
+		if _, err := refund(c.outRefundNo, c.amount, c.total); status(err) != c.status {
+			t.Errorf("%s: error = %v, want status %d", name, err, c.status)
+		}
+	}
+
+	if _, err := refund("WXR000000001000002", 600, 1000); err != nil {
+		t.Errorf("refund of the rest: %v", err)
+	}
+
+	if err := p.mock.CompleteRefund("WXR000000001000002"); err != nil {
+		t.Fatal(err)
+	}
+	notification := <-p.notifications
+
+	var result wxpay.RefundResult
+	if _, err := p.client.ParseNotification(notification, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.RefundStatus != wxpay.RefundStatusSuccess || result.Amount.Refund != 600 {
+		t.Errorf("refund result = %+v", result)
+	}
+}